@@ -0,0 +1,527 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/team-telnyx/telnyx-mock/generator/datareplacer"
+	"github.com/team-telnyx/telnyx-mock/spec"
+)
+
+//
+// Private values
+//
+
+// adminPathPrefix is the path under which telnyx-mock serves the admin API,
+// which controls the mock itself. The Telnyx API doesn't have any paths
+// under it, so it never shadows a real route.
+const adminPathPrefix = "/_mock/"
+
+const (
+	clockDisabled       = "The server's clock follows real time and can't be controlled."
+	invalidAdminBody    = "Couldn't parse request body: %v"
+	invalidClockAdvance = "Invalid advance: '%s'. Expected a duration that isn't negative, like `90s` or `24h`."
+	invalidWebhookURL   = "Invalid url: '%s'. Expected a URL like `http://localhost:8080/webhooks`."
+	journalDisabled     = "The request journal is disabled. Start telnyx-mock " +
+		"with a positive -journal-size to enable it."
+	deliveryLogDisabled = "The webhook delivery log is disabled. Start " +
+		"telnyx-mock with a positive -journal-size to enable it."
+	webhooksDisabled    = "Webhooks are disabled."
+	webhookMissingURL   = "`url` is required when telnyx-mock isn't started with a -webhook-url."
+	webhookNotDelivered = "Couldn't deliver webhook to %s: %v"
+)
+
+//
+// Private types
+//
+
+// adminClock is the body of a request to the admin API's `clock` endpoint,
+// which controls the server's clock. Each of its fields is optional, and
+// they're applied in order: the clock is frozen, set, advanced, and then
+// unfrozen.
+type adminClock struct {
+	// Frozen is whether the clock is stopped. Freezing it before setting or
+	// advancing it keeps it at exactly the time that it was moved to.
+	Frozen *bool `json:"frozen,omitempty"`
+
+	// Now is the time to set the clock to, like `2020-01-01T12:00:00Z`.
+	Now *time.Time `json:"now,omitempty"`
+
+	// Advance is how far to move the clock forward, like `90s` or `24h`.
+	// Anything scheduled to happen by then, like a delivery report, happens
+	// before the request returns.
+	Advance string `json:"advance,omitempty"`
+}
+
+// adminRoute describes a route of the spec in the response of the admin
+// API's `routes` endpoint.
+type adminRoute struct {
+	Method           string   `json:"method"`
+	Path             string   `json:"path"`
+	OperationID      string   `json:"operation_id,omitempty"`
+	ExpectedWebhooks []string `json:"expected_webhooks,omitempty"`
+}
+
+// adminWebhook is the body of a request to the admin API's `webhooks/send`
+// endpoint, which signs a payload and delivers it to a URL.
+type adminWebhook struct {
+	URL     string          `json:"url"`
+	Payload json.RawMessage `json:"payload"`
+
+	// Timestamp is the Unix time that the webhook is signed as of, which
+	// defaults to the current time. Pick one far enough in the past to check
+	// that a receiver rejects stale webhooks.
+	Timestamp *int64 `json:"timestamp,omitempty"`
+}
+
+// adminWebhookTrigger is the body of a request to the admin API's
+// `webhooks/trigger` endpoint, which generates an event of the given type and
+// delivers it like one caused by an API request would be.
+type adminWebhookTrigger struct {
+	EventType string `json:"event_type"`
+
+	// URL and FailoverURL are where the event is delivered, which default to
+	// the server's default webhook URLs.
+	URL         string `json:"url,omitempty"`
+	FailoverURL string `json:"failover_url,omitempty"`
+
+	// Data holds values to override in the generated event, which is the
+	// `data` of the webhook. They're merged in like request parameters are
+	// merged into responses, so only values that are in the generated event
+	// and of the same type are replaced, except that the payload of an event
+	// that the spec doesn't describe is taken as given.
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+//
+// Private functions
+//
+
+// handleAdminRequest handles a request to the admin API:
+//
+//	POST   /_mock/reset                Forgets all state and restores initial fixtures
+//	GET    /_mock/clock                Gets the server's current time
+//	POST   /_mock/clock                Freezes, sets, or advances the server's clock
+//	DELETE /_mock/clock                Returns the server's clock to real time
+//	GET    /_mock/routes               Lists the routes that requests can be made to
+//	GET    /_mock/fixtures             Gets the fixtures responses are generated from
+//	PUT    /_mock/fixtures             Replaces the fixtures
+//	POST   /_mock/fixtures             Adds to or overrides individual fixtures
+//	GET    /_mock/requests             Lists journaled requests, optionally filtered
+//	DELETE /_mock/requests             Clears the journal
+//	GET    /_mock/scenarios            Lists the configured scenarios
+//	POST   /_mock/scenarios            Configures a scenario
+//	DELETE /_mock/scenarios            Removes every configured scenario
+//	GET    /_mock/webhooks/deliveries  Lists attempts to deliver webhooks
+//	DELETE /_mock/webhooks/deliveries  Clears the webhook delivery log
+//	GET    /_mock/webhooks/public_key  Gets the key that webhooks are signed with
+//	POST   /_mock/webhooks/send        Signs and delivers a webhook payload
+//	POST   /_mock/webhooks/trigger     Generates and delivers an event
+func (s *StubServer) handleAdminRequest(w http.ResponseWriter, r *http.Request, start time.Time) {
+	endpoint := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, adminPathPrefix), "/")
+
+	switch endpoint + " " + r.Method {
+	case "reset " + http.MethodPost:
+		s.reset()
+		writeResponse(w, r, start, http.StatusOK, adminData(map[string]interface{}{
+			"reset": true,
+		}))
+
+	case "clock " + http.MethodGet, "clock " + http.MethodPost, "clock " + http.MethodDelete:
+		if s.clock == nil {
+			telnyxError := createTelnyxError(errorCodeBadRequest, clockDisabled)
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			var control adminClock
+			if telnyxError := decodeAdminBody(r, &control); telnyxError != nil {
+				writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+				return
+			}
+
+			if telnyxError := s.controlClock(&control); telnyxError != nil {
+				writeResponse(w, r, start, http.StatusUnprocessableEntity, telnyxError)
+				return
+			}
+
+		case http.MethodDelete:
+			s.clock.Reset()
+		}
+
+		writeResponse(w, r, start, http.StatusOK, adminData(map[string]interface{}{
+			"frozen": s.clock.Frozen(),
+			"now":    formatTimestamp(s.clock.Now()),
+		}))
+
+	case "routes " + http.MethodGet:
+		writeResponse(w, r, start, http.StatusOK, adminData(s.adminRoutes()))
+
+	case "fixtures " + http.MethodGet:
+		writeResponse(w, r, start, http.StatusOK, adminData(s.currentFixtures()))
+
+	case "fixtures " + http.MethodPost, "fixtures " + http.MethodPut:
+		var fixtures spec.Fixtures
+		if telnyxError := decodeAdminBody(r, &fixtures); telnyxError != nil {
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+
+		writeResponse(w, r, start, http.StatusOK,
+			adminData(s.loadFixtures(&fixtures, r.Method == http.MethodPut)))
+
+	case "requests " + http.MethodGet, "requests " + http.MethodDelete:
+		if s.journal == nil {
+			telnyxError := createTelnyxError(errorCodeBadRequest, journalDisabled)
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			s.journal.Reset()
+			writeResponse(w, r, start, http.StatusOK, adminData(s.journal.List(nil)))
+			return
+		}
+
+		filter, telnyxError := parseJournalFilter(r.URL.Query())
+		if telnyxError != nil {
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+
+		entries := s.journal.List(filter)
+		writeResponse(w, r, start, http.StatusOK, map[string]interface{}{
+			"data": entries,
+			"meta": map[string]interface{}{"total_results": len(entries)},
+		})
+
+	case "scenarios " + http.MethodGet:
+		writeResponse(w, r, start, http.StatusOK, adminData(s.scenarios.List()))
+
+	case "scenarios " + http.MethodPost:
+		var rule ScenarioRule
+		if telnyxError := decodeAdminBody(r, &rule); telnyxError != nil {
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+
+		if telnyxError := s.scenarios.Add(&rule); telnyxError != nil {
+			writeResponse(w, r, start, http.StatusUnprocessableEntity, telnyxError)
+			return
+		}
+
+		writeResponse(w, r, start, http.StatusOK, adminData(s.scenarios.List()))
+
+	case "scenarios " + http.MethodDelete:
+		s.scenarios.Reset()
+		writeResponse(w, r, start, http.StatusOK, adminData(s.scenarios.List()))
+
+	case "webhooks/deliveries " + http.MethodGet, "webhooks/deliveries " + http.MethodDelete:
+		if s.webhooks == nil || s.webhooks.Log == nil {
+			telnyxError := createTelnyxError(errorCodeBadRequest, deliveryLogDisabled)
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			s.webhooks.Log.Reset()
+		}
+
+		query := r.URL.Query()
+		attempts := s.webhooks.Log.List(query.Get("event_type"), query.Get("event_id"))
+		writeResponse(w, r, start, http.StatusOK, map[string]interface{}{
+			"data": attempts,
+			"meta": map[string]interface{}{"total_results": len(attempts)},
+		})
+
+	case "webhooks/public_key " + http.MethodGet:
+		if s.webhooks == nil {
+			telnyxError := createTelnyxError(errorCodeBadRequest, webhooksDisabled)
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+
+		publicKey := s.webhooks.Sender.Signer.PublicKey()
+		writeResponse(w, r, start, http.StatusOK, adminData(map[string]interface{}{
+			"public_key": base64.StdEncoding.EncodeToString(publicKey),
+		}))
+
+	case "webhooks/send " + http.MethodPost:
+		if s.webhooks == nil {
+			telnyxError := createTelnyxError(errorCodeBadRequest, webhooksDisabled)
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+
+		var delivery adminWebhook
+		if telnyxError := decodeAdminBody(r, &delivery); telnyxError != nil {
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+
+		status, data, telnyxError := s.sendAdminWebhook(&delivery)
+		if telnyxError != nil {
+			writeResponse(w, r, start, status, telnyxError)
+			return
+		}
+		writeResponse(w, r, start, http.StatusOK, adminData(data))
+
+	case "webhooks/trigger " + http.MethodPost:
+		if s.webhooks == nil {
+			telnyxError := createTelnyxError(errorCodeBadRequest, webhooksDisabled)
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+
+		var trigger adminWebhookTrigger
+		if telnyxError := decodeAdminBody(r, &trigger); telnyxError != nil {
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+
+		status, data, telnyxError := s.triggerAdminWebhook(&trigger)
+		if telnyxError != nil {
+			writeResponse(w, r, start, status, telnyxError)
+			return
+		}
+		writeResponse(w, r, start, http.StatusAccepted, adminData(data))
+
+	default:
+		message := fmt.Sprintf(invalidRoute, r.Method, r.URL.Path)
+		telnyxError := createTelnyxError(errorCodeResourceNotFound, message)
+		writeResponse(w, r, start, http.StatusNotFound, telnyxError)
+	}
+}
+
+// adminRoutes lists the server's routes sorted by path and method.
+func (s *StubServer) adminRoutes() []adminRoute {
+	routes := make([]adminRoute, 0)
+	for verb, verbRoutes := range s.routes {
+		for _, route := range verbRoutes {
+			routes = append(routes, adminRoute{
+				Method:           string(verb),
+				Path:             "/v2" + string(route.path),
+				OperationID:      route.operation.OperationID,
+				ExpectedWebhooks: route.expectedWebhooks,
+			})
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	return routes
+}
+
+// controlClock freezes, sets, advances, or unfreezes the server's clock as
+// requested through the admin API. It returns an error without touching the
+// clock if the request is invalid.
+func (s *StubServer) controlClock(control *adminClock) *ResponseError {
+	var advance time.Duration
+	if control.Advance != "" {
+		var err error
+		advance, err = time.ParseDuration(control.Advance)
+		if err != nil || advance < 0 {
+			return createTelnyxErrorWithSource(errorCodeBadRequest,
+				fmt.Sprintf(invalidClockAdvance, control.Advance),
+				&ResponseErrorSource{Pointer: "/advance"})
+		}
+	}
+
+	if control.Frozen != nil && *control.Frozen {
+		s.clock.Freeze()
+	}
+	if control.Now != nil {
+		s.clock.Set(*control.Now)
+	}
+	if advance > 0 {
+		s.clock.Advance(advance)
+	}
+	if control.Frozen != nil && !*control.Frozen {
+		s.clock.Unfreeze()
+	}
+	return nil
+}
+
+// loadFixtures installs new fixtures and returns them. If replace is false,
+// the new fixtures are added to the current ones instead, taking precedence
+// over those of the same resource.
+func (s *StubServer) loadFixtures(fixtures *spec.Fixtures, replace bool) *spec.Fixtures {
+	s.fixturesMu.Lock()
+	defer s.fixturesMu.Unlock()
+
+	// Fixtures are read without holding the lock once they've been
+	// retrieved, so the current ones are copied rather than modified.
+	loaded := &spec.Fixtures{Resources: make(map[spec.ResourceID]interface{})}
+	if !replace && s.fixtures != nil {
+		for id, resource := range s.fixtures.Resources {
+			loaded.Resources[id] = resource
+		}
+	}
+	for id, resource := range fixtures.Resources {
+		loaded.Resources[id] = resource
+	}
+
+	s.fixtures = loaded
+	return loaded
+}
+
+// reset returns the server to the state it started in: every stored
+// resource, call, scenario, journaled request, and webhook delivery attempt
+// is forgotten, fixtures loaded through the admin API are replaced by the
+// initial ones, and the clock goes back to real time.
+func (s *StubServer) reset() {
+	s.fixturesMu.Lock()
+	s.fixtures = s.initialFixtures
+	s.fixturesMu.Unlock()
+
+	s.scenarios.Reset()
+	atomic.StoreUint64(&s.created, 0)
+
+	if s.clock != nil {
+		s.clock.Reset()
+	}
+
+	if s.store != nil {
+		s.store.Reset()
+	}
+	if s.calls != nil {
+		s.calls.Reset()
+	}
+	if s.messages != nil {
+		s.messages.Reset()
+	}
+	if s.numberOrders != nil {
+		s.numberOrders.Reset()
+	}
+	if s.reservations != nil {
+		s.reservations.Reset()
+	}
+	if s.portouts != nil {
+		s.portouts.Reset()
+	}
+	if s.journal != nil {
+		s.journal.Reset()
+	}
+	if s.webhooks != nil {
+		s.webhooks.Reset()
+		if s.webhooks.Log != nil {
+			s.webhooks.Log.Reset()
+		}
+	}
+}
+
+// sendAdminWebhook signs and delivers a webhook requested through the admin
+// API. It returns a description of the delivery, or an error and its status
+// code if the webhook couldn't be delivered.
+func (s *StubServer) sendAdminWebhook(delivery *adminWebhook) (int, map[string]interface{}, *ResponseError) {
+	if !isURL(delivery.URL) {
+		return http.StatusUnprocessableEntity, nil, createTelnyxErrorWithSource(errorCodeBadRequest,
+			fmt.Sprintf(invalidWebhookURL, delivery.URL),
+			&ResponseErrorSource{Pointer: "/url"})
+	}
+	if len(delivery.Payload) == 0 {
+		return http.StatusUnprocessableEntity, nil, createTelnyxErrorWithSource(errorCodeMissingParameter,
+			"`payload` is required.", &ResponseErrorSource{Pointer: "/payload"})
+	}
+
+	timestamp := time.Now()
+	if delivery.Timestamp != nil {
+		timestamp = time.Unix(*delivery.Timestamp, 0)
+	}
+
+	// The payload is sent exactly as it was given, so that it's what the
+	// signature is over.
+	sender := s.webhooks.Sender
+	status, err := sender.SendAt(delivery.URL, delivery.Payload, timestamp)
+	if err != nil {
+		return http.StatusBadGateway, nil, createTelnyxError(errorCodeUnexpectedError,
+			fmt.Sprintf(webhookNotDelivered, delivery.URL, err))
+	}
+
+	return 0, map[string]interface{}{
+		"url":       delivery.URL,
+		"status":    status,
+		"signature": sender.Signer.Sign(delivery.Payload, timestamp),
+		"timestamp": timestamp.Unix(),
+	}, nil
+}
+
+// triggerAdminWebhook generates an event requested through the admin API and
+// sends it off for delivery. It returns the event, or an error and its status
+// code if the event couldn't be generated.
+func (s *StubServer) triggerAdminWebhook(trigger *adminWebhookTrigger) (int, map[string]interface{}, *ResponseError) {
+	if trigger.EventType == "" {
+		return http.StatusUnprocessableEntity, nil, createTelnyxErrorWithSource(errorCodeMissingParameter,
+			"`event_type` is required.", &ResponseErrorSource{Pointer: "/event_type"})
+	}
+
+	url, failoverURL := s.webhookURLs(map[string]interface{}{
+		"webhook_url":          trigger.URL,
+		"webhook_failover_url": trigger.FailoverURL,
+	})
+	if url == "" {
+		return http.StatusUnprocessableEntity, nil, createTelnyxErrorWithSource(errorCodeMissingParameter,
+			webhookMissingURL, &ResponseErrorSource{Pointer: "/url"})
+	}
+	if !isURL(url) {
+		return http.StatusUnprocessableEntity, nil, createTelnyxErrorWithSource(errorCodeBadRequest,
+			fmt.Sprintf(invalidWebhookURL, url), &ResponseErrorSource{Pointer: "/url"})
+	}
+	if failoverURL != "" && !isURL(failoverURL) {
+		return http.StatusUnprocessableEntity, nil, createTelnyxErrorWithSource(errorCodeBadRequest,
+			fmt.Sprintf(invalidWebhookURL, failoverURL), &ResponseErrorSource{Pointer: "/failover_url"})
+	}
+
+	event, err := s.generateEvent(trigger.EventType, nil)
+	if err != nil {
+		fmt.Printf("Couldn't generate %s webhook: %v\n", trigger.EventType, err)
+		return http.StatusInternalServerError, nil, createInternalServerError()
+	}
+
+	// There's nothing to merge the payload of an event that the spec doesn't
+	// describe into, so it's used as is.
+	if _, ok := s.eventSchemas[trigger.EventType]; !ok {
+		if payload, ok := trigger.Data["payload"].(map[string]interface{}); ok {
+			event["payload"] = payload
+		}
+	}
+	event = datareplacer.ReplaceData(trigger.Data, event)
+
+	// Delivery is retried and fails over like it is for any other webhook,
+	// which can take a while, so it's left to carry on in the background.
+	// Its attempts can be found in the delivery log by the event's ID.
+	go s.webhooks.Emit(url, failoverURL, []map[string]interface{}{event})
+
+	return 0, map[string]interface{}{"event": event}, nil
+}
+
+// adminData wraps the data of an admin API response in the same envelope as
+// the Telnyx API's responses.
+func adminData(data interface{}) map[string]interface{} {
+	return map[string]interface{}{"data": data}
+}
+
+// decodeAdminBody decodes the JSON body of a request to the admin API.
+func decodeAdminBody(r *http.Request, v interface{}) *ResponseError {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return createTelnyxError(errorCodeBadRequest, fmt.Sprintf(invalidAdminBody, err))
+	}
+
+	return nil
+}
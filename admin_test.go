@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/team-telnyx/telnyx-mock/spec"
+	"github.com/team-telnyx/telnyx-mock/webhook"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_AdminReset(t *testing.T) {
+	server := getStatefulStubServer(t)
+	server.store.Put(map[string]interface{}{
+		"id": "1", "record_type": "messaging_profile",
+	})
+	server.calls.Dial("call_123")
+	server.scenarios.Add(&ScenarioRule{Scenario: "server_error"})
+
+	// The admin API doesn't require authorization.
+	resp, _ := sendRequestToServer(t, server, "POST", "/_mock/reset", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, 0, len(server.store.List("messaging_profile")))
+	_, _, ok := server.calls.Status("call_123")
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(server.scenarios.List()))
+}
+
+func TestStubServer_AdminResetCancelsTimers(t *testing.T) {
+	var received int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	signer, err := webhook.GenerateSigner()
+	assert.NoError(t, err)
+
+	server := getStatefulStubServer(t)
+	server.webhooks = &WebhookEmitter{
+		Log:          NewDeliveryLog(defaultJournalSize),
+		Retries:      1,
+		RetryBackoff: time.Minute,
+		Sender:       &webhook.Sender{Signer: signer},
+	}
+	clock := NewClock()
+	clock.Freeze()
+	server.setClock(clock)
+
+	// An order waits to be fulfilled, and a webhook waits to be retried.
+	resp, _ := sendRequestToServer(t, server, "POST", "/v2/number_orders",
+		`{"phone_numbers": [{"phone_number": "+19705555098"}], "webhook_url": "`+receiver.URL+`"}`,
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/_mock/webhooks/trigger",
+		`{"event_type": "call.initiated", "url": "`+receiver.URL+`"}`, nil)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	for deadline := time.Now().Add(5 * time.Second); len(server.webhooks.Log.List("", "")) < 1; {
+		assert.True(t, time.Now().Before(deadline), "Timed out waiting for delivery log")
+		time.Sleep(time.Millisecond)
+	}
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/_mock/reset", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Neither happens once the clock passes when they were due.
+	clock.Advance(time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+	assert.Equal(t, 0, len(server.webhooks.Log.List("", "")))
+}
+
+func TestStubServer_AdminClock(t *testing.T) {
+	events := make(chan map[string]interface{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+
+		events <- body["data"].(map[string]interface{})
+	}))
+	defer receiver.Close()
+
+	signer, err := webhook.GenerateSigner()
+	assert.NoError(t, err)
+
+	server := getStatefulStubServer(t)
+	server.webhooks = &WebhookEmitter{Sender: &webhook.Sender{Signer: signer}}
+	server.setClock(NewClock())
+
+	send := func(method, path, body string) map[string]interface{} {
+		resp, respBody := sendRequestToServer(t, server, method, path, body, getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode, string(respBody))
+
+		var data map[string]interface{}
+		err := json.Unmarshal(respBody, &data)
+		assert.NoError(t, err)
+		return data["data"].(map[string]interface{})
+	}
+
+	clock := send("POST", "/_mock/clock", `{"frozen": true, "now": "2020-01-01T12:00:00Z"}`)
+	assert.Equal(t, true, clock["frozen"])
+	assert.Equal(t, "2020-01-01T12:00:00Z", clock["now"])
+
+	// Resources are stamped with the time on the clock.
+	profile := send("POST", "/v2/messaging_profiles", `{"name": "Test"}`)
+	assert.Equal(t, "2020-01-01T12:00:00Z", profile["created_at"])
+	assert.Equal(t, "2020-01-01T12:00:00Z", profile["updated_at"])
+
+	clock = send("POST", "/_mock/clock", `{"advance": "1h"}`)
+	assert.Equal(t, "2020-01-01T13:00:00Z", clock["now"])
+
+	profile = send("PATCH", "/v2/messaging_profiles/"+profile["id"].(string), `{"name": "Renamed"}`)
+	assert.Equal(t, "2020-01-01T12:00:00Z", profile["created_at"])
+	assert.Equal(t, "2020-01-01T13:00:00Z", profile["updated_at"])
+
+	// Advancing the clock makes reports that come due before it returns, and
+	// delivers them in the background.
+	send("POST", "/v2/messages", `{"to": "+13125550001", "text": "Hello", "webhook_url": "`+receiver.URL+`"}`)
+	assert.Empty(t, events)
+
+	send("POST", "/_mock/clock", `{"advance": "1s"}`)
+	select {
+	case event := <-events:
+		assert.Equal(t, messageSentEvent, event["event_type"])
+		assert.Equal(t, "2020-01-01T13:00:01Z", event["occurred_at"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook wasn't delivered after advancing the clock")
+	}
+
+	resp, _ := sendRequestToServer(t, server, "POST", "/_mock/clock", `{"advance": "-1s"}`, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	clock = send("DELETE", "/_mock/clock", "")
+	assert.Equal(t, false, clock["frozen"])
+	assert.NotEqual(t, "2020-01-01T13:00:01Z", clock["now"])
+
+	// Without a clock, the server follows real time.
+	resp, _ = sendRequestToServer(t, getStubServer(t), "GET", "/_mock/clock", "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStubServer_AdminRoutes(t *testing.T) {
+	server := getStubServer(t)
+
+	resp, body := sendRequestToServer(t, server, "GET", "/_mock/routes", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data struct {
+		Data []adminRoute `json:"data"`
+	}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 7, len(data.Data))
+	assert.Equal(t, adminRoute{Method: "GET", Path: "/v2/charges"}, data.Data[2])
+	assert.Equal(t, adminRoute{Method: "POST", Path: "/v2/charges"}, data.Data[3])
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/_mock/routes", "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestStubServer_AdminFixtures(t *testing.T) {
+	// Fixtures are only used for resources without an example, which none of
+	// the test spec's have.
+	server := &StubServer{
+		spec: &spec.Spec{
+			Components: spec.Components{
+				Schemas: map[string]*spec.Schema{
+					"charge": {
+						Type: spec.TypeObject,
+						Properties: map[string]*spec.Schema{
+							"customer": {Type: spec.TypeString},
+							"id":       {Type: spec.TypeString},
+						},
+						XResourceID: "charge",
+					},
+				},
+			},
+			Paths: map[spec.Path]map[spec.HTTPVerb]*spec.Operation{
+				"/charges/{id}": {
+					"get": {
+						Responses: map[spec.StatusCode]spec.Response{
+							"200": {
+								Content: map[string]spec.MediaType{
+									"application/json": {
+										Schema: &spec.Schema{
+											Properties: map[string]*spec.Schema{
+												"data": {Ref: "#/components/schemas/charge"},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		fixtures: &testFixtures,
+	}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	getCustomer := func() string {
+		resp, body := sendRequestToServer(t, server, "GET", "/v2/charges/ch_123",
+			"", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+		return data["data"].(map[string]interface{})["customer"].(string)
+	}
+
+	getResources := func(body []byte) map[string]interface{} {
+		var data map[string]interface{}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+		return data["data"].(map[string]interface{})["resources"].(map[string]interface{})
+	}
+
+	assert.Equal(t, "cus_123", getCustomer())
+
+	// Loaded fixtures take precedence over existing ones.
+	resp, body := sendRequestToServer(t, server, "POST", "/_mock/fixtures",
+		`{"resources": {"charge": {"id": "ch_123", "customer": "cus_456"}}}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 3, len(getResources(body)))
+	assert.Equal(t, "cus_456", getCustomer())
+
+	// Replaced fixtures don't keep any of the existing ones.
+	resp, body = sendRequestToServer(t, server, "PUT", "/_mock/fixtures",
+		`{"resources": {"charge": {"id": "ch_123", "customer": "cus_789"}}}`, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, len(getResources(body)))
+	assert.Equal(t, "cus_789", getCustomer())
+
+	resp, body = sendRequestToServer(t, server, "GET", "/_mock/fixtures", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, len(getResources(body)))
+
+	// The initial fixtures come back on reset.
+	resp, _ = sendRequestToServer(t, server, "POST", "/_mock/reset", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "cus_123", getCustomer())
+
+	resp, _ = sendRequestToServer(t, server, "PUT", "/_mock/fixtures", `{"charge": {}}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStubServer_AdminScenarios(t *testing.T) {
+	server := getStubServer(t)
+
+	addScenario := func(body string) int {
+		resp, _ := sendRequestToServer(t, server, "POST", "/_mock/scenarios", body, nil)
+		return resp.StatusCode
+	}
+
+	createCharge := func(headers map[string]string) int {
+		resp, _ := sendRequestToServer(t, server, "POST", "/v2/charges",
+			`{"amount": 123}`, headers)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK,
+		addScenario(`{"method": "POST", "path": "/v2/charges", "scenario": "rate_limited", "count": 2}`))
+
+	assert.Equal(t, http.StatusTooManyRequests, createCharge(getDefaultHeaders()))
+
+	// Headers take precedence over configured scenarios, and don't use them
+	// up.
+	headers := getDefaultHeaders()
+	headers[headerResponseStatus] = "404"
+	assert.Equal(t, http.StatusNotFound, createCharge(headers))
+
+	assert.Equal(t, http.StatusTooManyRequests, createCharge(getDefaultHeaders()))
+	assert.Equal(t, http.StatusOK, createCharge(getDefaultHeaders()))
+
+	// Scenarios can match on the path of an operation in the spec.
+	assert.Equal(t, http.StatusOK,
+		addScenario(`{"path": "/customers/{id}", "status": 503}`))
+
+	deleteCustomer := func() int {
+		resp, _ := sendRequestToServer(t, server, "DELETE", "/v2/customers/cus_123",
+			"", getDefaultHeaders())
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, deleteCustomer())
+
+	resp, body := sendRequestToServer(t, server, "GET", "/_mock/scenarios", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data struct {
+		Data []ScenarioRule `json:"data"`
+	}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	assert.Equal(t, []ScenarioRule{{Path: "/customers/{id}", Status: 503}}, data.Data)
+
+	resp, _ = sendRequestToServer(t, server, "DELETE", "/_mock/scenarios", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, http.StatusOK, deleteCustomer())
+
+	assert.Equal(t, http.StatusUnprocessableEntity, addScenario(`{"scenario": "bogus"}`))
+	assert.Equal(t, http.StatusUnprocessableEntity, addScenario(`{"status": 42}`))
+	assert.Equal(t, http.StatusUnprocessableEntity,
+		addScenario(`{"scenario": "not_found", "status": 404}`))
+	assert.Equal(t, http.StatusBadRequest, addScenario(`{"bogus": true}`))
+}
+
+//
+// Tests for private functions
+//
+
+func TestScenarioRuleMatches(t *testing.T) {
+	rule := &ScenarioRule{Method: "post", Path: "/v2/calls/{call_control_id}/actions/answer"}
+	assert.True(t, rule.matches("POST", "/v2/calls/123/actions/answer",
+		"/calls/{call_control_id}/actions/answer"))
+	assert.False(t, rule.matches("GET", "/v2/calls/123/actions/answer",
+		"/calls/{call_control_id}/actions/answer"))
+
+	rule = &ScenarioRule{Path: "/calls/123"}
+	assert.True(t, rule.matches("GET", "/v2/calls/123", "/calls/{call_control_id}"))
+	assert.False(t, rule.matches("GET", "/v2/calls/456", "/calls/{call_control_id}"))
+
+	rule = &ScenarioRule{}
+	assert.True(t, rule.matches("DELETE", "/v2/calls/456", "/calls/{call_control_id}"))
+}
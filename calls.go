@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// CallRegistry tracks the state of calls made through Call Control so that
+// commands are only accepted for calls in a state where the live API would
+// accept them.
+//
+// A call is initiated when it's dialed, and starts ringing once RingingDelay
+// has passed unless a command has moved it on before then.
+//
+// It's safe for concurrent use.
+type CallRegistry struct {
+	mu    sync.Mutex
+	calls map[string]*call
+
+	// RingingDelay is how long a call is initiated for before it starts
+	// ringing.
+	RingingDelay time.Duration
+
+	// now returns the current time. It's a field so that the passage of time
+	// can be controlled.
+	now func() time.Time
+}
+
+// NewCallRegistry initializes a new, empty CallRegistry with the default
+// ringing delay.
+func NewCallRegistry() *CallRegistry {
+	return &CallRegistry{
+		calls:        make(map[string]*call),
+		RingingDelay: defaultCallRingingDelay,
+		now:          time.Now,
+	}
+}
+
+// Command applies a Call Control command (e.g. `answer` or `hangup`) to the
+// call with the given ID.
+//
+// For `bridge`, bridgeID is the ID of the call that's being bridged with,
+// which goes through the same transition. It has to be another call that's
+// known to the registry.
+//
+// If the command isn't allowed, the call is left as it was and an error is
+// returned along with the status code that it should be returned with.
+func (r *CallRegistry) Command(id string, command string, bridgeID string) (int, *ResponseError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.calls[id]
+	if !ok {
+		return http.StatusNotFound, createTelnyxError(errorCodeResourceNotFound,
+			fmt.Sprintf(resourceNotFound, "call", id))
+	}
+
+	now := r.now()
+	c.ring(now)
+
+	if status, telnyxError := c.checkCommand(command); telnyxError != nil {
+		return status, telnyxError
+	}
+
+	var other *call
+	if command == "bridge" {
+		other = r.calls[bridgeID]
+		if other == nil || bridgeID == id {
+			return http.StatusUnprocessableEntity, createTelnyxErrorWithSource(
+				errorCodeBadRequest, fmt.Sprintf(invalidBridgeCall, bridgeID),
+				&ResponseErrorSource{Pointer: "/call_control_id"})
+		}
+
+		other.ring(now)
+		if status, telnyxError := other.checkCommand(command); telnyxError != nil {
+			return status, telnyxError
+		}
+	}
+
+	// Hanging up a bridged call returns its partner to the answered state.
+	if command == "hangup" && c.bridgedWith != "" {
+		if partner, ok := r.calls[c.bridgedWith]; ok && partner.state == callStateBridged {
+			partner.state = callStateAnswered
+			partner.bridgedWith = ""
+		}
+	}
+
+	c.transition(callCommandFor(command).toState, now)
+
+	if other != nil {
+		other.transition(callStateBridged, now)
+		other.bridgedWith = id
+		c.bridgedWith = bridgeID
+	}
+
+	return 0, nil
+}
+
+// Dial records a new outbound call.
+func (r *CallRegistry) Dial(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.calls[id] = &call{
+		createdAt: now,
+		ringsAt:   now.Add(r.RingingDelay),
+		state:     callStateInitiated,
+	}
+}
+
+// Reset forgets every call.
+func (r *CallRegistry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = make(map[string]*call)
+}
+
+// Status returns the state of the call with the given ID and how long it's
+// been going for in seconds. The last return value is false if the call isn't
+// known.
+func (r *CallRegistry) Status(id string) (string, int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.calls[id]
+	if !ok {
+		return "", 0, false
+	}
+
+	now := r.now()
+	c.ring(now)
+	return c.state, c.duration(now), true
+}
+
+//
+// Private values
+//
+
+// Calls start ringing this long after they're dialed unless configured
+// otherwise.
+const defaultCallRingingDelay = 1 * time.Second
+
+// The states that a call goes through over its lifetime.
+const (
+	callStateInitiated = "initiated"
+	callStateRinging   = "ringing"
+	callStateAnswered  = "answered"
+	callStateBridged   = "bridged"
+	callStateHangup    = "hangup"
+)
+
+const (
+	callHasEnded       = "This call is no longer active and can't receive commands."
+	invalidBridgeCall  = "Call can't be bridged with `%s`, which isn't another known call."
+	invalidCallCommand = "Call can't accept the `%s` command while %s."
+)
+
+// callCommands describes the Call Control commands that change a call's
+// state or are only allowed in particular states. Commands that aren't
+// listed manipulate the media of a call, and so need it to be answered.
+var callCommands = map[string]*callCommand{
+	"answer": {
+		fromStates: []string{callStateInitiated, callStateRinging},
+		toState:    callStateAnswered,
+	},
+	"bridge": {
+		fromStates: []string{callStateAnswered},
+		toState:    callStateBridged,
+	},
+	"hangup": {
+		fromStates: []string{callStateInitiated, callStateRinging,
+			callStateAnswered, callStateBridged},
+		toState: callStateHangup,
+	},
+	"reject": {
+		fromStates: []string{callStateInitiated, callStateRinging},
+		toState:    callStateHangup,
+	},
+}
+
+// defaultCallCommand describes any command not in callCommands.
+var defaultCallCommand = &callCommand{
+	fromStates: []string{callStateAnswered, callStateBridged},
+}
+
+// dialWebhooks are the webhooks sent when a call is dialed. The spec also
+// lists the events that follow the call being answered and hung up for the
+// dial command, but those are sent when the call actually gets there.
+var dialWebhooks = []string{"call.initiated"}
+
+//
+// Private types
+//
+
+// call is the state of a single call in a CallRegistry.
+type call struct {
+	answeredAt  time.Time
+	bridgedWith string
+	createdAt   time.Time
+	endedAt     time.Time
+	ringsAt     time.Time
+	state       string
+}
+
+// checkCommand returns an error if the call can't accept a command in its
+// current state.
+func (c *call) checkCommand(command string) (int, *ResponseError) {
+	if c.state == callStateHangup {
+		return http.StatusUnprocessableEntity,
+			createTelnyxError(errorCodeCallHasEnded, callHasEnded)
+	}
+
+	for _, state := range callCommandFor(command).fromStates {
+		if c.state == state {
+			return 0, nil
+		}
+	}
+
+	return http.StatusUnprocessableEntity, createTelnyxError(errorCodeBadRequest,
+		fmt.Sprintf(invalidCallCommand, command, c.state))
+}
+
+// duration returns the number of seconds that the call has been (or was)
+// answered for.
+func (c *call) duration(now time.Time) int {
+	if c.answeredAt.IsZero() {
+		return 0
+	}
+
+	if !c.endedAt.IsZero() {
+		now = c.endedAt
+	}
+
+	return int(now.Sub(c.answeredAt) / time.Second)
+}
+
+// ring moves an initiated call into the ringing state if it's due to have
+// started ringing by the given time.
+func (c *call) ring(now time.Time) {
+	if c.state == callStateInitiated && !now.Before(c.ringsAt) {
+		c.state = callStateRinging
+	}
+}
+
+// transition moves the call into a new state, noting the time of the
+// transition where it matters. An empty state leaves the call as it is.
+func (c *call) transition(state string, now time.Time) {
+	if state == "" {
+		return
+	}
+
+	switch state {
+	case callStateAnswered:
+		if c.answeredAt.IsZero() {
+			c.answeredAt = now
+		}
+	case callStateHangup:
+		c.endedAt = now
+		c.bridgedWith = ""
+	}
+
+	c.state = state
+}
+
+// callCommand describes the states in which a Call Control command is
+// allowed and the state that it leaves a call in.
+type callCommand struct {
+	// fromStates are the states in which a call accepts the command.
+	fromStates []string
+
+	// toState is the state that the call is in after the command.
+	//
+	// Empty if the command doesn't change the call's state.
+	toState string
+}
+
+//
+// Private functions
+//
+
+// reconcileWithCalls brings a Call Control request and its generated
+// response in line with the server's call registry. It's only used in
+// stateful mode, and only for routes under `/calls`.
+//
+// Dialing registers a new call, retrieving a call reports its current state
+// in `state` along with `is_alive` and `call_duration`, and commands move
+// calls between states. It returns the webhooks that the
+// request should trigger, or an error and its status code if the request
+// isn't allowed for the call in its current state.
+func (s *StubServer) reconcileWithCalls(r *http.Request, route *stubServerRoute,
+	pathParams *PathParamsMap, requestData map[string]interface{},
+	responseData interface{}) (interface{}, []string, int, *ResponseError) {
+
+	routePath := string(route.path)
+	responseMap, _ := responseData.(map[string]interface{})
+	data, _ := responseMap["data"].(map[string]interface{})
+
+	switch {
+	case routePath == "/calls" && r.Method == http.MethodPost:
+		// The generator has already minted a call control ID for the call.
+		// Without one, there's nothing to track the call by, but it's still
+		// only been dialed.
+		if id, _ := data["call_control_id"].(string); id != "" {
+			s.calls.Dial(id)
+			data["is_alive"] = true
+		}
+
+		return responseData, dialWebhooks, 0, nil
+
+	case routePath == "/calls/{call_control_id}" && r.Method == http.MethodGet:
+		id := pathParamValue(route, pathParams, "call_control_id")
+
+		state, duration, ok := s.calls.Status(id)
+		if !ok {
+			return nil, nil, http.StatusNotFound, createTelnyxError(
+				errorCodeResourceNotFound, fmt.Sprintf(resourceNotFound, "call", id))
+		}
+
+		if data != nil {
+			data["call_control_id"] = id
+			data["call_duration"] = duration
+			data["is_alive"] = state != callStateHangup
+			data["state"] = state
+		}
+
+	case strings.HasPrefix(routePath, "/calls/{call_control_id}/actions/"):
+		id := pathParamValue(route, pathParams, "call_control_id")
+		bridgeID, _ := requestData["call_control_id"].(string)
+
+		status, telnyxError := s.calls.Command(id, path.Base(routePath), bridgeID)
+		if telnyxError != nil {
+			return nil, nil, status, telnyxError
+		}
+	}
+
+	return responseData, route.expectedWebhooks, 0, nil
+}
+
+// callCommandFor returns the description of a Call Control command.
+func callCommandFor(command string) *callCommand {
+	if callCommand, ok := callCommands[command]; ok {
+		return callCommand
+	}
+	return defaultCallCommand
+}
+
+// isCallPath returns whether a path belongs to Call Control's call resource.
+func isCallPath(routePath string) bool {
+	return routePath == "/calls" || strings.HasPrefix(routePath, "/calls/")
+}
+
+// pathParamValue returns the value of the named parameter extracted from a
+// request path, or an empty string if there's no such parameter.
+func pathParamValue(route *stubServerRoute, pathParams *PathParamsMap, name string) string {
+	if pathParams == nil {
+		return ""
+	}
+
+	for _, secondaryID := range pathParams.SecondaryIDs {
+		if secondaryID.Name == name {
+			return secondaryID.ID
+		}
+	}
+
+	if pathParams.PrimaryID != nil && len(route.pathParamNames) > 0 &&
+		route.pathParamNames[route.primaryIDIndex] == name {
+		return *pathParams.PrimaryID
+	}
+
+	return ""
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_CallLifecycle(t *testing.T) {
+	server := getStatefulStubServer(t)
+	headers := getDefaultHeaders()
+
+	// The clock stands still so that calls don't start ringing partway.
+	clock := NewClock()
+	clock.Freeze()
+	server.setClock(clock)
+
+	sendCommand := func(id string, command string, body string) (int, map[string]interface{}) {
+		resp, respBody := sendRequestToServer(t, server, "POST",
+			"/v2/calls/"+id+"/actions/"+command, body, headers)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(respBody, &data)
+		assert.NoError(t, err)
+		return resp.StatusCode, data
+	}
+
+	getCall := func(id string) map[string]interface{} {
+		resp, body := sendRequestToServer(t, server, "GET", "/v2/calls/"+id, "", headers)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+		return data["data"].(map[string]interface{})
+	}
+
+	dial := func() string {
+		resp, body := sendRequestToServer(t, server, "POST", "/v2/calls",
+			`{"connection_id": "123", "to": "+13125550001", "from": "+13125550002"}`,
+			headers)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+		return data["data"].(map[string]interface{})["call_control_id"].(string)
+	}
+
+	id := dial()
+	otherID := dial()
+	assert.NotEqual(t, id, otherID)
+	assert.Regexp(t, `^v2:`, id)
+
+	call := getCall(id)
+	assert.Equal(t, callStateInitiated, call["state"])
+	assert.Equal(t, true, call["is_alive"])
+	assert.Equal(t, 0.0, call["call_duration"])
+
+	// Media can't be played into a call that hasn't been answered.
+	status, data := sendCommand(id, "playback_start", `{"audio_url": "http://example.com/a.wav"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Equal(t, errorCodeBadRequest, getFirstError(t, data)["code"])
+
+	status, _ = sendCommand(id, "answer", `{}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, callStateAnswered, getCall(id)["state"])
+
+	status, _ = sendCommand(id, "playback_start", `{"audio_url": "http://example.com/a.wav"}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	// A call can only be answered once.
+	status, _ = sendCommand(id, "answer", `{}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	// Both calls need to be answered before they can be bridged.
+	status, _ = sendCommand(id, "bridge", `{"call_control_id": "`+otherID+`"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	status, _ = sendCommand(otherID, "answer", `{}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	// Calls can only be bridged with other calls that are known.
+	for _, bridgeID := range []string{id, "unknown"} {
+		status, data = sendCommand(id, "bridge", `{"call_control_id": "`+bridgeID+`"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+		assert.Equal(t, map[string]interface{}{"pointer": "/call_control_id"},
+			getFirstError(t, data)["source"])
+	}
+	assert.Equal(t, callStateAnswered, getCall(id)["state"])
+
+	status, _ = sendCommand(id, "bridge", `{"call_control_id": "`+otherID+`"}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = sendCommand(id, "hangup", `{}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	status, data = sendCommand(id, "playback_start", `{"audio_url": "http://example.com/a.wav"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	errorInfo := getFirstError(t, data)
+	assert.Equal(t, errorCodeCallHasEnded, errorInfo["code"])
+	assert.Equal(t, "Call has already ended", errorInfo["title"])
+
+	call = getCall(id)
+	assert.Equal(t, callStateHangup, call["state"])
+	assert.Equal(t, false, call["is_alive"])
+
+	// Hanging up one side of a bridge leaves the other answered.
+	assert.Equal(t, true, getCall(otherID)["is_alive"])
+	status, _ = sendCommand(otherID, "speak",
+		`{"payload": "Hello", "voice": "female", "language": "en-US"}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	// Commands to calls that were never made are rejected.
+	status, data = sendCommand("unknown", "answer", `{}`)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, errorCodeResourceNotFound, getFirstError(t, data)["code"])
+
+	resp, _ := sendRequestToServer(t, server, "GET", "/v2/calls/unknown", "", headers)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestStubServer_CallRings(t *testing.T) {
+	server := getStatefulStubServer(t)
+	clock := NewClock()
+	clock.Freeze()
+	server.setClock(clock)
+	headers := getDefaultHeaders()
+
+	getCall := func(id string) map[string]interface{} {
+		resp, body := sendRequestToServer(t, server, "GET", "/v2/calls/"+id, "", headers)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+		return data["data"].(map[string]interface{})
+	}
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v2/calls",
+		`{"connection_id": "123", "to": "+13125550001", "from": "+13125550002"}`,
+		headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	id := data["data"].(map[string]interface{})["call_control_id"].(string)
+
+	assert.Equal(t, callStateInitiated, getCall(id)["state"])
+
+	clock.Advance(defaultCallRingingDelay)
+	call := getCall(id)
+	assert.Equal(t, callStateRinging, call["state"])
+	assert.Equal(t, true, call["is_alive"])
+
+	// A ringing call can be answered.
+	resp, _ = sendRequestToServer(t, server, "POST", "/v2/calls/"+id+"/actions/answer",
+		`{}`, headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, callStateAnswered, getCall(id)["state"])
+}
+
+func TestCallRegistry_Status(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	registry := NewCallRegistry()
+	registry.now = func() time.Time { return now }
+
+	registry.Dial("call_123")
+
+	state, duration, ok := registry.Status("call_123")
+	assert.True(t, ok)
+	assert.Equal(t, callStateInitiated, state)
+	assert.Equal(t, 0, duration)
+
+	now = now.Add(registry.RingingDelay)
+	state, _, _ = registry.Status("call_123")
+	assert.Equal(t, callStateRinging, state)
+
+	_, telnyxError := registry.Command("call_123", "answer", "")
+	assert.Nil(t, telnyxError)
+
+	now = now.Add(30 * time.Second)
+	_, duration, _ = registry.Status("call_123")
+	assert.Equal(t, 30, duration)
+
+	_, telnyxError = registry.Command("call_123", "hangup", "")
+	assert.Nil(t, telnyxError)
+
+	// The duration stops counting once the call has ended.
+	now = now.Add(30 * time.Second)
+	state, duration, _ = registry.Status("call_123")
+	assert.Equal(t, callStateHangup, state)
+	assert.Equal(t, 30, duration)
+
+	_, _, ok = registry.Status("call_456")
+	assert.False(t, ok)
+}
+
+func TestCallRegistry_Reject(t *testing.T) {
+	registry := NewCallRegistry()
+	registry.Dial("call_123")
+
+	_, telnyxError := registry.Command("call_123", "reject", "")
+	assert.Nil(t, telnyxError)
+
+	status, telnyxError := registry.Command("call_123", "hangup", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Equal(t, errorCodeCallHasEnded, telnyxError.Errors[0].Code)
+}
+
+//
+// Tests for private functions
+//
+
+func TestReconcileWithCalls_DialWebhooks(t *testing.T) {
+	server := getStatefulStubServer(t)
+	route := &stubServerRoute{
+		path:             "/calls",
+		expectedWebhooks: []string{"call.initiated", "call.answered", "call.hangup"},
+	}
+	r := httptest.NewRequest("POST", "https://telnyx.com/v2/calls", nil)
+
+	// Only the webhooks of dialing are sent, even for a call that can't be
+	// tracked.
+	for _, responseData := range []interface{}{
+		map[string]interface{}{},
+		map[string]interface{}{"data": map[string]interface{}{}},
+		map[string]interface{}{"data": map[string]interface{}{"call_control_id": "call_123"}},
+	} {
+		_, webhooks, _, telnyxError := server.reconcileWithCalls(r, route, nil, nil, responseData)
+		assert.Nil(t, telnyxError)
+		assert.Equal(t, dialWebhooks, webhooks)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// Clock is the server's notion of the current time. It follows real time
+// unless it's been set, in which case it runs on from the time that it was
+// set to, or frozen, in which case it stands still until it's set, advanced,
+// or unfrozen.
+//
+// Functions can be scheduled to run at a time on the clock with AfterFunc.
+// They run once the clock reaches that time, whether that's because real time
+// has passed or because the clock was moved forward, in which case each runs
+// with the clock reading the time that it was scheduled for. Functions run one
+// at a time so that moving the clock is deterministic, which means they
+// should only change state; anything slow, like delivering a webhook, belongs
+// in a Goroutine of its own.
+//
+// It's safe for concurrent use.
+type Clock struct {
+	mu sync.Mutex
+
+	// offset is how far ahead of real time the clock is while it's running.
+	offset time.Duration
+
+	// frozen is whether the clock is standing still at frozenAt.
+	frozen   bool
+	frozenAt time.Time
+
+	timers []*ClockTimer
+
+	// wake runs the timers that are due once real time has caught up with
+	// the next of them. It's nil when there are no timers to wait for.
+	wake *time.Timer
+
+	// moving serializes moving the clock forward so that timers run in the
+	// order that they're due.
+	moving sync.Mutex
+}
+
+// NewClock initializes a new Clock that follows real time.
+func NewClock() *Clock {
+	return &Clock{}
+}
+
+// AfterFunc schedules f to run once d has passed on the clock, in a
+// Goroutine of its own unless the clock is moved forward past it, in which
+// case it runs before the clock finishes moving.
+func (c *Clock) AfterFunc(d time.Duration, f func()) *ClockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	timer := &ClockTimer{clock: c, due: c.now().Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	c.schedule()
+	return timer
+}
+
+// Advance moves the clock forward by d, running every timer that comes due
+// on the way.
+func (c *Clock) Advance(d time.Duration) {
+	c.moving.Lock()
+	defer c.moving.Unlock()
+
+	c.moveTo(c.Now().Add(d))
+}
+
+// Freeze stops the clock at the current time.
+func (c *Clock) Freeze() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.frozen {
+		return
+	}
+
+	c.frozenAt = c.now()
+	c.frozen = true
+	c.schedule()
+}
+
+// Frozen returns whether the clock is stopped.
+func (c *Clock) Frozen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.frozen
+}
+
+// Now returns the current time on the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now()
+}
+
+// Reset returns the clock to real time. Timers stay scheduled for the time on
+// the clock that they were scheduled for, so any that are then due run right
+// away.
+func (c *Clock) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.offset = 0
+	c.frozen = false
+	c.schedule()
+}
+
+// Set moves the clock to t. If that's forward, every timer that comes due on
+// the way is run. Timers aren't rewound if it's backward.
+func (c *Clock) Set(t time.Time) {
+	c.moving.Lock()
+	defer c.moving.Unlock()
+
+	c.moveTo(t)
+}
+
+// Unfreeze starts the clock again from the time that it's stopped at.
+func (c *Clock) Unfreeze() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.frozen {
+		return
+	}
+
+	c.offset = c.frozenAt.Sub(time.Now())
+	c.frozen = false
+	c.schedule()
+}
+
+// afterFunc is AfterFunc in the form used by things that schedule functions.
+func (c *Clock) afterFunc(d time.Duration, f func()) stopper {
+	return c.AfterFunc(d, f)
+}
+
+// moveTo moves the clock to t, first stopping at each timer that's due by
+// then to run it. The clock must be held by moving, but not locked.
+func (c *Clock) moveTo(t time.Time) {
+	for {
+		c.mu.Lock()
+		timer := c.next(t)
+		if timer == nil {
+			c.set(t)
+			c.schedule()
+			c.mu.Unlock()
+			return
+		}
+
+		if timer.due.After(c.now()) {
+			c.set(timer.due)
+		}
+		c.mu.Unlock()
+
+		timer.f()
+	}
+}
+
+// next removes and returns the earliest timer that's due by t, or returns
+// nil if there isn't one. The clock must be locked.
+func (c *Clock) next(t time.Time) *ClockTimer {
+	index := -1
+	for i, timer := range c.timers {
+		if timer.due.After(t) {
+			continue
+		}
+		if index < 0 || timer.due.Before(c.timers[index].due) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil
+	}
+
+	timer := c.timers[index]
+	c.timers = append(c.timers[:index], c.timers[index+1:]...)
+	return timer
+}
+
+// now is Now for a locked clock.
+func (c *Clock) now() time.Time {
+	if c.frozen {
+		return c.frozenAt
+	}
+	return time.Now().Add(c.offset)
+}
+
+// run runs every timer that's due, in the order that they're due. It's
+// called when real time catches up with the next timer.
+func (c *Clock) run() {
+	for {
+		c.mu.Lock()
+		timer := c.next(c.now())
+		if timer == nil {
+			c.schedule()
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+
+		timer.f()
+	}
+}
+
+// schedule arranges for the next timer to run once real time catches up with
+// it, replacing any earlier arrangement. Timers that are already due run
+// right away even if the clock is frozen. The clock must be locked.
+func (c *Clock) schedule() {
+	if c.wake != nil {
+		c.wake.Stop()
+		c.wake = nil
+	}
+
+	var due time.Time
+	for _, timer := range c.timers {
+		if due.IsZero() || timer.due.Before(due) {
+			due = timer.due
+		}
+	}
+	if due.IsZero() {
+		return
+	}
+
+	wait := due.Sub(c.now())
+	if c.frozen && wait > 0 {
+		return
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	c.wake = time.AfterFunc(wait, c.run)
+}
+
+// set sets the clock to t without running any timers. The clock must be
+// locked.
+func (c *Clock) set(t time.Time) {
+	if c.frozen {
+		c.frozenAt = t
+		return
+	}
+	c.offset = t.Sub(time.Now())
+}
+
+// ClockTimer is a function scheduled to run at a time on a Clock.
+type ClockTimer struct {
+	clock *Clock
+	due   time.Time
+	f     func()
+}
+
+// Stop cancels the timer. It returns false if the timer has already run or
+// been stopped.
+func (t *ClockTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, timer := range c.timers {
+		if timer == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			c.schedule()
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Private types
+//
+
+// stopper is a scheduled function that can be cancelled, like a *time.Timer
+// or a *ClockTimer.
+type stopper interface {
+	Stop() bool
+}
+
+//
+// Private functions
+//
+
+// realAfterFunc schedules a function to run after a duration of real time.
+// It's what things that schedule functions use when they haven't been given a
+// Clock.
+func realAfterFunc(d time.Duration, f func()) stopper {
+	return time.AfterFunc(d, f)
+}
+
+// now returns the current time on the server's clock, or real time if it
+// doesn't have one.
+func (s *StubServer) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
+// setClock makes clock the server's clock, which everything that keeps time
+// in the server follows from then on.
+func (s *StubServer) setClock(clock *Clock) {
+	s.clock = clock
+
+	if s.calls != nil {
+		s.calls.now = clock.Now
+	}
+	if s.journal != nil {
+		s.journal.now = clock.Now
+	}
+	if s.messages != nil {
+		s.messages.now = clock.Now
+		s.messages.afterFunc = clock.afterFunc
+	}
+	if s.numberOrders != nil {
+		s.numberOrders.now = clock.Now
+		s.numberOrders.afterFunc = clock.afterFunc
+	}
+	if s.reservations != nil {
+		s.reservations.now = clock.Now
+	}
+	if s.webhooks != nil {
+		s.webhooks.afterFunc = clock.afterFunc
+		if s.webhooks.Log != nil {
+			s.webhooks.Log.now = clock.Now
+		}
+	}
+}
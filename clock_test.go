@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	clock := NewClock()
+	clock.Freeze()
+	clock.Set(start)
+	assert.True(t, clock.Frozen())
+	assert.Equal(t, start, clock.Now())
+
+	// Timers run in the order that they're due, with the clock reading the
+	// time that they were due at.
+	var ran []time.Time
+	clock.AfterFunc(3*time.Second, func() { ran = append(ran, clock.Now()) })
+	clock.AfterFunc(time.Second, func() { ran = append(ran, clock.Now()) })
+	late := clock.AfterFunc(10*time.Second, func() { ran = append(ran, clock.Now()) })
+
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, []time.Time{start.Add(time.Second), start.Add(3 * time.Second)}, ran)
+	assert.Equal(t, start.Add(5*time.Second), clock.Now())
+
+	assert.True(t, late.Stop())
+	assert.False(t, late.Stop())
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, 2, len(ran))
+
+	// Moving the clock backward doesn't run anything.
+	clock.AfterFunc(time.Second, func() { ran = append(ran, clock.Now()) })
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now())
+	assert.Equal(t, 2, len(ran))
+
+	clock.Unfreeze()
+	assert.False(t, clock.Frozen())
+	assert.False(t, clock.Now().Before(start))
+}
+
+func TestClock_RunsTimersInRealTime(t *testing.T) {
+	clock := NewClock()
+	clock.Set(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	ran := make(chan time.Time, 1)
+	clock.AfterFunc(10*time.Millisecond, func() { ran <- clock.Now() })
+
+	select {
+	case now := <-ran:
+		assert.Equal(t, 2020, now.Year())
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for timer")
+	}
+
+	// A frozen clock only runs timers once it's moved.
+	clock.Freeze()
+	clock.AfterFunc(10*time.Millisecond, func() { ran <- clock.Now() })
+
+	select {
+	case <-ran:
+		t.Fatal("Timer ran while the clock was frozen")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Reset()
+	assert.False(t, clock.Frozen())
+	select {
+	case now := <-ran:
+		assert.NotEqual(t, 2020, now.Year())
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for timer")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// DeliveryLog records every attempt to deliver a webhook so that tests can
+// assert on how webhooks were delivered, including failed attempts, retries,
+// and failovers.
+//
+// Like a Journal, it holds a bounded number of attempts, forgetting the oldest
+// ones as new ones are recorded. It's safe for concurrent use.
+type DeliveryLog struct {
+	mu       sync.Mutex
+	capacity int
+	attempts []*DeliveryAttempt
+
+	// lastID is the ID of the most recently recorded attempt.
+	lastID int
+
+	// now returns the current time. It's a field so that the passage of time
+	// can be controlled.
+	now func() time.Time
+}
+
+// NewDeliveryLog initializes a new, empty DeliveryLog that holds up to
+// capacity attempts.
+func NewDeliveryLog(capacity int) *DeliveryLog {
+	return &DeliveryLog{
+		capacity: capacity,
+		now:      time.Now,
+	}
+}
+
+// List returns the recorded attempts, oldest first. If eventType or eventID
+// isn't empty, only attempts to deliver events of that type or with that ID
+// are returned.
+func (l *DeliveryLog) List(eventType string, eventID string) []*DeliveryAttempt {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	attempts := make([]*DeliveryAttempt, 0)
+	for _, attempt := range l.attempts {
+		if eventType != "" && eventType != attempt.EventType {
+			continue
+		}
+		if eventID != "" && eventID != attempt.EventID {
+			continue
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts
+}
+
+// Record adds an attempt to the log, assigning it an ID and the time that it
+// was recorded at.
+func (l *DeliveryLog) Record(attempt *DeliveryAttempt) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastID++
+	attempt.ID = l.lastID
+	attempt.AttemptedAt = l.now().UTC()
+
+	l.attempts = append(l.attempts, attempt)
+	if overflow := len(l.attempts) - l.capacity; overflow > 0 {
+		// Copy rather than reslice so that forgotten attempts can be garbage
+		// collected.
+		l.attempts = append([]*DeliveryAttempt(nil), l.attempts[overflow:]...)
+	}
+}
+
+// Reset forgets every attempt.
+func (l *DeliveryLog) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.attempts = nil
+	l.lastID = 0
+}
+
+// DeliveryAttempt is a single attempt to deliver a webhook to a URL.
+type DeliveryAttempt struct {
+	ID          int       `json:"id"`
+	AttemptedAt time.Time `json:"attempted_at"`
+
+	EventID   string `json:"event_id"`
+	EventType string `json:"event_type"`
+
+	// Attempt counts the attempts to deliver the event, starting from one
+	// and continuing across the primary and failover URLs.
+	Attempt int    `json:"attempt"`
+	URL     string `json:"url"`
+
+	// Failover is whether URL is the failover URL, which is only tried once
+	// delivery to the primary URL has failed.
+	Failover bool `json:"failover"`
+
+	// Status is the status code of the receiver's response, or zero if it
+	// didn't respond at all, in which case Error says why.
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Delivered is whether the receiver accepted the webhook with a 2xx
+	// status. No more attempts are made after one that's delivered.
+	Delivered bool `json:"delivered"`
+}
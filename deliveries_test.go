@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestDeliveryLog_Record(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	log := NewDeliveryLog(2)
+	log.now = func() time.Time { return now }
+
+	for i, eventType := range []string{"call.initiated", "call.answered", "call.hangup"} {
+		log.Record(&DeliveryAttempt{EventID: strconv.Itoa(i), EventType: eventType, Attempt: 1})
+	}
+
+	// Only the most recent attempts are kept.
+	attempts := log.List("", "")
+	assert.Equal(t, 2, len(attempts))
+	assert.Equal(t, 2, attempts[0].ID)
+	assert.Equal(t, "call.answered", attempts[0].EventType)
+	assert.Equal(t, 3, attempts[1].ID)
+	assert.Equal(t, now.UTC(), attempts[1].AttemptedAt)
+
+	attempts = log.List("call.hangup", "")
+	assert.Equal(t, 1, len(attempts))
+	assert.Equal(t, 3, attempts[0].ID)
+
+	attempts = log.List("", "1")
+	assert.Equal(t, 1, len(attempts))
+	assert.Equal(t, 2, attempts[0].ID)
+	assert.Equal(t, 0, len(log.List("call.hangup", "1")))
+
+	log.Reset()
+	assert.Equal(t, 0, len(log.List("", "")))
+
+	log.Record(&DeliveryAttempt{EventType: "call.initiated", Attempt: 1})
+	assert.Equal(t, 1, log.List("", "")[0].ID)
+}
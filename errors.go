@@ -0,0 +1,286 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/team-telnyx/telnyx-mock/spec"
+)
+
+//
+// Public types
+//
+
+// ResponseError is a JSON-serializable structure representing an error
+// returned from Telnyx's API.
+//
+// Like the live API, errors are always returned as a list even though
+// telnyx-mock will only ever produce one at a time.
+type ResponseError struct {
+	Errors []*ResponseErrorDetail `json:"errors"`
+}
+
+// ResponseErrorDetail is a single error in a ResponseError.
+type ResponseErrorDetail struct {
+	// Code is Telnyx's numeric error code, encoded as a string.
+	Code string `json:"code"`
+
+	// Title is a short, human-readable summary of the error that's the same
+	// for every occurrence of its code.
+	Title string `json:"title"`
+
+	// Detail is a human-readable explanation specific to this occurrence of
+	// the error.
+	Detail string `json:"detail,omitempty"`
+
+	// Source identifies the part of the request that caused the error.
+	//
+	// nil if the error wasn't caused by a specific parameter.
+	Source *ResponseErrorSource `json:"source,omitempty"`
+}
+
+// ResponseErrorSource identifies the part of a request that caused an error.
+// Only one of its fields will be set.
+type ResponseErrorSource struct {
+	// Pointer is a JSON pointer (RFC 6901) to the offending value in the
+	// request body.
+	Pointer string `json:"pointer,omitempty"`
+
+	// Parameter is the name of the offending query parameter.
+	Parameter string `json:"parameter,omitempty"`
+}
+
+//
+// Private values
+//
+
+// Error codes returned by the live Telnyx API that telnyx-mock mirrors.
+const (
+	errorCodeMissingParameter     = "10004"
+	errorCodeResourceNotFound     = "10005"
+	errorCodeUnexpectedError      = "10007"
+	errorCodeAuthenticationFailed = "10009"
+	errorCodeAuthorizationFailed  = "10010"
+	errorCodeTooManyRequests      = "10011"
+	errorCodeBadRequest           = "10015"
+	errorCodeCallHasEnded         = "90018"
+)
+
+// errorTitles maps Telnyx error codes to their titles.
+var errorTitles = map[string]string{
+	errorCodeMissingParameter:     "Missing required parameter",
+	errorCodeResourceNotFound:     "Resource not found",
+	errorCodeUnexpectedError:      "Unexpected error",
+	errorCodeAuthenticationFailed: "Authentication failed",
+	errorCodeAuthorizationFailed:  "Authorization failed",
+	errorCodeTooManyRequests:      "Too many requests",
+	errorCodeBadRequest:           "Bad Request",
+	errorCodeCallHasEnded:         "Call has already ended",
+}
+
+// Patterns for the messages produced by jsval when an object fails
+// validation. Errors for nested values are wrapped by each enclosing object
+// (e.g. "object property 'a' validation failed: object property 'b' is
+// required"), and the whole message is prefixed with the address of the
+// validator that failed, which isn't of any use to an API consumer.
+var (
+	validationNestedPattern    = regexp.MustCompile(`\Aobject property (?:for )?'([^']+)' validation failed: `)
+	validationRequiredPattern  = regexp.MustCompile(`\Aobject property '([^']+)' is required`)
+	validationValidatorPattern = regexp.MustCompile(`\Avalidator \S+ failed: `)
+)
+
+//
+// Private functions
+//
+
+// Helper to create an internal server error for API issues.
+func createInternalServerError() *ResponseError {
+	return createTelnyxError(errorCodeUnexpectedError, internalServerError)
+}
+
+// This creates a Telnyx error to return in case of API errors.
+func createTelnyxError(code string, detail string) *ResponseError {
+	return createTelnyxErrorWithSource(code, detail, nil)
+}
+
+// createTelnyxErrorWithSource creates a Telnyx error that points back to the
+// part of the request that caused it.
+func createTelnyxErrorWithSource(code string, detail string, source *ResponseErrorSource) *ResponseError {
+	return &ResponseError{
+		Errors: []*ResponseErrorDetail{
+			{
+				Code:   code,
+				Title:  errorTitles[code],
+				Detail: detail,
+				Source: source,
+			},
+		},
+	}
+}
+
+// createValidationError creates a Telnyx error from an error produced by
+// validating request data against a schema.
+//
+// The path to the offending value is recovered from the validation message
+// and reported as a JSON pointer into the request body, or as a parameter
+// name when the data came from the query string.
+func createValidationError(err error, schema *spec.Schema,
+	data map[string]interface{}, inQuery bool) *ResponseError {
+
+	message := validationValidatorPattern.ReplaceAllString(err.Error(), "")
+	path, required := validationErrorPath(message, schema, data)
+
+	code := errorCodeBadRequest
+	if required {
+		code = errorCodeMissingParameter
+	}
+
+	var source *ResponseErrorSource
+	if inQuery {
+		if parameter := queryParameterName(path); parameter != "" {
+			source = &ResponseErrorSource{Parameter: parameter}
+		}
+	} else if pointer := jsonPointer(path); pointer != "" {
+		source = &ResponseErrorSource{Pointer: pointer}
+	}
+
+	return createTelnyxErrorWithSource(code, "Request validation error: "+message, source)
+}
+
+// findAdditionalProperty returns the name of the first key in data that isn't
+// described by the schema's properties, or an empty string if there is none.
+func findAdditionalProperty(schema *spec.Schema, data interface{}) string {
+	dataMap, ok := data.(map[string]interface{})
+	if !ok || schema == nil {
+		return ""
+	}
+
+	var keys []string
+	for key := range dataMap {
+		if _, ok := schema.Properties[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) < 1 {
+		return ""
+	}
+
+	// Map iteration order is random, so sort to make sure that the same
+	// request always produces the same error.
+	sort.Strings(keys)
+	return keys[0]
+}
+
+// jsonPointer encodes a path as a JSON pointer (RFC 6901). An empty path
+// points at the whole document, which is the empty string.
+func jsonPointer(path []string) string {
+	replacer := strings.NewReplacer("~", "~0", "/", "~1")
+
+	var pointer string
+	for _, segment := range path {
+		pointer += "/" + replacer.Replace(segment)
+	}
+	return pointer
+}
+
+// queryParameterName encodes a path as a query parameter name using the
+// bracketed, "Rack-style" notation that the API expects (e.g.
+// `filter[status]`).
+func queryParameterName(path []string) string {
+	if len(path) < 1 {
+		return ""
+	}
+
+	name := path[0]
+	for _, segment := range path[1:] {
+		name += "[" + segment + "]"
+	}
+	return name
+}
+
+// validationErrorPath recovers the path to the value that failed validation
+// from a jsval error message. The second return value indicates whether the
+// failure was a missing required property.
+//
+// jsval doesn't report array indexes, so when the path runs through an array,
+// the data is inspected to find the first element that the rest of the path
+// could apply to. Likewise, it doesn't name properties that aren't allowed, so
+// those are found by comparing the data against the schema.
+func validationErrorPath(message string, schema *spec.Schema,
+	data interface{}) ([]string, bool) {
+
+	var path []string
+	current := data
+
+	// descend moves into a property of the current value, stepping through
+	// any arrays along the way. want decides which array element to pick.
+	descend := func(key string, want func(map[string]interface{}) bool) {
+		for {
+			slice, ok := current.([]interface{})
+			if !ok {
+				break
+			}
+
+			index := -1
+			for i, element := range slice {
+				if elementMap, ok := element.(map[string]interface{}); ok && want(elementMap) {
+					index = i
+					break
+				}
+			}
+			if index == -1 {
+				break
+			}
+
+			path = append(path, strconv.Itoa(index))
+			current = slice[index]
+			if schema != nil {
+				schema = schema.Items
+			}
+		}
+
+		path = append(path, key)
+		if currentMap, ok := current.(map[string]interface{}); ok {
+			current = currentMap[key]
+		} else {
+			current = nil
+		}
+		if schema != nil {
+			schema = schema.Properties[key]
+		}
+	}
+
+	for {
+		if matches := validationNestedPattern.FindStringSubmatch(message); matches != nil {
+			key := matches[1]
+			descend(key, func(element map[string]interface{}) bool {
+				_, ok := element[key]
+				return ok
+			})
+			message = message[len(matches[0]):]
+			continue
+		}
+
+		if matches := validationRequiredPattern.FindStringSubmatch(message); matches != nil {
+			key := matches[1]
+			descend(key, func(element map[string]interface{}) bool {
+				_, ok := element[key]
+				return !ok
+			})
+			return path, true
+		}
+
+		break
+	}
+
+	if strings.HasPrefix(message, "additional properties are not allowed") {
+		if key := findAdditionalProperty(schema, current); key != "" {
+			path = append(path, key)
+		}
+	}
+
+	return path, false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/team-telnyx/telnyx-mock/spec"
+)
+
+func TestCreateValidationError(t *testing.T) {
+	schema := &spec.Schema{
+		Type: spec.TypeObject,
+		Properties: map[string]*spec.Schema{
+			"number_pool_settings": {
+				Type: spec.TypeObject,
+				Properties: map[string]*spec.Schema{
+					"geomatch": {Type: spec.TypeBoolean},
+				},
+			},
+		},
+	}
+
+	// Body errors get a pointer
+	{
+		data := map[string]interface{}{
+			"number_pool_settings": map[string]interface{}{"other": true},
+		}
+		err := fmt.Errorf("validator 0x123 failed: object property " +
+			"'number_pool_settings' validation failed: additional properties are not allowed")
+		telnyxError := createValidationError(err, schema, data, false)
+
+		assert.Equal(t, 1, len(telnyxError.Errors))
+		assert.Equal(t, errorCodeBadRequest, telnyxError.Errors[0].Code)
+		assert.Equal(t, "Request validation error: object property "+
+			"'number_pool_settings' validation failed: additional properties are not allowed",
+			telnyxError.Errors[0].Detail)
+		assert.Equal(t, &ResponseErrorSource{Pointer: "/number_pool_settings/other"},
+			telnyxError.Errors[0].Source)
+	}
+
+	// Body errors about the whole body don't get a pointer
+	{
+		err := fmt.Errorf("validator 0x123 failed: expected an object")
+		telnyxError := createValidationError(err, schema, nil, false)
+		assert.Nil(t, telnyxError.Errors[0].Source)
+	}
+
+	// Query errors get a parameter
+	{
+		err := fmt.Errorf("validator 0x123 failed: object property " +
+			"'page' validation failed: object property 'number' is required")
+		telnyxError := createValidationError(err, nil, nil, true)
+
+		assert.Equal(t, errorCodeMissingParameter, telnyxError.Errors[0].Code)
+		assert.Equal(t, &ResponseErrorSource{Parameter: "page[number]"},
+			telnyxError.Errors[0].Source)
+	}
+}
+
+func TestJSONPointer(t *testing.T) {
+	assert.Equal(t, "", jsonPointer(nil))
+	assert.Equal(t, "/to/0/phone_number", jsonPointer([]string{"to", "0", "phone_number"}))
+	assert.Equal(t, "/a~1b/c~0d", jsonPointer([]string{"a/b", "c~d"}))
+}
+
+func TestQueryParameterName(t *testing.T) {
+	assert.Equal(t, "", queryParameterName(nil))
+	assert.Equal(t, "limit", queryParameterName([]string{"limit"}))
+	assert.Equal(t, "filter[status][eq]", queryParameterName([]string{"filter", "status", "eq"}))
+}
+
+func TestValidationErrorPath(t *testing.T) {
+	// Steps through arrays to the first element that's missing a required
+	// property
+	{
+		data := map[string]interface{}{
+			"to": []interface{}{
+				map[string]interface{}{"phone_number": "+15555555555"},
+				map[string]interface{}{},
+			},
+		}
+		path, required := validationErrorPath(
+			"object property 'to' validation failed: object property 'phone_number' is required",
+			nil, data)
+		assert.True(t, required)
+		assert.Equal(t, []string{"to", "1", "phone_number"}, path)
+	}
+
+	// Unrecognized messages produce the path leading up to them
+	{
+		path, required := validationErrorPath(
+			"object property 'name' validation failed: value is not a string (Kind: int)",
+			nil, map[string]interface{}{"name": 7})
+		assert.False(t, required)
+		assert.Equal(t, []string{"name"}, path)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/team-telnyx/telnyx-mock/spec"
+)
+
+//
+// Private values
+//
+
+// The operators that can follow a filter's field name, as in
+// `filter[connection_name][contains]`. A filter without an operator is an
+// equality filter.
+const (
+	filterOperatorContains   = "contains"
+	filterOperatorEndsWith   = "ends_with"
+	filterOperatorEq         = "eq"
+	filterOperatorGt         = "gt"
+	filterOperatorGte        = "gte"
+	filterOperatorLt         = "lt"
+	filterOperatorLte        = "lte"
+	filterOperatorStartsWith = "starts_with"
+)
+
+// filterOperators is the set of operators that filters are applied with.
+var filterOperators = map[string]bool{
+	filterOperatorContains:   true,
+	filterOperatorEndsWith:   true,
+	filterOperatorEq:         true,
+	filterOperatorGt:         true,
+	filterOperatorGte:        true,
+	filterOperatorLt:         true,
+	filterOperatorLte:        true,
+	filterOperatorStartsWith: true,
+}
+
+//
+// Private types
+//
+
+// listFilter is a single `filter[...]` parameter of a list request.
+type listFilter struct {
+	// field is the path to the filtered property within each resource. Dots
+	// in a filter's name separate its segments, so
+	// `filter[voice.connection_name]` filters on `connection_name` within
+	// `voice`.
+	field []string
+
+	// operator is one of the filterOperator* constants.
+	operator string
+
+	// values are what the property is compared against. There's more than
+	// one if the filter was given as an array, in which case the property
+	// must match all of them.
+	values []string
+}
+
+// matches returns whether a resource passes the filter. Resources that don't
+// have the filtered property don't pass.
+func (f *listFilter) matches(item interface{}) bool {
+	fieldValues := lookupFilterField(item, f.field)
+	if len(fieldValues) < 1 {
+		return false
+	}
+
+	for _, value := range f.values {
+		matched := false
+		for _, fieldValue := range fieldValues {
+			if compareFilterValue(fieldValue, f.operator, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reflect sets the filtered property of a resource to a value that passes the
+// filter where that's possible. Only equality and substring filters can be
+// reflected, and only into resources that already have the property.
+func (f *listFilter) reflect(item interface{}) {
+	switch f.operator {
+	case filterOperatorContains, filterOperatorEndsWith, filterOperatorEq,
+		filterOperatorStartsWith:
+	default:
+		return
+	}
+
+	field, ok := resolveFilterField([]interface{}{item}, f.field)
+	if !ok {
+		return
+	}
+
+	for _, value := range f.values {
+		setFilterField(item, field, value)
+	}
+}
+
+//
+// Private functions
+//
+
+// applyFilters returns the resources that pass every filter.
+//
+// A filter on a property that none of the resources have is ignored, because
+// the property most likely isn't part of the resource at all, and the filter
+// tunes how the list is produced instead (e.g. `filter[limit]`).
+func applyFilters(items []interface{}, filters []*listFilter) []interface{} {
+	for _, filter := range filters {
+		field, ok := resolveFilterField(items, filter.field)
+		if !ok {
+			continue
+		}
+
+		resolved := &listFilter{field: field, operator: filter.operator, values: filter.values}
+
+		filtered := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			if resolved.matches(item) {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	return items
+}
+
+// compareFilterValue compares a resource's property with a filter's value.
+// Values are compared as numbers if both can be parsed as one, and as strings
+// otherwise, which also orders timestamps in the API's format correctly.
+func compareFilterValue(fieldValue interface{}, operator string, value string) bool {
+	if fieldValue == nil {
+		return false
+	}
+	field := fmt.Sprint(fieldValue)
+
+	switch operator {
+	case filterOperatorContains:
+		return strings.Contains(field, value)
+	case filterOperatorEndsWith:
+		return strings.HasSuffix(field, value)
+	case filterOperatorEq:
+		return field == value
+	case filterOperatorStartsWith:
+		return strings.HasPrefix(field, value)
+	}
+
+	var comparison int
+	fieldNumber, fieldErr := strconv.ParseFloat(field, 64)
+	valueNumber, valueErr := strconv.ParseFloat(value, 64)
+	if fieldErr == nil && valueErr == nil {
+		switch {
+		case fieldNumber < valueNumber:
+			comparison = -1
+		case fieldNumber > valueNumber:
+			comparison = 1
+		}
+	} else {
+		comparison = strings.Compare(field, value)
+	}
+
+	switch operator {
+	case filterOperatorGt:
+		return comparison > 0
+	case filterOperatorGte:
+		return comparison >= 0
+	case filterOperatorLt:
+		return comparison < 0
+	case filterOperatorLte:
+		return comparison <= 0
+	}
+
+	return false
+}
+
+// cycleEnumProperties varies the top-level properties of the i-th resource
+// in a generated list that have an enum so that the list includes every
+// allowed value. Each property starts from its generated value so that the
+// first resource is left unchanged.
+func cycleEnumProperties(item interface{}, schema *spec.Schema, i int) {
+	itemMap, ok := item.(map[string]interface{})
+	if !ok || schema == nil {
+		return
+	}
+
+	for name, property := range schema.Properties {
+		if len(property.Enum) < 2 {
+			continue
+		}
+
+		value, ok := itemMap[name]
+		if !ok {
+			continue
+		}
+
+		start := 0
+		for j, enumValue := range property.Enum {
+			if enumValue == value {
+				start = j
+				break
+			}
+		}
+
+		itemMap[name] = property.Enum[(start+i)%len(property.Enum)]
+	}
+}
+
+// lookupFilterField finds the values of a filtered property in a resource.
+// Arrays along the path are searched element by element, so there may be more
+// than one value, and if the property itself is an array, each of its
+// elements is a value.
+func lookupFilterField(value interface{}, path []string) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		var values []interface{}
+		for _, element := range v {
+			values = append(values, lookupFilterField(element, path)...)
+		}
+		return values
+
+	case map[string]interface{}:
+		if len(path) < 1 {
+			return nil
+		}
+		subValue, ok := v[path[0]]
+		if !ok {
+			return nil
+		}
+		return lookupFilterField(subValue, path[1:])
+	}
+
+	if len(path) > 0 {
+		return nil
+	}
+	return []interface{}{value}
+}
+
+// resolveFilterField finds the path of the property that a filter applies
+// to in a set of resources. Filter names don't always match the property
+// exactly, so a few alternatives are tried after the name itself: the
+// plural of the last segment (`filter[tag]` filters on `tags`), and the last
+// segment on its own (`filter[voice.connection_name]` filters on
+// `connection_name` where that's at the top level). The last return value
+// is false if none of the resources have any of them.
+func resolveFilterField(items []interface{}, field []string) ([]string, bool) {
+	last := field[len(field)-1]
+
+	plural := make([]string, len(field))
+	copy(plural, field)
+	plural[len(plural)-1] = last + "s"
+
+	candidates := [][]string{field, plural}
+	if len(field) > 1 {
+		candidates = append(candidates, []string{last})
+	}
+
+	for _, candidate := range candidates {
+		for _, item := range items {
+			if len(lookupFilterField(item, candidate)) > 0 {
+				return candidate, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// parseFilters extracts the filters from a list request's parameters. They're
+// sorted by field so that they're always applied in the same order.
+func parseFilters(requestData map[string]interface{}) []*listFilter {
+	filterParams, ok := requestData["filter"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var filters []*listFilter
+	for name, param := range filterParams {
+		field := strings.Split(name, ".")
+
+		operators, ok := param.(map[string]interface{})
+		if !ok {
+			operators = map[string]interface{}{filterOperatorEq: param}
+		}
+
+		for operator, value := range operators {
+			if !filterOperators[operator] {
+				continue
+			}
+
+			filters = append(filters, &listFilter{
+				field:    field,
+				operator: operator,
+				values:   filterValues(value),
+			})
+		}
+	}
+
+	sort.Slice(filters, func(i, j int) bool {
+		iField := strings.Join(filters[i].field, ".")
+		jField := strings.Join(filters[j].field, ".")
+		if iField != jField {
+			return iField < jField
+		}
+		return filters[i].operator < filters[j].operator
+	})
+
+	return filters
+}
+
+// filterValues converts a filter parameter's value to strings.
+func filterValues(value interface{}) []string {
+	if values, ok := value.([]interface{}); ok {
+		strs := make([]string, len(values))
+		for i, v := range values {
+			strs[i] = fmt.Sprint(v)
+		}
+		return strs
+	}
+
+	return []string{fmt.Sprint(value)}
+}
+
+// setFilterField sets a property along a filter's path in a resource if the
+// resource already has it. Where the path runs through an array, only the
+// first element is changed. If the property is itself an array, the value is
+// added to it instead.
+func setFilterField(value interface{}, path []string, fieldValue string) {
+	switch v := value.(type) {
+	case []interface{}:
+		if len(v) > 0 {
+			setFilterField(v[0], path, fieldValue)
+		}
+
+	case map[string]interface{}:
+		if len(path) < 1 {
+			return
+		}
+
+		subValue, ok := v[path[0]]
+		if !ok {
+			return
+		}
+
+		if len(path) > 1 {
+			setFilterField(subValue, path[1:], fieldValue)
+			return
+		}
+
+		switch existing := subValue.(type) {
+		case []interface{}:
+			for _, element := range existing {
+				if fmt.Sprint(element) == fieldValue {
+					return
+				}
+			}
+			v[path[0]] = append(existing, fieldValue)
+		case map[string]interface{}:
+			// Objects can't be filtered on directly.
+		case bool:
+			if parsed, err := strconv.ParseBool(fieldValue); err == nil {
+				v[path[0]] = parsed
+			}
+		case float64, int:
+			if parsed, err := strconv.ParseFloat(fieldValue, 64); err == nil {
+				v[path[0]] = parsed
+			}
+		default:
+			v[path[0]] = fieldValue
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_Filters(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	getPhoneNumbers := func(query string) ([]interface{}, float64) {
+		resp, body := sendRequestToServer(t, server, "GET",
+			"/v2/phone_numbers?page[size]=250&"+query, "", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+
+		meta := data["meta"].(map[string]interface{})
+		return data["data"].([]interface{}), meta["total_results"].(float64)
+	}
+
+	items, total := getPhoneNumbers("")
+	assert.Equal(t, defaultListSize, len(items))
+	assert.Equal(t, float64(defaultListSize), total)
+
+	// Enum properties vary across the list, so filtering on one keeps some
+	// items and drops others.
+	items, total = getPhoneNumbers("filter[status]=deleted")
+	assert.True(t, len(items) > 0 && len(items) < defaultListSize)
+	assert.Equal(t, float64(len(items)), total)
+	for _, item := range items {
+		assert.Equal(t, "deleted", item.(map[string]interface{})["status"])
+	}
+
+	// `filter[tag]` filters on the `tags` array.
+	items, _ = getPhoneNumbers("filter[tag]=vip")
+	assert.Equal(t, defaultListSize/2, len(items))
+	for _, item := range items {
+		assert.Contains(t, item.(map[string]interface{})["tags"], "vip")
+	}
+
+	items, _ = getPhoneNumbers("filter[voice.connection_name][contains]=office")
+	assert.Equal(t, defaultListSize/2, len(items))
+	for _, item := range items {
+		assert.Contains(t, item.(map[string]interface{})["connection_name"], "office")
+	}
+
+	// Filters are combined, and every filter is reflected into the same
+	// items.
+	items, _ = getPhoneNumbers("filter[tag]=vip&filter[status]=deleted")
+	assert.Equal(t, defaultListSize/2, len(items))
+	for _, item := range items {
+		assert.Equal(t, "deleted", item.(map[string]interface{})["status"])
+		assert.Contains(t, item.(map[string]interface{})["tags"], "vip")
+	}
+}
+
+func TestStubServer_FiltersStored(t *testing.T) {
+	server := getStatefulStubServer(t)
+
+	server.store.Put(map[string]interface{}{
+		"id": "1", "record_type": "phone_number", "status": "active",
+	})
+	server.store.Put(map[string]interface{}{
+		"id": "2", "record_type": "phone_number", "status": "deleted",
+	})
+
+	resp, body := sendRequestToServer(t, server, "GET",
+		"/v2/phone_numbers?filter[status]=deleted", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+
+	items := data["data"].([]interface{})
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "2", items[0].(map[string]interface{})["id"])
+}
+
+//
+// Tests for private functions
+//
+
+func TestApplyFilters(t *testing.T) {
+	items := []interface{}{
+		map[string]interface{}{
+			"created_at":    "2020-01-01T00:00:00Z",
+			"phone_numbers": []interface{}{map[string]interface{}{"phone_number": "+13125550001"}},
+			"port":          5060.0,
+		},
+		map[string]interface{}{
+			"created_at":    "2020-02-01T00:00:00Z",
+			"phone_numbers": []interface{}{map[string]interface{}{"phone_number": "+13125550002"}},
+			"port":          5061.0,
+		},
+	}
+
+	filter := func(requestData map[string]interface{}) int {
+		return len(applyFilters(items, parseFilters(requestData)))
+	}
+
+	assert.Equal(t, 2, filter(nil))
+	assert.Equal(t, 1, filter(map[string]interface{}{"filter": map[string]interface{}{
+		"created_at": map[string]interface{}{"gt": "2020-01-15T00:00:00Z"},
+	}}))
+	assert.Equal(t, 0, filter(map[string]interface{}{"filter": map[string]interface{}{
+		"created_at": map[string]interface{}{
+			"gt": "2020-01-15T00:00:00Z",
+			"lt": "2020-01-20T00:00:00Z",
+		},
+	}}))
+	assert.Equal(t, 1, filter(map[string]interface{}{"filter": map[string]interface{}{
+		"phone_numbers.phone_number": "+13125550002",
+	}}))
+	assert.Equal(t, 2, filter(map[string]interface{}{"filter": map[string]interface{}{
+		"phone_numbers.phone_number": map[string]interface{}{"starts_with": "+1312"},
+	}}))
+	assert.Equal(t, 1, filter(map[string]interface{}{"filter": map[string]interface{}{
+		"port": "5060",
+	}}))
+	assert.Equal(t, 1, filter(map[string]interface{}{"filter": map[string]interface{}{
+		"port": map[string]interface{}{"gte": 5061},
+	}}))
+
+	// Filters on properties that the resources don't have are ignored.
+	assert.Equal(t, 2, filter(map[string]interface{}{"filter": map[string]interface{}{
+		"limit": "1",
+	}}))
+}
+
+func TestCompareFilterValue(t *testing.T) {
+	assert.True(t, compareFilterValue("connection-name", filterOperatorContains, "tion"))
+	assert.True(t, compareFilterValue("connection-name", filterOperatorStartsWith, "conn"))
+	assert.True(t, compareFilterValue("connection-name", filterOperatorEndsWith, "name"))
+	assert.False(t, compareFilterValue("connection-name", filterOperatorEndsWith, "conn"))
+	assert.True(t, compareFilterValue(true, filterOperatorEq, "true"))
+	assert.True(t, compareFilterValue(10.0, filterOperatorGt, "9"))
+	assert.False(t, compareFilterValue("10", filterOperatorLt, "9"))
+	assert.False(t, compareFilterValue(nil, filterOperatorEq, "<nil>"))
+}
+
+func TestCycleEnumProperties(t *testing.T) {
+	schema := realSpec.Paths["/phone_numbers"]["get"].
+		Responses["200"].Content["application/json"].Schema.Properties["data"].Items
+
+	seen := make(map[interface{}]bool)
+	for i := 0; i < 10; i++ {
+		item := map[string]interface{}{"status": "active"}
+		cycleEnumProperties(item, schema, i)
+		seen[item["status"]] = true
+
+		if i == 0 {
+			assert.Equal(t, "active", item["status"])
+		}
+	}
+	assert.Equal(t, len(schema.Properties["status"].Enum), len(seen))
+}
+
+func TestParseFilters(t *testing.T) {
+	filters := parseFilters(map[string]interface{}{
+		"filter": map[string]interface{}{
+			"status":                "active",
+			"voice.connection_name": map[string]interface{}{"contains": "a", "bogus": "b"},
+			"features":              []interface{}{"sms", "voice"},
+		},
+	})
+
+	assert.Equal(t, []*listFilter{
+		{field: []string{"features"}, operator: filterOperatorEq, values: []string{"sms", "voice"}},
+		{field: []string{"status"}, operator: filterOperatorEq, values: []string{"active"}},
+		{field: []string{"voice", "connection_name"}, operator: filterOperatorContains, values: []string{"a"}},
+	}, filters)
+}
+
+func TestResolveFilterField(t *testing.T) {
+	items := []interface{}{
+		map[string]interface{}{"tags": []interface{}{"a"}, "connection_name": "c"},
+	}
+
+	field, ok := resolveFilterField(items, []string{"tag"})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"tags"}, field)
+
+	field, ok = resolveFilterField(items, []string{"voice", "connection_name"})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"connection_name"}, field)
+
+	_, ok = resolveFilterField(items, []string{"limit"})
+	assert.False(t, ok)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"reflect"
 	"sort"
@@ -26,6 +27,14 @@ type GenerateParams struct {
 	// none of the original expansions applied.
 	Expansions *ExpansionLevel
 
+	// NewIDRand, if set, means that the request creates a resource, and is a
+	// PRNG to mint the new resource's ID with. The minted ID has the same
+	// shape as the resource's other IDs, and replaces the generated one
+	// everywhere that an ID from the request path would.
+	//
+	// nil if the request doesn't create anything.
+	NewIDRand *rand.Rand
+
 	// PathParams, if set, is a collection that contains values for parameters
 	// that were extracted from a request path. This is useful so that we can
 	// reflect those values into responses for a more realistic effect.
@@ -64,6 +73,25 @@ type GenerateParams struct {
 	// returned.
 	WrapWithList bool
 
+	// ListSize is the total number of objects in a list that's being
+	// generated. Only those on the requested page are returned, and the
+	// list's `meta` describes where that page is in the whole.
+	//
+	// Defaults to defaultListSize if zero. Only used with WrapWithList.
+	ListSize int
+
+	// PageNumber is the page of the list that's being generated, counting
+	// from one.
+	//
+	// Defaults to the first page if zero. Only used with WrapWithList.
+	PageNumber int
+
+	// PageSize is the number of objects on each page of the list that's
+	// being generated.
+	//
+	// Defaults to defaultPageSize if zero. Only used with WrapWithList.
+	PageSize int
+
 	//
 	// Private fields
 	//
@@ -93,6 +121,12 @@ type GenerateParams struct {
 type DataGenerator struct {
 	definitions map[string]*spec.Schema
 	fixtures    *spec.Fixtures
+
+	// rand varies generated data, like the IDs of the items of a list and
+	// synthetic values.
+	//
+	// nil if generated data should be the same every time.
+	rand *rand.Rand
 }
 
 // Generate generates a fixture response.
@@ -142,14 +176,20 @@ func (g *DataGenerator) Generate(dataSchema *spec.Schema, metaSchema *spec.Schem
 		}
 	}
 
-	if params.PathParams != nil {
+	pathParams := params.PathParams
+	if params.NewIDRand != nil {
+		pathParams = mintPrimaryID(pathParams, dataSchema.FlattenAllOf(), data,
+			params.NewIDRand)
+	}
+
+	if pathParams != nil {
 		// Passses through the generated data and replaces IDs that existed in
 		// the fixtures with IDs that were extracted from the request path, if
 		// and where appropriate.
 		//
 		// Note that the path params are mutated by the function, but we return
 		// them anyway to make the control flow here more clear.
-		pathParams := recordAndReplaceIDs(params.PathParams, data)
+		pathParams = recordAndReplaceIDs(pathParams, data)
 
 		// Passes through the generated data again to replace the values of any old
 		// IDs that we replaced. This is a separate step because IDs could have
@@ -166,8 +206,51 @@ func (g *DataGenerator) Generate(dataSchema *spec.Schema, metaSchema *spec.Schem
 	}
 
 	if params.WrapWithList {
+		listSize := params.ListSize
+		if listSize == 0 {
+			listSize = defaultListSize
+		}
+
+		pageNumber := params.PageNumber
+		if pageNumber == 0 {
+			pageNumber = 1
+		}
+
+		pageSize := params.PageSize
+		if pageSize == 0 {
+			pageSize = defaultPageSize
+		}
+
+		filters := parseFilters(params.RequestData)
+		itemSchema := dataSchema.FlattenAllOf()
+
+		items := make([]interface{}, listSize)
+		for i := range items {
+			item := deepCopy(data)
+			cycleEnumProperties(item, itemSchema, i)
+
+			// The first item is left alone so that it still matches
+			// fixtures and path parameters.
+			if g.rand != nil && i > 0 {
+				varyListItem(item, g.rand)
+			}
+
+			// Reflect the values of any filters into every other item so
+			// that filtering narrows the list down instead of emptying it.
+			if i%2 == 0 {
+				for _, filter := range filters {
+					filter.reflect(item)
+				}
+			}
+
+			items[i] = item
+		}
+
+		items = applyFilters(items, filters)
+		setPageMeta(meta, len(items), pageNumber, pageSize)
+
 		nestedData := map[string]interface{}{
-			"data": []interface{}{data},
+			"data": paginate(items, pageNumber, pageSize),
 			"meta": meta,
 		}
 		return nestedData, nil
@@ -344,7 +427,7 @@ func (g *DataGenerator) generateInternal(params *GenerateParams) (interface{}, e
 
 	// Generate a synthethic schema as a last ditch effort
 	if example == nil && schema.XResourceID == "" {
-		example = &valueWrapper{value: g.generateSyntheticFixture(schema, context)}
+		example = &valueWrapper{value: g.generateSyntheticFixture(schema, "", context)}
 
 		context = fmt.Sprintf("%sGenerated synthetic fixture: %+v\n", context, schema)
 
@@ -529,7 +612,9 @@ func (g *DataGenerator) generateListResource(params *GenerateParams) (interface{
 }
 
 // generateSyntheticFixture generates a synthetic fixture for the given schema
-// by examining its properties and returning default values for each.
+// by examining its properties and returning realistic values for each. name is
+// the name of the property that the schema is for, if any, which hints at what
+// its value should look like.
 //
 // This is useful in cases where we don't have a valid fixture for some object.
 // That could happen for a prerelease object or in cases where an expansion has
@@ -539,7 +624,7 @@ func (g *DataGenerator) generateListResource(params *GenerateParams) (interface{
 // This function calls itself recursively by initially iterating through every
 // property in an object schema, then recursing and returning values for
 // embedded objects and scalars.
-func (g *DataGenerator) generateSyntheticFixture(schema *spec.Schema, context string) interface{} {
+func (g *DataGenerator) generateSyntheticFixture(schema *spec.Schema, name string, context string) interface{} {
 	context = fmt.Sprintf("%sGenerating synthetic fixture: %+v\n", context, schema)
 
 	// Always try to use the user provided example first
@@ -560,7 +645,7 @@ func (g *DataGenerator) generateSyntheticFixture(schema *spec.Schema, context st
 			panic(err)
 		}
 
-		return g.generateSyntheticFixture(resolved, context)
+		return g.generateSyntheticFixture(resolved, name, context)
 	}
 
 	// Return a member of an enum if one is available because it's probably
@@ -575,12 +660,19 @@ func (g *DataGenerator) generateSyntheticFixture(schema *spec.Schema, context st
 			if subSchema.Ref != "" {
 				continue
 			}
-			return g.generateSyntheticFixture(subSchema, context)
+			return g.generateSyntheticFixture(subSchema, name, context)
 		}
 		panic(fmt.Sprintf("%sCouldn't find an anyOf branch to take", context))
 	}
 
-	switch schema.Type {
+	// Schemas with properties describe objects even if they don't say so,
+	// like the error responses in the spec.
+	schemaType := schema.Type
+	if schemaType == "" && len(schema.Properties) > 0 {
+		schemaType = spec.TypeObject
+	}
+
+	switch schemaType {
 	case spec.TypeArray:
 		return []string{}
 
@@ -588,20 +680,20 @@ func (g *DataGenerator) generateSyntheticFixture(schema *spec.Schema, context st
 		return true
 
 	case spec.TypeInteger:
-		return 0
+		return generateSyntheticInteger(schema)
 
 	case spec.TypeNumber:
-		return 0.0
+		return float64(generateSyntheticInteger(schema))
 
 	case spec.TypeObject:
 		fixture := make(map[string]interface{})
 		for property, subSchema := range schema.Properties {
-			fixture[property] = g.generateSyntheticFixture(subSchema, context)
+			fixture[property] = g.generateSyntheticFixture(subSchema, property, context)
 		}
 		return fixture
 
 	case spec.TypeString:
-		return ""
+		return generateSyntheticString(schema, name, g.rand)
 	}
 
 	panic(fmt.Sprintf("%sUnhandled type: %s", context, stringOrEmpty(schema.Type)))
@@ -771,6 +863,55 @@ func logReplacedID(prevID, newID string) {
 		prevID, newID)
 }
 
+// mintPrimaryID gives a resource that's being created a new ID by setting it
+// as the primary ID in pathParams, as if it had been in the request path, so
+// that it's replaced like one would be.
+//
+// The new ID has the format that the resource's schema declares for it, or
+// failing that, the same shape as the generated one. Calls are the exception:
+// they don't have an `id`, so they're given a new call control ID directly.
+//
+// pathParams itself is left alone, since the caller may still need the IDs
+// that were in the request path. Returns pathParams if there's no new ID to
+// set, or a copy of it (or a new PathParamsMap if it was nil) with the new ID.
+func mintPrimaryID(pathParams *PathParamsMap, schema *spec.Schema, data interface{},
+	rng *rand.Rand) *PathParamsMap {
+
+	dataMap, ok := data.(map[string]interface{})
+	if !ok {
+		return pathParams
+	}
+
+	if _, ok := dataMap["call_control_id"].(string); ok {
+		if _, ok := dataMap["id"]; !ok {
+			dataMap["call_control_id"] = randomCallControlID(rng)
+			return pathParams
+		}
+	}
+
+	id, ok := dataMap["id"].(string)
+	if !ok || id == "" {
+		return pathParams
+	}
+
+	var newID string
+	if idSchema, ok := schema.Properties["id"]; ok && idSchema != nil {
+		if generate, ok := stringFormatGenerators[idSchema.Format]; ok {
+			newID = generate("id", rng)
+		}
+	}
+	if newID == "" {
+		newID = varyID(id, rng)
+	}
+
+	minted := &PathParamsMap{}
+	if pathParams != nil {
+		*minted = *pathParams
+	}
+	minted.PrimaryID = &newID
+	return minted
+}
+
 // propertyNames returns the names of all properties of a schema joined
 // together and comma-separated.
 //
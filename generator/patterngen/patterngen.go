@@ -0,0 +1,123 @@
+package patterngen
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// Generate produces a string that matches the given regular expression.
+//
+// Generation is deterministic and aims for short, readable strings: optional
+// and repeated parts are produced as few times as the pattern allows, the
+// first branch of an alternation is always taken, and character classes
+// produce their first printable character (preferring letters and digits).
+//
+// An error is returned if the pattern can't be parsed, or if it uses a
+// construct (like a negative lookahead or word boundary) that makes the
+// generated string fail to match.
+func Generate(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	generate(&builder, re.Simplify())
+	generated := builder.String()
+
+	matched, err := regexp.MatchString(pattern, generated)
+	if err != nil {
+		return "", err
+	}
+	if !matched {
+		return "", fmt.Errorf("couldn't generate a string matching %q", pattern)
+	}
+
+	return generated, nil
+}
+
+//
+// Private values
+//
+
+// preferredRunes are tried in order when picking a character from a class so
+// that classes like `[A-Za-z0-9_]` produce something that reads naturally.
+var preferredRunes = []rune{'a', 'A', '0', 'x', 'X', '1'}
+
+//
+// Private functions
+//
+
+func generate(builder *strings.Builder, re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			builder.WriteRune(r)
+		}
+
+	case syntax.OpCharClass:
+		builder.WriteRune(pickRune(re.Rune))
+
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		builder.WriteRune(preferredRunes[0])
+
+	case syntax.OpCapture:
+		generate(builder, re.Sub[0])
+
+	case syntax.OpPlus:
+		generate(builder, re.Sub[0])
+
+	case syntax.OpRepeat:
+		for i := 0; i < re.Min; i++ {
+			generate(builder, re.Sub[0])
+		}
+
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			generate(builder, sub)
+		}
+
+	case syntax.OpAlternate:
+		generate(builder, re.Sub[0])
+
+		// Everything else (optional and starred parts, anchors, and
+		// boundaries) produces nothing.
+	}
+}
+
+// pickRune picks a rune from a character class, given as pairs of inclusive
+// ranges like syntax.Regexp.Rune.
+func pickRune(ranges []rune) rune {
+	inClass := func(r rune) bool {
+		for i := 0; i+1 < len(ranges); i += 2 {
+			if r >= ranges[i] && r <= ranges[i+1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, r := range preferredRunes {
+		if inClass(r) {
+			return r
+		}
+	}
+
+	// Otherwise take the first printable character.
+	for i := 0; i+1 < len(ranges); i += 2 {
+		low := ranges[i]
+		if low < ' ' {
+			low = ' '
+		}
+		if low <= ranges[i+1] {
+			return low
+		}
+	}
+
+	if len(ranges) > 0 {
+		return ranges[0]
+	}
+	return preferredRunes[0]
+}
@@ -0,0 +1,43 @@
+package patterngen
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestGenerate(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		expected string
+	}{
+		{`^[A-Z]{2}$`, "AA"},
+		{`^\+[1-9]\d{1,14}$`, "+10"},
+		{`^(foo|bar)-[a-f0-9]{4}$`, "foo-aaaa"},
+		{`^[^a-z]+$`, "A"},
+		{`^v\d+(\.\d+)?$`, "v0"},
+		{`.*`, ""},
+		{`^KEY[0-9A-Z_]{3,}$`, "KEYAAA"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.pattern, func(t *testing.T) {
+			generated, err := Generate(testCase.pattern)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, generated)
+		})
+	}
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	_, err := Generate(`[`)
+	assert.Error(t, err)
+
+	// A word boundary between two non-word characters can't be satisfied.
+	_, err = Generate(`^-\b-$`)
+	assert.Error(t, err)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"strings"
 	"sync"
@@ -50,7 +51,7 @@ func TestConcurrentAccess(t *testing.T) {
 
 	// We use the real spec here because when there was a concurrency problem,
 	// it wasn't revealed due to the test spec being oversimplistic.
-	generator = DataGenerator{realSpec.Components.Schemas, &realFixtures}
+	generator = DataGenerator{realSpec.Components.Schemas, &realFixtures, nil}
 
 	var wg sync.WaitGroup
 
@@ -73,7 +74,7 @@ func TestGenerateResponseData(t *testing.T) {
 	t.Skip("skipping test; fixtures not used. Use examples in spec instead.")
 	// basic reference
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures}
+		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, nil}
 		schema := &spec.Schema{Ref: "#/components/schemas/charge"}
 		data, err := generator.Generate(schema, nil, &GenerateParams{})
 		assert.Nil(t, err)
@@ -89,7 +90,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// expansion
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures}
+		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, nil}
 		schema := &spec.Schema{Ref: "#/components/schemas/charge"}
 		data, err := generator.Generate(schema, nil, &GenerateParams{
 			Expansions: &ExpansionLevel{
@@ -107,7 +108,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// bad expansion
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures}
+		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, nil}
 		schema := &spec.Schema{Ref: "#/components/schemas/charge"}
 		_, err := generator.Generate(schema, nil, &GenerateParams{
 			Expansions: &ExpansionLevel{
@@ -122,7 +123,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// bad nested expansion
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures}
+		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, nil}
 		schema := &spec.Schema{Ref: "#/components/schemas/charge"}
 		_, err := generator.Generate(schema, nil, &GenerateParams{
 			Expansions: &ExpansionLevel{
@@ -136,7 +137,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// wildcard expansion
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures}
+		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, nil}
 		schema := &spec.Schema{Ref: "#/components/schemas/charge"}
 		data, err := generator.Generate(schema, nil, &GenerateParams{
 			Expansions: &ExpansionLevel{wildcard: true},
@@ -149,7 +150,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// list
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures}
+		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, nil}
 		data, err := generator.Generate(listSchema, nil, &GenerateParams{
 			RequestPath: "/v2/charges",
 		})
@@ -175,6 +176,7 @@ func TestGenerateResponseData(t *testing.T) {
 					},
 				},
 			},
+			nil,
 		}
 		schema := &spec.Schema{
 			Type: "object",
@@ -207,7 +209,7 @@ func TestGenerateResponseData(t *testing.T) {
 					"id": "ch_123",
 				},
 			},
-		}}
+		}, nil}
 		newID := "ch_123_InjectedFromURL"
 		schema := &spec.Schema{Ref: "#/components/schemas/charge"}
 		data, err := generator.Generate(schema, nil, &GenerateParams{
@@ -234,7 +236,7 @@ func TestGenerateResponseData(t *testing.T) {
 					"object": "customer",
 				},
 			},
-		}}
+		}, nil}
 		newCustomerID := "cus_123_InjectedFromURL"
 		schema := &spec.Schema{Ref: "#/components/schemas/charge"}
 		data, err := generator.Generate(schema, nil, &GenerateParams{
@@ -267,7 +269,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// data replacement on `POST`
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures}
+		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, nil}
 		schema := &spec.Schema{Ref: "#/components/schemas/charge"}
 		data, err := generator.Generate(schema, nil, &GenerateParams{
 			RequestData: map[string]interface{}{
@@ -283,7 +285,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// *no* data replacement on non-`POST`
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures}
+		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, nil}
 		schema := &spec.Schema{Ref: "#/components/schemas/charge"}
 		data, err := generator.Generate(schema, nil, &GenerateParams{
 			RequestData: map[string]interface{}{
@@ -299,7 +301,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// synthetic schema
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures}
+		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, nil}
 		schema := &spec.Schema{
 			Properties: map[string]*spec.Schema{
 				"string_property": {
@@ -322,7 +324,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// pick non-deleted anyOf branch
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures}
+		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, nil}
 		schema := &spec.Schema{AnyOf: []*spec.Schema{
 			// put the deleted version first so we know it's not just
 			// returning the first result
@@ -342,7 +344,7 @@ func TestGenerateResponseData(t *testing.T) {
 
 	// pick deleted anyOf branch
 	{
-		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures}
+		generator := DataGenerator{testSpec.Components.Schemas, &testFixtures, nil}
 		schema := &spec.Schema{AnyOf: []*spec.Schema{
 			// put the non-deleted version first so we know it's not just
 			// returning the first result
@@ -383,7 +385,7 @@ func TestGenerateResponseData(t *testing.T) {
             }
         }
 
-        generator := DataGenerator{schemas, &testFixtures}
+        generator := DataGenerator{schemas, &testFixtures, nil}
         schema := &spec.Schema{Ref: "#/components/schemas/charge"}
         data, err := generator.Generate(schema, nil, &GenerateParams{
             RequestMethod: http.MethodPost,
@@ -633,7 +635,7 @@ func TestFindAnyOfBranch(t *testing.T) {
 		},
 	}
 
-	generator := DataGenerator{nil, nil}
+	generator := DataGenerator{nil, nil, nil}
 
 	// Finds a deleted schema branch
 	{
@@ -658,26 +660,26 @@ func TestFindAnyOfBranch(t *testing.T) {
 }
 
 func TestGenerateSyntheticFixture(t *testing.T) {
-	generator := DataGenerator{nil, nil}
+	generator := DataGenerator{nil, nil, nil}
 
 	// Scalars (and an array, which is easy)
-	assert.Equal(t, []string{}, generator.generateSyntheticFixture(&spec.Schema{Type: spec.TypeArray}, ""))
-	assert.Equal(t, true, generator.generateSyntheticFixture(&spec.Schema{Type: spec.TypeBoolean}, ""))
-	assert.Equal(t, 0, generator.generateSyntheticFixture(&spec.Schema{Type: spec.TypeInteger}, ""))
-	assert.Equal(t, 0.0, generator.generateSyntheticFixture(&spec.Schema{Type: spec.TypeNumber}, ""))
-	assert.Equal(t, "", generator.generateSyntheticFixture(&spec.Schema{Type: spec.TypeString}, ""))
+	assert.Equal(t, []string{}, generator.generateSyntheticFixture(&spec.Schema{Type: spec.TypeArray}, "", ""))
+	assert.Equal(t, true, generator.generateSyntheticFixture(&spec.Schema{Type: spec.TypeBoolean}, "", ""))
+	assert.Equal(t, 0, generator.generateSyntheticFixture(&spec.Schema{Type: spec.TypeInteger}, "", ""))
+	assert.Equal(t, 0.0, generator.generateSyntheticFixture(&spec.Schema{Type: spec.TypeNumber}, "", ""))
+	assert.Equal(t, "", generator.generateSyntheticFixture(&spec.Schema{Type: spec.TypeString}, "", ""))
 
 	// Nullable property
 	assert.Equal(t, nil, generator.generateSyntheticFixture(&spec.Schema{
 		Nullable: true,
 		Type:     spec.TypeString,
-	}, ""))
+	}, "", ""))
 
 	// Property with enum
 	assert.Equal(t, "list", generator.generateSyntheticFixture(&spec.Schema{
 		Enum: []interface{}{"list"},
 		Type: spec.TypeString,
-	}, ""))
+	}, "", ""))
 
 	// Takes the first non-reference branch of an anyOf
 	assert.Equal(t, "", generator.generateSyntheticFixture(&spec.Schema{
@@ -685,7 +687,7 @@ func TestGenerateSyntheticFixture(t *testing.T) {
 			{Ref: "#/components/schemas/radar_rule"},
 			{Type: spec.TypeString},
 		},
-	}, ""))
+	}, "", ""))
 
 	// Object
 	assert.Equal(t,
@@ -693,7 +695,7 @@ func TestGenerateSyntheticFixture(t *testing.T) {
 			"has_more":    true,
 			"object":      "list",
 			"total_count": 0,
-			"url":         "",
+			"url":         "https://example.com/url",
 		},
 		generator.generateSyntheticFixture(&spec.Schema{
 			Type: "object",
@@ -711,8 +713,137 @@ func TestGenerateSyntheticFixture(t *testing.T) {
 					Type: "string",
 				},
 			},
-		}, ""),
+		}, "", ""),
+	)
+
+	// Object without a type
+	assert.Equal(t,
+		map[string]interface{}{"errors": []string{}},
+		generator.generateSyntheticFixture(&spec.Schema{
+			Properties: map[string]*spec.Schema{
+				"errors": {Type: spec.TypeArray},
+			},
+		}, "", ""),
 	)
+
+	// Formats
+	assert.Equal(t, "2020-01-01T12:00:00Z", generator.generateSyntheticFixture(&spec.Schema{
+		Format: "date-time",
+		Type:   spec.TypeString,
+	}, "", ""))
+	assert.Equal(t, "https://example.com/webhook-url", generator.generateSyntheticFixture(&spec.Schema{
+		Format: "url",
+		Type:   spec.TypeString,
+	}, "webhook_url", ""))
+
+	uuid := generator.generateSyntheticFixture(&spec.Schema{
+		Format: "uuid",
+		Type:   spec.TypeString,
+	}, "id", "").(string)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, uuid)
+	assert.NotEqual(t, uuid, generator.generateSyntheticFixture(&spec.Schema{
+		Format: "uuid",
+		Type:   spec.TypeString,
+	}, "connection_id", ""))
+
+	// Patterns take precedence over formats
+	assert.Equal(t, "AA", generator.generateSyntheticFixture(&spec.Schema{
+		Format:  "iso4217",
+		Pattern: "^[A-Z]{2}$",
+		Type:    spec.TypeString,
+	}, "", ""))
+
+	// Property names
+	assert.Equal(t, "+13125550100", generator.generateSyntheticFixture(&spec.Schema{
+		Type: spec.TypeString,
+	}, "phone_number", ""))
+	assert.Equal(t, "2020-01-01T12:00:00Z", generator.generateSyntheticFixture(&spec.Schema{
+		Type: spec.TypeString,
+	}, "created_at", ""))
+
+	// Bounds
+	assert.Equal(t, "xxx", generator.generateSyntheticFixture(&spec.Schema{
+		MinLength: 3,
+		Type:      spec.TypeString,
+	}, "", ""))
+	assert.Equal(t, 5, generator.generateSyntheticFixture(&spec.Schema{
+		Minimum: 5,
+		Maximum: 10,
+		Type:    spec.TypeInteger,
+	}, "", ""))
+	assert.Equal(t, -10, generator.generateSyntheticFixture(&spec.Schema{
+		Maximum: -10,
+		Type:    spec.TypeInteger,
+	}, "", ""))
+	assert.Equal(t, 1.0, generator.generateSyntheticFixture(&spec.Schema{
+		Minimum: 1,
+		Type:    spec.TypeNumber,
+	}, "", ""))
+	assert.Equal(t, 1, generator.generateSyntheticFixture(&spec.Schema{
+		ExclusiveMinimum: true,
+		Type:             spec.TypeInteger,
+	}, "", ""))
+	assert.Equal(t, 9, generator.generateSyntheticFixture(&spec.Schema{
+		ExclusiveMaximum: true,
+		Maximum:          10,
+		Minimum:          10,
+		Type:             spec.TypeInteger,
+	}, "", ""))
+	assert.Equal(t, "AAx", generator.generateSyntheticFixture(&spec.Schema{
+		MinLength: 3,
+		Pattern:   "^[A-Z]{2}$",
+		Type:      spec.TypeString,
+	}, "", ""))
+	assert.Equal(t, "https://", generator.generateSyntheticFixture(&spec.Schema{
+		Format:    "url",
+		MaxLength: 8,
+		Type:      spec.TypeString,
+	}, "webhook_url", ""))
+
+	// Phone numbers vary with a PRNG, and only within the numbers reserved
+	// for fiction.
+	seeded := func(seed int64) interface{} {
+		generator := DataGenerator{nil, nil, rand.New(rand.NewSource(seed))}
+		return generator.generateSyntheticFixture(&spec.Schema{
+			Format: "e164",
+			Type:   spec.TypeString,
+		}, "phone_number", "")
+	}
+	assert.Regexp(t, `^\+131255501\d{2}$`, seeded(1))
+	assert.Equal(t, seeded(1), seeded(1))
+	assert.NotEqual(t, seeded(1), seeded(2))
+}
+
+func TestMintPrimaryID(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	schema := &spec.Schema{
+		Properties: map[string]*spec.Schema{
+			"id": {Type: "string", Format: "int64"},
+		},
+	}
+
+	// The format in the schema wins over the generated ID's shape.
+	pathParams := mintPrimaryID(nil, schema, map[string]interface{}{"id": "abc_123"}, rng)
+	assert.Regexp(t, `^[1-9][0-9]{15}$`, *pathParams.PrimaryID)
+
+	// Without a format, the new ID looks like the generated one.
+	secondaryIDs := []*PathParamsSecondaryID{{ID: "123", Name: "id"}}
+	requestPathParams := &PathParamsMap{SecondaryIDs: secondaryIDs}
+	pathParams = mintPrimaryID(requestPathParams, &spec.Schema{},
+		map[string]interface{}{"id": "abc_123"}, rng)
+	assert.Regexp(t, `^abc_[1-9][0-9]{2}$`, *pathParams.PrimaryID)
+	assert.Equal(t, secondaryIDs, pathParams.SecondaryIDs)
+
+	// The path params from the request are left as they were.
+	assert.Nil(t, requestPathParams.PrimaryID)
+
+	// Calls get a new call control ID in place.
+	call := map[string]interface{}{"call_control_id": "428c31b6"}
+	pathParams = mintPrimaryID(nil, &spec.Schema{}, call, rng)
+	assert.Nil(t, pathParams)
+	assert.Regexp(t, `^v2:[a-zA-Z0-9]{51}$`, call["call_control_id"])
+
+	assert.Nil(t, mintPrimaryID(nil, &spec.Schema{}, []interface{}{}, rng))
 }
 
 func TestPropertyNames(t *testing.T) {
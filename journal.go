@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// Journal records the requests that telnyx-mock has handled along with its
+// responses to them so that tests can assert on what was sent.
+//
+// It holds a bounded number of entries, forgetting the oldest ones as new
+// ones are recorded. It's safe for concurrent use.
+type Journal struct {
+	mu       sync.Mutex
+	capacity int
+	entries  []*JournalEntry
+
+	// lastID is the ID of the most recently recorded entry.
+	lastID int
+
+	// now returns the current time. It's a field so that the passage of time
+	// can be controlled.
+	now func() time.Time
+}
+
+// NewJournal initializes a new, empty Journal that holds up to capacity
+// entries.
+func NewJournal(capacity int) *Journal {
+	return &Journal{
+		capacity: capacity,
+		now:      time.Now,
+	}
+}
+
+// List returns the recorded entries that match a filter, oldest first.
+func (j *Journal) List(filter *JournalFilter) []*JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := make([]*JournalEntry, 0)
+	for _, entry := range j.entries {
+		if filter == nil || filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Record adds an entry to the journal, assigning it an ID and the time that
+// it was recorded at.
+func (j *Journal) Record(entry *JournalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.lastID++
+	entry.ID = j.lastID
+	entry.RecordedAt = j.now().UTC()
+
+	j.entries = append(j.entries, entry)
+	if overflow := len(j.entries) - j.capacity; overflow > 0 {
+		// Copy rather than reslice so that forgotten entries can be garbage
+		// collected.
+		j.entries = append([]*JournalEntry(nil), j.entries[overflow:]...)
+	}
+}
+
+// Reset forgets every entry.
+func (j *Journal) Reset() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.entries = nil
+	j.lastID = 0
+}
+
+// JournalEntry is a request that telnyx-mock handled along with its response.
+type JournalEntry struct {
+	ID         int       `json:"id"`
+	RecordedAt time.Time `json:"recorded_at"`
+
+	Method  string      `json:"method"`
+	Path    string      `json:"path"`
+	Headers http.Header `json:"headers"`
+
+	// RequestData is the request's query and body parameters as they were
+	// parsed and coerced for validation. It's nil if they couldn't be
+	// parsed.
+	RequestData map[string]interface{} `json:"request_data"`
+
+	// OperationID and Route identify the operation in the spec that the
+	// request was routed to. Both are empty if it wasn't routed to one.
+	OperationID string `json:"operation_id,omitempty"`
+	Route       string `json:"route,omitempty"`
+
+	Status int `json:"status"`
+
+	// ResponseBody is the decoded body of the response if it was JSON, and
+	// the body as a string otherwise.
+	ResponseBody interface{} `json:"response_body"`
+}
+
+// JournalFilter selects the entries of a Journal to list. Empty fields
+// match every entry.
+type JournalFilter struct {
+	Method      string
+	OperationID string
+
+	// Path is either a request's path, or the path of an operation as it
+	// appears in the spec. The `/v2` prefix is optional.
+	Path string
+
+	Status int
+
+	// RequestData are values that parameters of the request must have,
+	// keyed by their paths within the request data. Dots separate the
+	// segments of a path, as in `to` or `media_urls`.
+	RequestData map[string]string
+}
+
+// matches returns whether an entry passes the filter.
+func (f *JournalFilter) matches(entry *JournalEntry) bool {
+	if f.Method != "" && !strings.EqualFold(f.Method, entry.Method) {
+		return false
+	}
+
+	if f.OperationID != "" && f.OperationID != entry.OperationID {
+		return false
+	}
+
+	if f.Path != "" {
+		path := strings.TrimPrefix(f.Path, "/v2")
+		if path != strings.TrimPrefix(entry.Path, "/v2") && path != entry.Route {
+			return false
+		}
+	}
+
+	if f.Status != 0 && f.Status != entry.Status {
+		return false
+	}
+
+	for field, value := range f.RequestData {
+		filter := &listFilter{
+			field:    strings.Split(field, "."),
+			operator: filterOperatorEq,
+			values:   []string{value},
+		}
+		if !filter.matches(entry.RequestData) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//
+// Private values
+//
+
+// defaultJournalSize is the number of requests that the journal holds unless
+// configured otherwise.
+const defaultJournalSize = 1000
+
+//
+// Private types
+//
+
+// responseRecorder is an http.ResponseWriter that keeps a copy of the
+// response that's written through it so that it can be journaled.
+type responseRecorder struct {
+	http.ResponseWriter
+
+	body   bytes.Buffer
+	status int
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// decodedBody returns the recorded body decoded from JSON, or as a string if
+// it isn't JSON.
+func (r *responseRecorder) decodedBody() interface{} {
+	var body interface{}
+	if err := json.Unmarshal(r.body.Bytes(), &body); err != nil {
+		return r.body.String()
+	}
+	return body
+}
+
+//
+// Private functions
+//
+
+// parseJournalFilter builds a filter from the query of a request to list
+// journal entries: `method`, `path`, `operation_id`, and `status` filter on
+// the properties of the same names, and `data[<field>]` on request data.
+func parseJournalFilter(query url.Values) (*JournalFilter, *ResponseError) {
+	filter := &JournalFilter{
+		Method:      query.Get("method"),
+		OperationID: query.Get("operation_id"),
+		Path:        query.Get("path"),
+		RequestData: make(map[string]string),
+	}
+
+	if status := query.Get("status"); status != "" {
+		var err error
+		filter.Status, err = strconv.Atoi(status)
+		if err != nil {
+			return nil, createTelnyxErrorWithSource(errorCodeBadRequest,
+				"`status` should be an HTTP status code like `200`.",
+				&ResponseErrorSource{Parameter: "status"})
+		}
+	}
+
+	for key, values := range query {
+		if strings.HasPrefix(key, "data[") && strings.HasSuffix(key, "]") && len(values) > 0 {
+			field := strings.TrimSuffix(strings.TrimPrefix(key, "data["), "]")
+			filter.RequestData[field] = values[0]
+		}
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_Journal(t *testing.T) {
+	server := &StubServer{
+		spec:     &realSpec,
+		fixtures: &realFixtures,
+		journal:  NewJournal(defaultJournalSize),
+	}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	sendMessage := func(to string) {
+		resp, _ := sendRequestToServer(t, server, "POST", "/v2/messages",
+			`{"from": "+13125550001", "to": "`+to+`", "text": "Hello"}`,
+			getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	listRequests := func(query string) []map[string]interface{} {
+		resp, body := sendRequestToServer(t, server, "GET", "/_mock/requests"+query, "", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data struct {
+			Data []map[string]interface{} `json:"data"`
+			Meta struct {
+				TotalResults int `json:"total_results"`
+			} `json:"meta"`
+		}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+		assert.Equal(t, len(data.Data), data.Meta.TotalResults)
+		return data.Data
+	}
+
+	sendMessage("+13125550002")
+	sendMessage("+13125550003")
+
+	resp, _ := sendRequestToServer(t, server, "GET", "/v2/messaging_profiles", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Requests to the admin API aren't journaled.
+	assert.Equal(t, 3, len(listRequests("")))
+	assert.Equal(t, 3, len(listRequests("")))
+
+	entries := listRequests("?method=POST&path=/v2/messages&data[to]=%2B13125550003")
+	assert.Equal(t, 1, len(entries))
+
+	entry := entries[0]
+	assert.Equal(t, 2.0, entry["id"])
+	assert.Equal(t, "POST", entry["method"])
+	assert.Equal(t, "/v2/messages", entry["path"])
+	assert.Equal(t, "createMessage", entry["operation_id"])
+	assert.Equal(t, "/messages", entry["route"])
+	assert.Equal(t, 200.0, entry["status"])
+	assert.Equal(t, "+13125550003", entry["request_data"].(map[string]interface{})["to"])
+	assert.Equal(t, []interface{}{"Bearer KEYSUPERSECRET"},
+		entry["headers"].(map[string]interface{})["Authorization"])
+	assert.NotNil(t, entry["response_body"].(map[string]interface{})["data"])
+
+	assert.Equal(t, 2, len(listRequests("?operation_id=createMessage")))
+	assert.Equal(t, 1, len(listRequests("?status=401")))
+	assert.Equal(t, 0, len(listRequests("?method=GET&path=/messages")))
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/_mock/requests?status=ok", "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Requests answered with a requested status before they'd be handled
+	// are journaled along with their request data.
+	headers := getDefaultHeaders()
+	headers[headerResponseStatus] = "429"
+	resp, _ = sendRequestToServer(t, server, "POST", "/v2/messages",
+		`{"from": "+13125550001", "to": "+13125550004", "text": "Hello"}`, headers)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	entries = listRequests("?data[to]=%2B13125550004")
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, 429.0, entries[0]["status"])
+
+	resp, _ = sendRequestToServer(t, server, "DELETE", "/_mock/requests", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, len(listRequests("")))
+
+	// Without a journal, its endpoint explains how to enable it.
+	server.journal = nil
+	resp, _ = sendRequestToServer(t, server, "GET", "/_mock/requests", "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestJournal_Record(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	journal := NewJournal(2)
+	journal.now = func() time.Time { return now }
+
+	for _, path := range []string{"/v2/calls", "/v2/messages", "/v2/phone_numbers"} {
+		journal.Record(&JournalEntry{Method: "GET", Path: path})
+	}
+
+	// Only the most recent entries are kept.
+	entries := journal.List(nil)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, 2, entries[0].ID)
+	assert.Equal(t, "/v2/messages", entries[0].Path)
+	assert.Equal(t, 3, entries[1].ID)
+	assert.Equal(t, now.UTC(), entries[1].RecordedAt)
+
+	journal.Reset()
+	assert.Equal(t, 0, len(journal.List(nil)))
+
+	journal.Record(&JournalEntry{Method: "GET", Path: "/v2/calls"})
+	assert.Equal(t, 1, journal.List(nil)[0].ID)
+}
+
+//
+// Tests for private functions
+//
+
+func TestParseJournalFilter(t *testing.T) {
+	query, err := url.ParseQuery("method=post&path=/calls&status=200&data[to]=%2B1&data[from.carrier]=x")
+	assert.NoError(t, err)
+
+	filter, telnyxError := parseJournalFilter(query)
+	assert.Nil(t, telnyxError)
+	assert.Equal(t, &JournalFilter{
+		Method: "post",
+		Path:   "/calls",
+		Status: 200,
+		RequestData: map[string]string{
+			"from.carrier": "x",
+			"to":           "+1",
+		},
+	}, filter)
+
+	assert.True(t, filter.matches(&JournalEntry{
+		Method: "POST",
+		Path:   "/v2/calls",
+		Status: 200,
+		RequestData: map[string]interface{}{
+			"from": map[string]interface{}{"carrier": "x"},
+			"to":   "+1",
+		},
+	}))
+	assert.False(t, filter.matches(&JournalEntry{
+		Method:      "POST",
+		Path:        "/v2/calls",
+		Status:      200,
+		RequestData: map[string]interface{}{"to": "+1"},
+	}))
+}
@@ -5,6 +5,7 @@ package main
 import (
 	"crypto/md5"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -15,10 +16,14 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/team-telnyx/telnyx-mock/spec"
+	"github.com/team-telnyx/telnyx-mock/webhook"
+	yaml "gopkg.in/yaml.v2"
 )
 
 const defaultPortHTTP = 12111
@@ -49,9 +54,35 @@ func main() {
 	flag.IntVar(&options.httpsPort, "https-port", -1, "Port to listen on for HTTPS")
 	flag.StringVar(&options.httpsUnixSocket, "https-unix", "", "Unix socket to listen on for HTTPS")
 
-	flag.StringVar(&options.fixturesPath, "fixtures", "", "Path to fixtures to use instead of bundled version (should be JSON)")
-	flag.StringVar(&options.specPath, "spec", "", "Path to OpenAPI spec to use instead of the latest version (should be JSON)")
+	flag.DurationVar(&options.callRingingDelay, "call-ringing-delay", defaultCallRingingDelay, "Time after a call is dialed that it starts ringing, with -stateful")
+
+	flag.IntVar(&options.journalSize, "journal-size", defaultJournalSize, "Number of requests (and webhook delivery attempts) to keep in the journal served at /_mock/requests (0 disables it)")
+	flag.IntVar(&options.listSize, "list-size", defaultListSize, "Total number of resources that generated lists have to page through")
+
+	flag.StringVar(&options.cassettePath, "cassette", "", "Path to a cassette to replay recorded responses from (or record them to with -record)")
+	flag.BoolVar(&options.record, "record", false, "Forward requests to -upstream-url and record its responses to -cassette")
+	flag.StringVar(&options.upstreamURL, "upstream-url", defaultUpstreamURL, "Base URL of the API that requests are forwarded to with -record")
+
+	flag.DurationVar(&options.messageFinalizedDelay, "message-finalized-delay", defaultMessageFinalizedDelay, "Time after a message is sent that it reaches its final status, with -stateful")
+	flag.Var(&options.messageOutcomes, "message-outcome", "Final status of messages to destinations that match a pattern, as PATTERN=STATUS (can be repeated), with -stateful")
+	flag.DurationVar(&options.messageSentDelay, "message-sent-delay", defaultMessageSentDelay, "Time after a message is queued that it's sent, with -stateful")
+
+	flag.DurationVar(&options.numberOrderDelay, "number-order-delay", defaultNumberOrderDelay, "Time after a number order is placed that it's fulfilled, with -stateful")
+	flag.StringVar(&options.numberOrderFailures, "number-order-failures", "", "Regular expression matching the numbers that fail to be ordered, with -stateful")
+	flag.DurationVar(&options.numberReservationDuration, "number-reservation-duration", defaultReservationDuration, "Time that a number reservation holds its numbers for, and that actions/extend pushes its expiry back to, with -stateful")
+
+	flag.Int64Var(&options.seed, "seed", 0, "Seed for the random data in generated responses (requests can override it with a Telnyx-Mock-Seed header)")
+	flag.StringVar(&options.fixturesPath, "fixtures", "", "Path to fixtures to use instead of bundled version (should be JSON or YAML)")
+	flag.StringVar(&options.specPath, "spec", "", "Path to OpenAPI spec to use instead of the latest version (should be JSON or YAML)")
 	flag.BoolVar(&options.specSkipCache, "spec-skip-cache", false, "Skip the cache when fetching the live API spec")
+	flag.BoolVar(&options.stateful, "stateful", false, "Persist created, updated, and deleted resources between requests")
+	flag.StringVar(&options.validateResponses, "validate-responses", string(responseValidationOff), "What to do about generated responses that don't conform to their schemas: off, log, or strict (respond with a 500 instead)")
+	flag.StringVar(&options.webhookFailoverURL, "webhook-failover-url", "", "URL to deliver webhooks to when delivery to their URL fails, for requests that don't include a webhook_failover_url")
+	flag.StringVar(&options.webhookPrivateKey, "webhook-private-key", "", "Base64-encoded Ed25519 private key (or its 32-byte seed) to sign webhooks with instead of a generated one")
+	flag.IntVar(&options.webhookRetries, "webhook-retries", defaultWebhookRetries, "Number of times to retry delivering a webhook to a URL before giving up on it")
+	flag.DurationVar(&options.webhookRetryBackoff, "webhook-retry-backoff", defaultWebhookRetryBackoff, "Time to wait before retrying a webhook delivery, which doubles with each retry")
+	flag.DurationVar(&options.webhookTimeout, "webhook-timeout", webhook.DefaultTimeout, "Time to wait for a webhook receiver to respond before retrying")
+	flag.StringVar(&options.webhookURL, "webhook-url", "", "URL to deliver webhooks to for requests that don't include a webhook_url")
 
 	flag.IntVar(&options.port, "port", -1, "Port to listen on (also respects PORT from environment)")
 	flag.StringVar(&options.unixSocket, "unix", "", "Unix socket to listen on")
@@ -86,7 +117,62 @@ func main() {
 
 	telnyxSpec.Flatten()
 
-	stub := StubServer{fixtures: fixtures, spec: telnyxSpec}
+	stub := StubServer{fixtures: fixtures, listSize: options.listSize, seed: options.seed, spec: telnyxSpec}
+	stub.responseValidation, _ = parseResponseValidationMode(options.validateResponses)
+	if options.journalSize > 0 {
+		stub.journal = NewJournal(options.journalSize)
+	}
+	if options.cassettePath != "" {
+		stub.proxy, err = getProxy(options.cassettePath, options.upstreamURL, options.record)
+		if err != nil {
+			abort(err.Error())
+		}
+	}
+	if options.stateful {
+		stub.calls = NewCallRegistry()
+		stub.calls.RingingDelay = options.callRingingDelay
+		stub.store = NewResourceStore()
+
+		stub.messages = NewMessageRegistry()
+		stub.messages.SentDelay = options.messageSentDelay
+		stub.messages.FinalizedDelay = options.messageFinalizedDelay
+		stub.messages.Outcomes = options.messageOutcomes
+
+		stub.numberOrders = NewNumberOrderQueue()
+		stub.numberOrders.Delay = options.numberOrderDelay
+		if options.numberOrderFailures != "" {
+			stub.numberOrders.FailurePattern = regexp.MustCompile(options.numberOrderFailures)
+		}
+
+		stub.reservations = NewReservationRegistry()
+		stub.reservations.Duration = options.numberReservationDuration
+
+		stub.portouts = NewPortoutRegistry()
+	}
+
+	signer, err := getWebhookSigner(options.webhookPrivateKey)
+	if err != nil {
+		abort(err.Error())
+	}
+	fmt.Printf("Signing webhooks with public key: %s\n",
+		base64.StdEncoding.EncodeToString(signer.PublicKey()))
+
+	stub.webhooks = &WebhookEmitter{
+		DefaultURL:         options.webhookURL,
+		DefaultFailoverURL: options.webhookFailoverURL,
+		Retries:            options.webhookRetries,
+		RetryBackoff:       options.webhookRetryBackoff,
+		Sender: &webhook.Sender{
+			Client: &http.Client{Timeout: options.webhookTimeout},
+			Signer: signer,
+		},
+	}
+	if options.journalSize > 0 {
+		stub.webhooks.Log = NewDeliveryLog(options.journalSize)
+	}
+
+	stub.setClock(NewClock())
+
 	err = stub.initializeRouter()
 	if err != nil {
 		abort(fmt.Sprintf("Error initializing router: %v\n", err))
@@ -178,9 +264,30 @@ type options struct {
 	showVersion bool
 	unixSocket  string
 
-	fixturesPath  string
-	specPath      string
-	specSkipCache bool
+	callRingingDelay          time.Duration
+	cassettePath              string
+	fixturesPath              string
+	journalSize               int
+	listSize                  int
+	messageFinalizedDelay     time.Duration
+	messageOutcomes           messageOutcomes
+	messageSentDelay          time.Duration
+	numberOrderDelay          time.Duration
+	numberOrderFailures       string
+	numberReservationDuration time.Duration
+	record                    bool
+	seed                      int64
+	specPath                  string
+	specSkipCache             bool
+	stateful                  bool
+	upstreamURL               string
+	validateResponses         string
+	webhookFailoverURL        string
+	webhookPrivateKey         string
+	webhookRetries            int
+	webhookRetryBackoff       time.Duration
+	webhookTimeout            time.Duration
+	webhookURL                string
 }
 
 func (o *options) checkConflictingOptions() error {
@@ -220,6 +327,46 @@ func (o *options) checkConflictingOptions() error {
 		return fmt.Errorf("Please specify only one of -https-port or -https-unix")
 	}
 
+	if o.record && o.cassettePath == "" {
+		return fmt.Errorf("Please specify a -cassette to record to when using -record")
+	}
+
+	if _, err := parseResponseValidationMode(o.validateResponses); err != nil {
+		return err
+	}
+
+	if o.callRingingDelay < 0 {
+		return fmt.Errorf("Please specify a -call-ringing-delay that isn't negative")
+	}
+
+	if o.journalSize < 0 {
+		return fmt.Errorf("Please specify a -journal-size that isn't negative")
+	}
+
+	if o.listSize < 1 {
+		return fmt.Errorf("Please specify a -list-size that's positive")
+	}
+
+	if o.messageSentDelay < 0 || o.messageFinalizedDelay < 0 {
+		return fmt.Errorf("Please specify message delays that aren't negative")
+	}
+
+	if o.numberOrderDelay < 0 {
+		return fmt.Errorf("Please specify a -number-order-delay that isn't negative")
+	}
+
+	if _, err := regexp.Compile(o.numberOrderFailures); err != nil {
+		return fmt.Errorf("Couldn't parse -number-order-failures: %v", err)
+	}
+
+	if o.numberReservationDuration < 0 {
+		return fmt.Errorf("Please specify a -number-reservation-duration that isn't negative")
+	}
+
+	if o.webhookRetries < 0 {
+		return fmt.Errorf("Please specify a -webhook-retries that isn't negative")
+	}
+
 	return nil
 }
 
@@ -286,6 +433,28 @@ func (o *options) getNonSecureHTTPSListener() (net.Listener, error) {
 	return getPortListenerDefault(o.httpsPortDefault, protocol)
 }
 
+// messageOutcomes collects the outcomes given with -message-outcome, which
+// can be repeated.
+type messageOutcomes []*MessageOutcome
+
+func (o *messageOutcomes) Set(value string) error {
+	outcome, err := parseMessageOutcome(value)
+	if err != nil {
+		return err
+	}
+
+	*o = append(*o, outcome)
+	return nil
+}
+
+func (o *messageOutcomes) String() string {
+	var values []string
+	for _, outcome := range *o {
+		values = append(values, outcome.String())
+	}
+	return strings.Join(values, ",")
+}
+
 //
 // Private functions
 //
@@ -319,8 +488,8 @@ func getFixtures(fixturesPath string) (*spec.Fixtures, error) {
 		// And do the same for fixtures
 		data, err = Asset("openapi/openapi/fixtures3.json")
 	} else {
-		if !isJSONFile(fixturesPath) {
-			return nil, fmt.Errorf("Fixtures should come from a JSON file")
+		if !isJSONFile(fixturesPath) && !isYAMLFile(fixturesPath) {
+			return nil, fmt.Errorf("Fixtures should come from a JSON or YAML file")
 		}
 
 		data, err = ioutil.ReadFile(fixturesPath)
@@ -331,7 +500,7 @@ func getFixtures(fixturesPath string) (*spec.Fixtures, error) {
 	}
 
 	var fixtures spec.Fixtures
-	err = json.Unmarshal(data, &fixtures)
+	err = unmarshalSpecData(fixturesPath, data, &fixtures)
 	if err != nil {
 		return nil, fmt.Errorf("error decoding spec: %v", err)
 	}
@@ -364,6 +533,31 @@ func getPortListenerDefault(defaultPort int, protocol string) (net.Listener, err
 	return getPortListener(defaultPort, protocol)
 }
 
+// getProxy loads the cassette at cassettePath to replay responses from, or
+// to record the responses of the API at upstreamURL to if record is true.
+func getProxy(cassettePath, upstreamURL string, record bool) (*Proxy, error) {
+	cassette, err := LoadCassette(cassettePath)
+	if err != nil {
+		return nil, err
+	}
+
+	upstream, err := url.Parse(upstreamURL)
+	if err != nil || !isURL(upstreamURL) {
+		return nil, fmt.Errorf("invalid upstream URL: %s", upstreamURL)
+	}
+
+	return &Proxy{Cassette: cassette, Record: record, Upstream: upstream}, nil
+}
+
+// getWebhookSigner returns a Signer for the given base64-encoded private key,
+// or one with a newly generated keypair if it's empty.
+func getWebhookSigner(privateKey string) (*webhook.Signer, error) {
+	if privateKey == "" {
+		return webhook.GenerateSigner()
+	}
+	return webhook.ParseSigner(privateKey)
+}
+
 func getSpec(specPath string, skipCache bool) (*spec.Spec, error) {
 	var data []byte
 	var err error
@@ -372,7 +566,7 @@ func getSpec(specPath string, skipCache bool) (*spec.Spec, error) {
 		specPath = liveSpecFile
 	}
 
-	if _, err := url.ParseRequestURI(specPath); err == nil {
+	if isURL(specPath) {
 		fmt.Printf("Downloading API spec file from: %s\n", specPath)
 
 		data, err = downloadSpec(specPath, skipCache)
@@ -381,8 +575,8 @@ func getSpec(specPath string, skipCache bool) (*spec.Spec, error) {
 			return nil, fmt.Errorf("error downloading spec file: %v", err)
 		}
 	} else {
-		if !isJSONFile(specPath) {
-			return nil, fmt.Errorf("spec should come from a JSON file")
+		if !isJSONFile(specPath) && !isYAMLFile(specPath) {
+			return nil, fmt.Errorf("spec should come from a JSON or YAML file")
 		}
 
 		data, err = ioutil.ReadFile(specPath)
@@ -391,6 +585,26 @@ func getSpec(specPath string, skipCache bool) (*spec.Spec, error) {
 		return nil, fmt.Errorf("error loading spec: %v", err)
 	}
 
+	var document map[string]interface{}
+	err = unmarshalSpecData(specPath, data, &document)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding spec: %v", err)
+	}
+
+	// Specs may be split across files that reference each other, so resolve
+	// every reference into a single document before decoding it.
+	err = spec.ResolveRefs(document, specPath, func(location string) (interface{}, error) {
+		return loadSpecDocument(location, skipCache)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error resolving spec references: %v", err)
+	}
+
+	data, err = json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding spec: %v", err)
+	}
+
 	var telnyxSpec spec.Spec
 	err = json.Unmarshal(data, &telnyxSpec)
 	if err != nil {
@@ -434,6 +648,30 @@ func downloadSpec(url string, skipCache bool) ([]byte, error) {
 	return data, nil
 }
 
+// loadSpecDocument loads a file or URL referenced from a spec, decoded from
+// JSON or YAML.
+func loadSpecDocument(location string, skipCache bool) (interface{}, error) {
+	var data []byte
+	var err error
+
+	if isURL(location) {
+		data, err = downloadSpec(location, skipCache)
+	} else {
+		data, err = ioutil.ReadFile(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var document interface{}
+	err = unmarshalSpecData(location, data, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
 func getUnixSocketListener(unixSocket, protocol string) (net.Listener, error) {
 	listener, err := net.Listen("unix", unixSocket)
 	if err != nil {
@@ -450,3 +688,94 @@ func getUnixSocketListener(unixSocket, protocol string) (net.Listener, error) {
 func isJSONFile(path string) bool {
 	return strings.ToLower(filepath.Ext(path)) == ".json"
 }
+
+// isURL judges whether a spec's path is a URL to download it from rather
+// than a file. Absolute paths parse as request URIs too, so only paths with a
+// scheme count.
+func isURL(path string) bool {
+	u, err := url.ParseRequestURI(path)
+	return err == nil && u.Scheme != ""
+}
+
+// isYAMLFile judges based on a file's extension whether it's a YAML file.
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// looksLikeJSON judges based on its first non-whitespace character whether
+// some data is a JSON document. It's used for specs downloaded from URLs that
+// don't end in an extension.
+func looksLikeJSON(data []byte) bool {
+	trimmed := strings.TrimSpace(string(data))
+	return strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")
+}
+
+// unmarshalSpecData decodes a spec or fixtures file into v. YAML documents
+// are converted to JSON first so that they decode into exactly the same
+// structure as JSON ones do.
+func unmarshalSpecData(path string, data []byte, v interface{}) error {
+	if isYAMLFile(path) || (!isJSONFile(path) && !looksLikeJSON(data)) {
+		var err error
+		data, err = yamlToJSON(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// yamlToJSON converts a YAML document to JSON.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var value interface{}
+	err := yaml.Unmarshal(data, &value)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err = convertYAMLValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(value)
+}
+
+// convertYAMLValue converts a value decoded from YAML into one that can be
+// encoded as JSON. YAML mappings decode with keys of any type, and keys that
+// look like numbers (e.g. response status codes like `200`) or booleans
+// don't come out as strings, so all keys are converted to strings.
+func convertYAMLValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, subValue := range v {
+			converted, err := convertYAMLValue(subValue)
+			if err != nil {
+				return nil, err
+			}
+
+			switch key.(type) {
+			case bool, float64, int, int64, string, uint64:
+				m[fmt.Sprint(key)] = converted
+			default:
+				return nil, fmt.Errorf("unsupported YAML mapping key: %v", key)
+			}
+		}
+		return m, nil
+
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, subValue := range v {
+			converted, err := convertYAMLValue(subValue)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = converted
+		}
+		return s, nil
+	}
+
+	return value, nil
+}
@@ -3,7 +3,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	assert "github.com/stretchr/testify/require"
 	"github.com/team-telnyx/telnyx-mock/spec"
@@ -253,10 +259,33 @@ func initTestSpec() {
 	}
 }
 
+const yamlSpec = `
+openapi: 3.0.0
+paths:
+  /v2/charges/{id}:
+    get:
+      operationId: GetCharge
+      responses:
+        200:
+          description: A charge.
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/charge'
+components:
+  schemas:
+    charge:
+      type: object
+      properties:
+        id:
+          type: string
+`
+
 func getDefaultOptions() *options {
 	return &options{
 		httpPort:  -1,
 		httpsPort: -1,
+		listSize:  defaultListSize,
 		port:      -1,
 	}
 }
@@ -419,6 +448,94 @@ func TestCheckConflictingOptions(t *testing.T) {
 		err := options.checkConflictingOptions()
 		assert.Equal(t, fmt.Errorf("Please specify only one of -https-port or -https-unix"), err)
 	}
+
+	{
+		options := getDefaultOptions()
+		options.record = true
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify a -cassette to record to when using -record"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.validateResponses = "loud"
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify one of `off`, `log`, or `strict` for -validate-responses"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.callRingingDelay = -time.Second
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify a -call-ringing-delay that isn't negative"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.journalSize = -1
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify a -journal-size that isn't negative"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.listSize = -1
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify a -list-size that's positive"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.listSize = 0
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify a -list-size that's positive"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.messageSentDelay = -time.Second
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify message delays that aren't negative"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.numberOrderDelay = -time.Second
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify a -number-order-delay that isn't negative"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.numberOrderFailures = "("
+
+		err := options.checkConflictingOptions()
+		assert.Error(t, err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.numberReservationDuration = -time.Minute
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify a -number-reservation-duration that isn't negative"), err)
+	}
+
+	{
+		options := getDefaultOptions()
+		options.webhookRetries = -1
+
+		err := options.checkConflictingOptions()
+		assert.Equal(t, fmt.Errorf("Please specify a -webhook-retries that isn't negative"), err)
+	}
 }
 
 // Specify :0 to ask the OS for a free port.
@@ -505,3 +622,124 @@ func TestOptionsGetNonSecureHTTPSListener(t *testing.T) {
 		listener.Close()
 	}
 }
+
+func TestGetSpec_YAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telnyx-mock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	checkSpec := func(telnyxSpec *spec.Spec) {
+		operation := telnyxSpec.Paths["/v2/charges/{id}"]["get"]
+		assert.NotNil(t, operation)
+		assert.Equal(t, "#/components/schemas/charge",
+			operation.Responses["200"].Content["application/json"].Schema.Ref)
+		assert.Equal(t, "string",
+			telnyxSpec.Components.Schemas["charge"].Properties["id"].Type)
+	}
+
+	for _, name := range []string{"spec.yaml", "spec.yml"} {
+		specPath := filepath.Join(dir, name)
+		err = ioutil.WriteFile(specPath, []byte(yamlSpec), 0644)
+		assert.NoError(t, err)
+
+		telnyxSpec, err := getSpec(specPath, false)
+		assert.NoError(t, err)
+		checkSpec(telnyxSpec)
+	}
+
+	// Specs downloaded from URLs are recognized by content when their URL
+	// doesn't have an extension.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(yamlSpec))
+	}))
+	defer server.Close()
+
+	telnyxSpec, err := getSpec(server.URL+"/openapi", true)
+	assert.NoError(t, err)
+	checkSpec(telnyxSpec)
+
+	// Absolute paths aren't mistaken for URLs.
+	_, err = getSpec(filepath.Join(dir, "spec.txt"), false)
+	assert.Error(t, err)
+}
+
+func TestGetSpec_MultipleFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telnyx-mock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "schemas"), 0755)
+	assert.NoError(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "spec.yaml"), []byte(`
+openapi: 3.0.0
+paths:
+  /v2/charges/{id}:
+    get:
+      responses:
+        200:
+          description: A charge.
+          content:
+            application/json:
+              schema:
+                $ref: './schemas/charge.yaml'
+`), 0644)
+	assert.NoError(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "schemas", "charge.yaml"), []byte(`
+type: object
+properties:
+  id:
+    type: string
+`), 0644)
+	assert.NoError(t, err)
+
+	telnyxSpec, err := getSpec(filepath.Join(dir, "spec.yaml"), false)
+	assert.NoError(t, err)
+
+	schema := telnyxSpec.Paths["/v2/charges/{id}"]["get"].
+		Responses["200"].Content["application/json"].Schema
+	assert.Equal(t, "#/components/schemas/charge", schema.Ref)
+	assert.Equal(t, "string",
+		telnyxSpec.Components.Schemas["charge"].Properties["id"].Type)
+}
+
+func TestGetFixtures_YAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telnyx-mock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fixturesPath := filepath.Join(dir, "fixtures.yaml")
+	err = ioutil.WriteFile(fixturesPath, []byte(`
+resources:
+  charge:
+    id: ch_123
+    amount: 100
+`), 0644)
+	assert.NoError(t, err)
+
+	fixtures, err := getFixtures(fixturesPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "ch_123", "amount": 100.0},
+		fixtures.Resources["charge"])
+}
+
+func TestYAMLToJSON(t *testing.T) {
+	data, err := yamlToJSON([]byte(`
+200: ok
+true: yes
+list:
+  - a: 1
+created_at: 2020-01-01T00:00:00Z
+`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"200": "ok",
+		"true": true,
+		"list": [{"a": 1}],
+		"created_at": "2020-01-01T00:00:00Z"
+	}`, string(data))
+
+	_, err = yamlToJSON([]byte(`[a: 1]: b`))
+	assert.Error(t, err)
+}
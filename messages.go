@@ -0,0 +1,444 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// MessageOutcome decides the final status of messages sent to destinations
+// that match a pattern, so that failed deliveries can be simulated.
+type MessageOutcome struct {
+	// Pattern is matched against a message's destination number (e.g.
+	// `+13125550123`).
+	Pattern *regexp.Regexp
+
+	// Status is the final status of the message for matching destinations,
+	// which is one of messageFinalStatuses.
+	Status string
+}
+
+// String formats the outcome like it's given on the command line.
+func (o *MessageOutcome) String() string {
+	return o.Pattern.String() + "=" + o.Status
+}
+
+// MessageRegistry tracks the lifecycle of outbound messages so that their
+// status moves on over time like the status of a real message would, and
+// schedules the delivery reports that go with it.
+//
+// A message is queued when it's sent through the API, then sending until
+// SentDelay has passed, at which point it's sent. Once another FinalizedDelay
+// has passed, it reaches its final status, which is decided for each
+// destination by Outcomes. Messages that fail to send skip straight to their
+// final status when they would have been sent.
+//
+// It's safe for concurrent use.
+type MessageRegistry struct {
+	mu       sync.Mutex
+	messages map[string]*message
+
+	// SentDelay is how long a message is queued and sending for before it's
+	// sent.
+	SentDelay time.Duration
+
+	// FinalizedDelay is how long a message is sent for before it reaches its
+	// final status.
+	FinalizedDelay time.Duration
+
+	// Outcomes decide the final status of messages by their destination.
+	// The first outcome whose pattern matches wins, and messages to
+	// destinations that none match are delivered.
+	Outcomes []*MessageOutcome
+
+	// now returns the current time, and afterFunc schedules delivery
+	// reports. They're fields so that the passage of time can be controlled.
+	now       func() time.Time
+	afterFunc func(d time.Duration, f func()) stopper
+}
+
+// NewMessageRegistry initializes a new, empty MessageRegistry with the
+// default delays.
+func NewMessageRegistry() *MessageRegistry {
+	return &MessageRegistry{
+		messages:       make(map[string]*message),
+		SentDelay:      defaultMessageSentDelay,
+		FinalizedDelay: defaultMessageFinalizedDelay,
+		now:            time.Now,
+		afterFunc:      realAfterFunc,
+	}
+}
+
+// Reflect updates a message's representation in data to its current state.
+// It returns false if the message isn't known.
+func (r *MessageRegistry) Reflect(id string, data map[string]interface{}) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.messages[id]
+	if !ok {
+		return false
+	}
+
+	m.reflect(data, r.now())
+	return true
+}
+
+// Reset forgets every message, cancelling any delivery reports that haven't
+// been made yet.
+func (r *MessageRegistry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range r.messages {
+		for _, timer := range m.timers {
+			timer.Stop()
+		}
+	}
+	r.messages = make(map[string]*message)
+}
+
+// Send records a new outbound message to the given destinations and updates
+// its representation in data to its initial, queued state.
+//
+// Unless report is nil, it's called with `message.sent` once the message has
+// been sent, and `message.finalized` once it's reached its final status, in a
+// Goroutine of its own.
+func (r *MessageRegistry) Send(id string, to []string, data map[string]interface{},
+	report func(eventType string)) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := &message{
+		createdAt: r.now(),
+		sentDelay: r.SentDelay,
+	}
+
+	for _, phoneNumber := range to {
+		m.recipients = append(m.recipients, &messageRecipient{
+			outcome:     r.outcomeFor(phoneNumber),
+			phoneNumber: phoneNumber,
+		})
+	}
+
+	m.finalizedDelay = m.sentDelay
+	if m.isSent() {
+		m.finalizedDelay += r.FinalizedDelay
+	}
+
+	if report != nil {
+		if m.isSent() {
+			m.timers = append(m.timers, r.afterFunc(m.sentDelay,
+				func() { report(messageSentEvent) }))
+		}
+		m.timers = append(m.timers, r.afterFunc(m.finalizedDelay,
+			func() { report(messageFinalizedEvent) }))
+	}
+
+	r.messages[id] = m
+	m.reflect(data, m.createdAt)
+}
+
+// outcomeFor returns the final status of a message to a destination.
+func (r *MessageRegistry) outcomeFor(phoneNumber string) string {
+	for _, outcome := range r.Outcomes {
+		if outcome.Pattern.MatchString(phoneNumber) {
+			return outcome.Status
+		}
+	}
+	return messageStatusDelivered
+}
+
+//
+// Private values
+//
+
+// Messages are sent this long after they're queued, and reach their final
+// status this long after that, unless configured otherwise.
+const (
+	defaultMessageSentDelay      = time.Second
+	defaultMessageFinalizedDelay = 2 * time.Second
+)
+
+// The delivery reports that follow a message.
+const (
+	messageSentEvent      = "message.sent"
+	messageFinalizedEvent = "message.finalized"
+)
+
+// The statuses that each destination of a message goes through.
+const (
+	messageStatusQueued              = "queued"
+	messageStatusSending             = "sending"
+	messageStatusSent                = "sent"
+	messageStatusDelivered           = "delivered"
+	messageStatusDeliveryFailed      = "delivery_failed"
+	messageStatusDeliveryUnconfirmed = "delivery_unconfirmed"
+	messageStatusSendingFailed       = "sending_failed"
+)
+
+const invalidMessageOutcome = "Please specify a -message-outcome like PATTERN=STATUS, where STATUS is one of: %s"
+
+// messageFinalStatuses are the statuses that a message can end up in, and
+// so the ones that can be given as an outcome.
+var messageFinalStatuses = []string{
+	messageStatusDelivered,
+	messageStatusDeliveryFailed,
+	messageStatusDeliveryUnconfirmed,
+	messageStatusSendingFailed,
+}
+
+// messageErrors are the errors reported for destinations whose message ended
+// up in a failed status.
+var messageErrors = map[string]map[string]interface{}{
+	messageStatusDeliveryFailed: {
+		"code":   "40008",
+		"title":  "Undeliverable",
+		"detail": "The message couldn't be delivered to the recipient.",
+	},
+	messageStatusSendingFailed: {
+		"code":   "40001",
+		"title":  "Not routable",
+		"detail": "The message couldn't be routed to the recipient.",
+	},
+}
+
+// messagePaths are the paths that messages are sent through.
+var messagePaths = map[string]bool{
+	"/messages":             true,
+	"/messages/long_code":   true,
+	"/messages/number_pool": true,
+	"/messages/short_code":  true,
+}
+
+//
+// Private types
+//
+
+// message is the state of a single message in a MessageRegistry.
+type message struct {
+	createdAt  time.Time
+	recipients []*messageRecipient
+
+	// sentDelay and finalizedDelay are how long after the message was
+	// created that it's sent and reaches its final status.
+	sentDelay      time.Duration
+	finalizedDelay time.Duration
+
+	// timers are the delivery reports scheduled for the message.
+	timers []stopper
+}
+
+// isSent returns whether the message gets sent to any of its destinations,
+// as opposed to failing to send to all of them.
+func (m *message) isSent() bool {
+	for _, recipient := range m.recipients {
+		if recipient.outcome != messageStatusSendingFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// reflect updates a message's representation in data to its state at the
+// given time.
+func (m *message) reflect(data map[string]interface{}, now time.Time) {
+	elapsed := now.Sub(m.createdAt)
+
+	data["received_at"] = formatTimestamp(m.createdAt)
+	if m.isSent() && elapsed >= m.sentDelay {
+		data["sent_at"] = formatTimestamp(m.createdAt.Add(m.sentDelay))
+	}
+	if elapsed >= m.finalizedDelay {
+		data["completed_at"] = formatTimestamp(m.createdAt.Add(m.finalizedDelay))
+	}
+
+	// Keep whatever else was generated for each destination, like its
+	// carrier.
+	existing, _ := data["to"].([]interface{})
+	to := make([]interface{}, len(m.recipients))
+	errors := make([]interface{}, 0)
+	for i, recipient := range m.recipients {
+		entry := make(map[string]interface{})
+		if len(existing) > 0 {
+			if template, ok := existing[i%len(existing)].(map[string]interface{}); ok {
+				entry = deepCopy(template).(map[string]interface{})
+			}
+		}
+
+		status := recipient.status(elapsed, m.sentDelay, m.finalizedDelay)
+		entry["phone_number"] = recipient.phoneNumber
+		entry["status"] = status
+		to[i] = entry
+
+		if messageError, ok := messageErrors[status]; ok {
+			messageError = deepCopy(messageError).(map[string]interface{})
+			messageError["source"] = map[string]interface{}{
+				"pointer": fmt.Sprintf("/to/%d", i),
+			}
+			errors = append(errors, messageError)
+		}
+	}
+	data["to"] = to
+	data["errors"] = errors
+}
+
+// messageRecipient is a single destination of a message.
+type messageRecipient struct {
+	// outcome is the status that the message reaches for the destination in
+	// the end.
+	outcome     string
+	phoneNumber string
+}
+
+// status returns the status of the message for the destination once the
+// given time has elapsed since the message was created.
+func (r *messageRecipient) status(elapsed, sentDelay, finalizedDelay time.Duration) string {
+	switch {
+	case elapsed <= 0:
+		return messageStatusQueued
+	case r.outcome == messageStatusSendingFailed && elapsed >= sentDelay:
+		return messageStatusSendingFailed
+	case elapsed < sentDelay:
+		return messageStatusSending
+	case elapsed < finalizedDelay:
+		return messageStatusSent
+	default:
+		return r.outcome
+	}
+}
+
+//
+// Private functions
+//
+
+// reconcileWithMessages brings a messaging request and its generated
+// response in line with the server's message registry. It's only used in
+// stateful mode, after the response has been reconciled with the store.
+//
+// Sending a message registers it and schedules its delivery reports, and
+// retrieving one reports its current status.
+func (s *StubServer) reconcileWithMessages(r *http.Request, route *stubServerRoute,
+	requestData map[string]interface{}, responseData interface{}) interface{} {
+
+	responseMap, _ := responseData.(map[string]interface{})
+	data, _ := responseMap["data"].(map[string]interface{})
+	if data == nil {
+		return responseData
+	}
+
+	id, _ := data["id"].(string)
+
+	switch {
+	case messagePaths[string(route.path)] && r.Method == http.MethodPost:
+		var report func(eventType string)
+		if s.webhooks != nil {
+			if url, failoverURL := s.webhookURLs(requestData); url != "" {
+				report = s.messageReporter(id, url, failoverURL)
+			}
+		}
+
+		s.messages.Send(id, messageDestinations(requestData, data), data, report)
+		s.store.Put(data)
+
+	case string(route.path) == "/messages/{id}" && r.Method == http.MethodGet:
+		s.messages.Reflect(id, data)
+	}
+
+	return responseData
+}
+
+// messageReporter returns a function that delivers a message's delivery
+// reports, each of which carries the message as it is when it's made. The
+// report is made right away, but delivered in the background so that the
+// clock doesn't wait for the receiver.
+func (s *StubServer) messageReporter(id, url, failoverURL string) func(eventType string) {
+	return func(eventType string) {
+		data, ok := s.store.Get("message", id)
+		if !ok || !s.messages.Reflect(id, data) {
+			return
+		}
+
+		event, err := s.generateEvent(eventType, data)
+		if err != nil {
+			fmt.Printf("Couldn't generate %s webhook: %v\n", eventType, err)
+			return
+		}
+
+		go s.webhooks.Emit(url, failoverURL, []map[string]interface{}{event})
+	}
+}
+
+// formatTimestamp formats a time like the timestamps of Telnyx resources.
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// isMessagePath returns whether a path belongs to the messaging API.
+func isMessagePath(routePath string) bool {
+	return messagePaths[routePath] || strings.HasPrefix(routePath, "/messages/")
+}
+
+// messageDestinations returns the numbers that a message is being sent to,
+// which are taken from the request's `to`, or failing that, the generated
+// response.
+func messageDestinations(requestData map[string]interface{}, data map[string]interface{}) []string {
+	var to []string
+	switch value := requestData["to"].(type) {
+	case string:
+		to = append(to, value)
+	case []interface{}:
+		for _, item := range value {
+			if phoneNumber, ok := item.(string); ok {
+				to = append(to, phoneNumber)
+			}
+		}
+	}
+	if len(to) > 0 {
+		return to
+	}
+
+	recipients, _ := data["to"].([]interface{})
+	for _, recipient := range recipients {
+		if recipient, ok := recipient.(map[string]interface{}); ok {
+			if phoneNumber, ok := recipient["phone_number"].(string); ok {
+				to = append(to, phoneNumber)
+			}
+		}
+	}
+	return to
+}
+
+// parseMessageOutcome parses an outcome given on the command line as
+// `PATTERN=STATUS`. The pattern is a regular expression, and the outcome is
+// split at the last `=` so that the pattern can contain one.
+func parseMessageOutcome(value string) (*MessageOutcome, error) {
+	index := strings.LastIndex(value, "=")
+	if index == -1 {
+		return nil, fmt.Errorf(invalidMessageOutcome, strings.Join(messageFinalStatuses, ", "))
+	}
+
+	status := value[index+1:]
+	isFinal := false
+	for _, finalStatus := range messageFinalStatuses {
+		isFinal = isFinal || status == finalStatus
+	}
+	if !isFinal {
+		return nil, fmt.Errorf(invalidMessageOutcome, strings.Join(messageFinalStatuses, ", "))
+	}
+
+	pattern, err := regexp.Compile(value[:index])
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse -message-outcome pattern: %v", err)
+	}
+
+	return &MessageOutcome{Pattern: pattern, Status: status}, nil
+}
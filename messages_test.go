@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/team-telnyx/telnyx-mock/webhook"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_MessageLifecycle(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	server := getStatefulStubServer(t)
+	server.messages.now = func() time.Time { return now }
+	server.messages.Outcomes = []*MessageOutcome{
+		{Pattern: regexp.MustCompile(`0666$`), Status: messageStatusDeliveryFailed},
+	}
+
+	send := func(to string) map[string]interface{} {
+		resp, body := sendRequestToServer(t, server, "POST", "/v2/messages/long_code",
+			`{"from": "+13125550100", "to": "`+to+`", "text": "Hello"}`,
+			getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+		return data["data"].(map[string]interface{})
+	}
+
+	getMessage := func(id string) map[string]interface{} {
+		resp, body := sendRequestToServer(t, server, "GET", "/v2/messages/"+id, "",
+			getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+		return data["data"].(map[string]interface{})
+	}
+
+	recipient := func(message map[string]interface{}) map[string]interface{} {
+		to := message["to"].([]interface{})
+		assert.Equal(t, 1, len(to))
+		return to[0].(map[string]interface{})
+	}
+
+	message := send("+13125550001")
+	id := message["id"].(string)
+	assert.Equal(t, "+13125550001", recipient(message)["phone_number"])
+	assert.Equal(t, messageStatusQueued, recipient(message)["status"])
+	assert.Nil(t, message["sent_at"])
+
+	failed := send("+13125550666")
+	failedID := failed["id"].(string)
+	assert.NotEqual(t, id, failedID)
+
+	now = now.Add(time.Millisecond)
+	assert.Equal(t, messageStatusSending, recipient(getMessage(id))["status"])
+
+	now = now.Add(defaultMessageSentDelay)
+	message = getMessage(id)
+	assert.Equal(t, messageStatusSent, recipient(message)["status"])
+	assert.Equal(t, "2017-07-14T02:40:01Z", message["sent_at"])
+	assert.Nil(t, message["completed_at"])
+
+	now = now.Add(defaultMessageFinalizedDelay)
+	message = getMessage(id)
+	assert.Equal(t, messageStatusDelivered, recipient(message)["status"])
+	assert.Equal(t, "2017-07-14T02:40:03Z", message["completed_at"])
+	assert.Empty(t, message["errors"])
+
+	failed = getMessage(failedID)
+	assert.Equal(t, messageStatusDeliveryFailed, recipient(failed)["status"])
+	messageError := failed["errors"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "40008", messageError["code"])
+	assert.Equal(t, "/to/0", messageError["source"].(map[string]interface{})["pointer"])
+
+	// Messages are forgotten on reset.
+	resp, _ := sendRequestToServer(t, server, "POST", "/_mock/reset", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "GET", "/v2/messages/"+id, "", getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestStubServer_MessageDeliveryReports(t *testing.T) {
+	events := make(chan map[string]interface{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+
+		events <- body["data"].(map[string]interface{})
+	}))
+	defer receiver.Close()
+
+	signer, err := webhook.GenerateSigner()
+	assert.NoError(t, err)
+
+	server := getStatefulStubServer(t)
+	server.messages.SentDelay = 10 * time.Millisecond
+	server.messages.FinalizedDelay = 10 * time.Millisecond
+	server.messages.Outcomes = []*MessageOutcome{
+		{Pattern: regexp.MustCompile(`0666$`), Status: messageStatusSendingFailed},
+	}
+	server.webhooks = &WebhookEmitter{Sender: &webhook.Sender{Signer: signer}}
+
+	receive := func() map[string]interface{} {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for webhook")
+		}
+		return nil
+	}
+
+	status := func(event map[string]interface{}) interface{} {
+		payload := event["payload"].(map[string]interface{})
+		return payload["to"].([]interface{})[0].(map[string]interface{})["status"]
+	}
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v2/messages",
+		`{"to": "+13125550001", "text": "Hello", "webhook_url": "`+receiver.URL+`"}`,
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	id := data["data"].(map[string]interface{})["id"]
+
+	event := receive()
+	assert.Equal(t, messageSentEvent, event["event_type"])
+	assert.Equal(t, id, event["payload"].(map[string]interface{})["id"])
+	assert.Equal(t, messageStatusSent, status(event))
+
+	event = receive()
+	assert.Equal(t, messageFinalizedEvent, event["event_type"])
+	assert.Equal(t, messageStatusDelivered, status(event))
+
+	// A message that fails to send is only ever finalized.
+	resp, _ = sendRequestToServer(t, server, "POST", "/v2/messages",
+		`{"to": "+13125550666", "text": "Hello", "webhook_url": "`+receiver.URL+`"}`,
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	event = receive()
+	assert.Equal(t, messageFinalizedEvent, event["event_type"])
+	assert.Equal(t, messageStatusSendingFailed, status(event))
+}
+
+//
+// Tests for private functions
+//
+
+func TestParseMessageOutcome(t *testing.T) {
+	outcome, err := parseMessageOutcome(`^\+1312555=0666$=delivery_failed`)
+	assert.NoError(t, err)
+	assert.Equal(t, `^\+1312555=0666$`, outcome.Pattern.String())
+	assert.Equal(t, messageStatusDeliveryFailed, outcome.Status)
+
+	_, err = parseMessageOutcome(`^\+1312555`)
+	assert.Error(t, err)
+
+	_, err = parseMessageOutcome(`^\+1312555=sent`)
+	assert.Error(t, err)
+
+	_, err = parseMessageOutcome(`(=delivered`)
+	assert.Error(t, err)
+}
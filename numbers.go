@@ -0,0 +1,540 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+//
+// Private values
+//
+
+// availablePhoneNumbersPath is the path of the search for phone numbers that
+// are available to order.
+const availablePhoneNumbersPath = "/available_phone_numbers"
+
+// maxNumberSearchLimit is the most available phone numbers that a search
+// finds, whatever its `filter[limit]`.
+const maxNumberSearchLimit = 250
+
+// defaultNumberCountry is the country that available phone numbers are
+// searched for in when a search doesn't filter on one, like Telnyx's.
+const defaultNumberCountry = "US"
+
+// The number types that a search can filter on.
+const (
+	numberTypeLocal    = "local"
+	numberTypeMobile   = "mobile"
+	numberTypeNational = "national"
+	numberTypeTollFree = "toll-free"
+)
+
+// numberFeaturesLocal are the features of local numbers in North America.
+var numberFeaturesLocal = []string{"sms", "mms", "voice", "fax", "emergency"}
+
+// numberRegions are the regions that available phone numbers are generated
+// in. Where a country has more than one, the first is the one that a search
+// without any other filters mostly turns up.
+var numberRegions = []*numberRegion{
+	{
+		countryCode: "US", callingCode: "1", ndc: "312", subscriber: "NXXXXXX",
+		locality: "Chicago", administrativeArea: "IL", rateCenter: "CHICAGO",
+		numberType: numberTypeLocal, features: numberFeaturesLocal,
+		upfrontCost: "1.00", monthlyCost: "1.00",
+	},
+	{
+		countryCode: "US", callingCode: "1", ndc: "212", subscriber: "NXXXXXX",
+		locality: "New York", administrativeArea: "NY", rateCenter: "NEW YORK CITY",
+		numberType: numberTypeLocal, features: numberFeaturesLocal,
+		upfrontCost: "1.00", monthlyCost: "1.00",
+	},
+	{
+		countryCode: "US", callingCode: "1", ndc: "415", subscriber: "NXXXXXX",
+		locality: "San Francisco", administrativeArea: "CA", rateCenter: "SAN FRANCISCO",
+		numberType: numberTypeLocal, features: numberFeaturesLocal,
+		upfrontCost: "1.00", monthlyCost: "1.00",
+	},
+	{
+		countryCode: "US", callingCode: "1", ndc: "512", subscriber: "NXXXXXX",
+		locality: "Austin", administrativeArea: "TX", rateCenter: "AUSTIN",
+		numberType: numberTypeLocal, features: numberFeaturesLocal,
+		upfrontCost: "1.00", monthlyCost: "1.00",
+	},
+	{
+		countryCode: "US", callingCode: "1", ndc: "970", subscriber: "NXXXXXX",
+		locality: "Fort Collins", administrativeArea: "CO", rateCenter: "FORT COLLINS",
+		numberType: numberTypeLocal, features: numberFeaturesLocal,
+		upfrontCost: "1.00", monthlyCost: "1.00",
+	},
+	{
+		countryCode: "US", callingCode: "1", ndc: "888", subscriber: "NXXXXXX",
+		numberType: numberTypeTollFree, features: []string{"sms", "voice", "fax"},
+		upfrontCost: "1.00", monthlyCost: "2.00",
+	},
+	{
+		countryCode: "CA", callingCode: "1", ndc: "416", subscriber: "NXXXXXX",
+		locality: "Toronto", administrativeArea: "ON", rateCenter: "TORONTO",
+		numberType: numberTypeLocal, features: numberFeaturesLocal,
+		upfrontCost: "1.00", monthlyCost: "1.00",
+	},
+	{
+		countryCode: "CA", callingCode: "1", ndc: "604", subscriber: "NXXXXXX",
+		locality: "Vancouver", administrativeArea: "BC", rateCenter: "VANCOUVER",
+		numberType: numberTypeLocal, features: numberFeaturesLocal,
+		upfrontCost: "1.00", monthlyCost: "1.00",
+	},
+	{
+		countryCode: "GB", callingCode: "44", ndc: "20", subscriber: "NXXXXXXX",
+		locality: "London", numberType: numberTypeNational,
+		features: []string{"voice", "fax", "emergency"}, regulated: true,
+		upfrontCost: "1.00", monthlyCost: "1.50",
+	},
+	{
+		countryCode: "GB", callingCode: "44", ndc: "7700", subscriber: "NXXXXX",
+		numberType: numberTypeMobile, features: []string{"sms", "voice"}, regulated: true,
+		upfrontCost: "1.00", monthlyCost: "2.50",
+	},
+	{
+		countryCode: "AU", callingCode: "61", ndc: "2", subscriber: "NXXXXXXX",
+		locality: "Sydney", administrativeArea: "NSW", numberType: numberTypeNational,
+		features: []string{"voice", "fax", "emergency"}, regulated: true,
+		upfrontCost: "1.00", monthlyCost: "3.00",
+	},
+	{
+		countryCode: "DE", callingCode: "49", ndc: "30", subscriber: "NXXXXXXX",
+		locality: "Berlin", numberType: numberTypeNational,
+		features: []string{"voice", "fax", "emergency"}, regulated: true,
+		upfrontCost: "1.00", monthlyCost: "1.50",
+	},
+}
+
+// keypadDigits maps letters to the digits that they share a telephone keypad
+// key with, so that vanity patterns like `FLOWERS` can be searched for.
+var keypadDigits = map[rune]byte{
+	'A': '2', 'B': '2', 'C': '2',
+	'D': '3', 'E': '3', 'F': '3',
+	'G': '4', 'H': '4', 'I': '4',
+	'J': '5', 'K': '5', 'L': '5',
+	'M': '6', 'N': '6', 'O': '6',
+	'P': '7', 'Q': '7', 'R': '7', 'S': '7',
+	'T': '8', 'U': '8', 'V': '8',
+	'W': '9', 'X': '9', 'Y': '9', 'Z': '9',
+}
+
+//
+// Private types
+//
+
+// numberRegion describes where available phone numbers come from: the
+// country and national destination code (NDC, i.e. area code) that they're
+// in, and what they cost and can do.
+type numberRegion struct {
+	countryCode string
+	callingCode string
+	ndc         string
+
+	// subscriber is the shape of the part of a number that follows its NDC,
+	// with an `N` for each digit from 2 to 9 and an `X` for any digit.
+	subscriber string
+
+	// locality, administrativeArea, and rateCenter locate the region. Any of
+	// them may be empty, like they are for toll-free numbers.
+	locality           string
+	administrativeArea string
+	rateCenter         string
+
+	numberType string
+	features   []string
+
+	// regulated is whether numbers in the region can only be ordered with
+	// proof of address.
+	regulated bool
+
+	upfrontCost string
+	monthlyCost string
+}
+
+// generate generates a number in the region that matches a search, or
+// returns false if no number in the region can match it.
+func (r *numberRegion) generate(search *numberSearch, rng *rand.Rand) (string, string, bool) {
+	national := []byte(r.ndc + strings.Repeat("?", len(r.subscriber)))
+	vanity := make([]byte, len(national))
+	copy(vanity, national)
+
+	// Patterns are matched against the part of the number that follows its
+	// NDC if the search is for a particular NDC, and against the whole
+	// national number otherwise.
+	offset := 0
+	if search.ndc != "" {
+		offset = len(r.ndc)
+	}
+
+	place := func(pattern string, at int) bool {
+		digits, letters := keypadPattern(pattern)
+		if at < offset || at+len(digits) > len(national) {
+			return false
+		}
+		for i := range digits {
+			if !r.allows(national, at+i, digits[i]) {
+				return false
+			}
+		}
+		for i := range digits {
+			national[at+i] = digits[i]
+			vanity[at+i] = letters[i]
+		}
+		return true
+	}
+
+	if search.startsWith != "" && !place(search.startsWith, offset) {
+		return "", "", false
+	}
+	if search.endsWith != "" {
+		digits, _ := keypadPattern(search.endsWith)
+		if !place(search.endsWith, len(national)-len(digits)) {
+			return "", "", false
+		}
+	}
+	if search.contains != "" {
+		digits, _ := keypadPattern(search.contains)
+
+		var candidates []int
+		for at := offset; at+len(digits) <= len(national); at++ {
+			fits := true
+			for i := range digits {
+				fits = fits && r.allows(national, at+i, digits[i])
+			}
+			if fits {
+				candidates = append(candidates, at)
+			}
+		}
+		if len(candidates) < 1 {
+			return "", "", false
+		}
+		place(search.contains, candidates[rng.Intn(len(candidates))])
+	}
+
+	for i, digit := range national {
+		if digit != '?' {
+			continue
+		}
+
+		min := 0
+		if r.subscriber[i-len(r.ndc)] == 'N' {
+			min = 2
+		}
+		national[i] = byte('0' + min + rng.Intn(10-min))
+		vanity[i] = national[i]
+	}
+
+	phoneNumber := "+" + r.callingCode + string(national)
+	vanityFormat := ""
+	if string(vanity) != string(national) {
+		vanityFormat = "+" + r.callingCode + string(vanity)
+	}
+
+	return phoneNumber, vanityFormat, true
+}
+
+// allows returns whether a digit can go at a position of a national number
+// that's partly filled in (with `?` for the positions that are still free).
+func (r *numberRegion) allows(national []byte, i int, digit byte) bool {
+	if national[i] != '?' {
+		return national[i] == digit
+	}
+	return r.subscriber[i-len(r.ndc)] != 'N' || digit >= '2'
+}
+
+// matches returns whether the region is one that a search could turn up
+// numbers in.
+func (r *numberRegion) matches(search *numberSearch) bool {
+	if !strings.EqualFold(r.countryCode, search.countryCode) {
+		return false
+	}
+	if search.ndc != "" && search.ndc != r.ndc {
+		return false
+	}
+	if search.numberType != "" && search.numberType != r.numberType {
+		return false
+	}
+	if search.locality != "" && !strings.EqualFold(search.locality, r.locality) {
+		return false
+	}
+	if search.administrativeArea != "" &&
+		!strings.EqualFold(search.administrativeArea, r.administrativeArea) {
+		return false
+	}
+
+	for _, feature := range search.features {
+		supported := false
+		for _, regionFeature := range r.features {
+			supported = supported || strings.EqualFold(feature, regionFeature)
+		}
+		if !supported {
+			return false
+		}
+	}
+
+	return true
+}
+
+// regionInformation describes where a number in the region is, most
+// specific first, like Telnyx does.
+func (r *numberRegion) regionInformation() []interface{} {
+	var information []interface{}
+	add := func(regionType, name string) {
+		if name != "" {
+			information = append(information, map[string]interface{}{
+				"region_type": regionType,
+				"region_name": name,
+			})
+		}
+	}
+
+	add("rate_center", r.rateCenter)
+	add("location", r.locality)
+	add("state", r.administrativeArea)
+	add("country_code", r.countryCode)
+	return information
+}
+
+// numberSearch holds the filters of a search for available phone numbers.
+type numberSearch struct {
+	countryCode        string
+	ndc                string
+	numberType         string
+	locality           string
+	administrativeArea string
+	features           []string
+
+	startsWith string
+	endsWith   string
+	contains   string
+
+	// limit is the number of results to return.
+	limit int
+}
+
+//
+// Private functions
+//
+
+// searchAvailablePhoneNumbers replaces the generated results of a search for
+// available phone numbers with numbers that match the search's filters. The
+// numbers are valid E.164 numbers in the right country and area, and the
+// same search with the same PRNG seed always finds the same numbers.
+//
+// Everything that the generated results have that isn't determined by the
+// number (e.g. `quickship`) is kept. A search without `filter[limit]` finds
+// listSize numbers.
+func searchAvailablePhoneNumbers(requestData map[string]interface{},
+	responseData interface{}, listSize int, rng *rand.Rand) interface{} {
+
+	responseMap, ok := responseData.(map[string]interface{})
+	if !ok {
+		return responseData
+	}
+
+	template := map[string]interface{}{"record_type": "available_phone_number"}
+	if data, ok := responseMap["data"].([]interface{}); ok && len(data) > 0 {
+		if item, ok := data[0].(map[string]interface{}); ok {
+			template = item
+		}
+	}
+
+	search := parseNumberSearch(requestData, listSize)
+
+	var regions []*numberRegion
+	for _, region := range numberRegions {
+		if region.matches(search) {
+			regions = append(regions, region)
+		}
+	}
+
+	// Any NDC can be searched for in North America, even if it's not one
+	// that numbers are usually generated in.
+	if len(regions) < 1 && isNANPAreaCode(search.ndc) {
+		for _, region := range numberRegions {
+			if region.callingCode != "1" || region.numberType != numberTypeLocal {
+				continue
+			}
+
+			synthetic := &numberRegion{
+				countryCode: region.countryCode, callingCode: region.callingCode,
+				ndc: search.ndc, subscriber: region.subscriber,
+				numberType: region.numberType, features: region.features,
+				upfrontCost: region.upfrontCost, monthlyCost: region.monthlyCost,
+			}
+			if synthetic.matches(search) {
+				regions = append(regions, synthetic)
+				break
+			}
+		}
+	}
+
+	items := make([]interface{}, 0, search.limit)
+	seen := make(map[string]bool)
+	for attempt := 0; len(regions) > 0 && len(items) < search.limit &&
+		attempt < search.limit*10; attempt++ {
+
+		// The first region is the most likely, but every region gets some.
+		region := regions[0]
+		if rng.Intn(2) == 1 {
+			region = regions[rng.Intn(len(regions))]
+		}
+
+		phoneNumber, vanityFormat, ok := region.generate(search, rng)
+		if !ok || seen[phoneNumber] || isNANPServiceCode(region, phoneNumber) {
+			continue
+		}
+		seen[phoneNumber] = true
+
+		item := deepCopy(template).(map[string]interface{})
+		item["best_effort"] = false
+		item["phone_number"] = phoneNumber
+		item["vanity_format"] = vanityFormat
+		item["region_information"] = region.regionInformation()
+		item["cost_information"] = map[string]interface{}{
+			"upfront_cost": region.upfrontCost,
+			"monthly_cost": region.monthlyCost,
+			"currency":     "USD",
+		}
+
+		features := make([]interface{}, len(region.features))
+		for i, feature := range region.features {
+			features[i] = map[string]interface{}{"name": feature}
+		}
+		item["features"] = features
+
+		if !region.regulated {
+			item["regulatory_requirements"] = []interface{}{}
+		}
+
+		items = append(items, item)
+	}
+
+	responseMap["data"] = items
+	responseMap["meta"] = map[string]interface{}{
+		"best_effort_results": 0,
+		"total_results":       len(items),
+	}
+	return responseMap
+}
+
+// isNANPAreaCode returns whether a string is a valid North American area
+// code, which is three digits starting with one from 2 to 9.
+func isNANPAreaCode(ndc string) bool {
+	if len(ndc) != 3 || ndc[0] < '2' || ndc[0] > '9' {
+		return false
+	}
+	_, err := strconv.Atoi(ndc)
+	return err == nil
+}
+
+// isNANPServiceCode returns whether a North American number has an exchange
+// code like 411 or 911, which are reserved for services.
+func isNANPServiceCode(region *numberRegion, phoneNumber string) bool {
+	return region.callingCode == "1" && strings.HasPrefix(phoneNumber[6:], "11")
+}
+
+// keypadPattern converts a pattern that a number is searched for with to the
+// digits that it stands for, which differ from the pattern where it's spelled
+// out in letters. It returns both along with the pattern as it's shown in a
+// vanity format, in upper case.
+func keypadPattern(pattern string) ([]byte, []byte) {
+	pattern = strings.ToUpper(pattern)
+
+	var digits, letters []byte
+	for _, c := range pattern {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = append(digits, byte(c))
+			letters = append(letters, byte(c))
+		default:
+			if digit, ok := keypadDigits[c]; ok {
+				digits = append(digits, digit)
+				letters = append(letters, byte(c))
+			}
+		}
+	}
+	return digits, letters
+}
+
+// parseNumberSearch extracts the filters of a search for available phone
+// numbers from its parameters.
+//
+// A pattern to search for (e.g. `filter[phone_number][starts_with]`) that
+// starts with the country's calling code in E.164 format (e.g. `+1312`) has
+// it removed.
+//
+// The search finds defaultLimit numbers unless `filter[limit]` says
+// otherwise, and never more than maxNumberSearchLimit.
+func parseNumberSearch(requestData map[string]interface{}, defaultLimit int) *numberSearch {
+	filters, _ := requestData["filter"].(map[string]interface{})
+	phoneNumber, _ := filters["phone_number"].(map[string]interface{})
+
+	value := func(params map[string]interface{}, name string) string {
+		if value, ok := params[name]; ok && value != nil {
+			return strings.TrimSpace(fmt.Sprint(value))
+		}
+		return ""
+	}
+
+	search := &numberSearch{
+		countryCode:        strings.ToUpper(value(filters, "country_code")),
+		ndc:                value(filters, "national_destination_code"),
+		numberType:         value(filters, "number_type"),
+		locality:           value(filters, "locality"),
+		administrativeArea: value(filters, "administrative_area"),
+		limit:              defaultLimit,
+	}
+	if search.limit == 0 {
+		search.limit = defaultListSize
+	}
+	if search.countryCode == "" {
+		search.countryCode = defaultNumberCountry
+	}
+
+	callingCode := ""
+	for _, region := range numberRegions {
+		if region.countryCode == search.countryCode {
+			callingCode = region.callingCode
+			break
+		}
+	}
+
+	pattern := func(name string) string {
+		text := value(phoneNumber, name)
+		if callingCode != "" && strings.HasPrefix(text, "+"+callingCode) {
+			text = text[len(callingCode)+1:]
+		}
+		return text
+	}
+	search.startsWith = pattern(filterOperatorStartsWith)
+	search.endsWith = pattern(filterOperatorEndsWith)
+	search.contains = pattern(filterOperatorContains)
+
+	switch features := filters["features"].(type) {
+	case []interface{}:
+		for _, feature := range features {
+			search.features = append(search.features, fmt.Sprint(feature))
+		}
+	case string:
+		for _, feature := range strings.Split(features, ",") {
+			if feature = strings.TrimSpace(feature); feature != "" {
+				search.features = append(search.features, feature)
+			}
+		}
+	}
+
+	if limit, err := strconv.Atoi(value(filters, "limit")); err == nil && limit > 0 {
+		search.limit = limit
+	}
+	if search.limit > maxNumberSearchLimit {
+		search.limit = maxNumberSearchLimit
+	}
+
+	return search
+}
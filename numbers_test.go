@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_SearchesAvailablePhoneNumbers(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	search := func(filters url.Values, headers map[string]string) ([]interface{}, map[string]interface{}) {
+		resp, body := sendRequestToServer(t, server, "GET",
+			"/v2/available_phone_numbers?"+filters.Encode(), "", headers)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+		return data["data"].([]interface{}), data["meta"].(map[string]interface{})
+	}
+
+	numbers, meta := search(url.Values{
+		"filter[country_code]":              {"US"},
+		"filter[national_destination_code]": {"312"},
+		"filter[features]":                  {"voice,sms"},
+		"filter[limit]":                     {"5"},
+	}, getDefaultHeaders())
+	assert.Equal(t, 5, len(numbers))
+	assert.Equal(t, 5.0, meta["total_results"])
+
+	number := numbers[0].(map[string]interface{})
+	assert.Equal(t, "available_phone_number", number["record_type"])
+	assert.Regexp(t, `^\+1312[2-9]\d{6}$`, number["phone_number"])
+	assert.Contains(t, number["region_information"], map[string]interface{}{
+		"region_type": "state",
+		"region_name": "IL",
+	})
+	assert.Equal(t, "USD", number["cost_information"].(map[string]interface{})["currency"])
+	assert.Contains(t, number["features"], map[string]interface{}{"name": "sms"})
+
+	// Patterns follow the NDC when one is given, and can be spelled out.
+	numbers, _ = search(url.Values{
+		"filter[national_destination_code]": {"303"},
+		"filter[phone_number][starts_with]": {"FLOWERS"},
+		"filter[limit]":                     {"1"},
+	}, getDefaultHeaders())
+	number = numbers[0].(map[string]interface{})
+	assert.Equal(t, "+13033569377", number["phone_number"])
+	assert.Equal(t, "+1303FLOWERS", number["vanity_format"])
+
+	numbers, _ = search(url.Values{
+		"filter[country_code]":            {"GB"},
+		"filter[features]":                {"sms"},
+		"filter[phone_number][ends_with]": {"00"},
+	}, getDefaultHeaders())
+	assert.Equal(t, defaultListSize, len(numbers))
+	for _, number := range numbers {
+		phoneNumber := number.(map[string]interface{})["phone_number"].(string)
+		assert.True(t, strings.HasPrefix(phoneNumber, "+447700"), phoneNumber)
+		assert.True(t, strings.HasSuffix(phoneNumber, "00"), phoneNumber)
+	}
+
+	// Nothing is available with features that no number has.
+	numbers, meta = search(url.Values{
+		"filter[country_code]": {"DE"},
+		"filter[features]":     {"sms"},
+	}, getDefaultHeaders())
+	assert.Equal(t, 0, len(numbers))
+	assert.Equal(t, 0.0, meta["total_results"])
+
+	// Limits are capped, however large.
+	numbers, _ = search(url.Values{
+		"filter[limit]": {"999999999999999"},
+	}, getDefaultHeaders())
+	assert.Equal(t, maxNumberSearchLimit, len(numbers))
+
+	// The same search with the same seed finds the same numbers, and a
+	// different seed finds different ones.
+	filters := url.Values{"filter[limit]": {"3"}}
+	seeded := func(seed string) []interface{} {
+		headers := getDefaultHeaders()
+		headers[headerSeed] = seed
+		numbers, _ := search(filters, headers)
+		return numbers
+	}
+	assert.Equal(t, seeded("1"), seeded("1"))
+	assert.NotEqual(t, seeded("1"), seeded("2"))
+}
+
+//
+// Tests for private functions
+//
+
+func TestParseNumberSearch(t *testing.T) {
+	assert.Equal(t, 45, parseNumberSearch(nil, 45).limit)
+	assert.Equal(t, defaultListSize, parseNumberSearch(nil, 0).limit)
+
+	filters := func(limit string) map[string]interface{} {
+		return map[string]interface{}{
+			"filter": map[string]interface{}{"limit": limit},
+		}
+	}
+	assert.Equal(t, 3, parseNumberSearch(filters("3"), 45).limit)
+	assert.Equal(t, maxNumberSearchLimit, parseNumberSearch(filters("10000000"), 45).limit)
+}
+
+func TestKeypadPattern(t *testing.T) {
+	digits, letters := keypadPattern("1-800-Flowers")
+	assert.Equal(t, "18003569377", string(digits))
+	assert.Equal(t, "1800FLOWERS", string(letters))
+}
+
+func TestNumberRegion_Generate(t *testing.T) {
+	region := numberRegions[0]
+
+	// `1` can't start an exchange code, so no number can start with it.
+	_, _, ok := region.generate(&numberSearch{ndc: region.ndc, startsWith: "1"},
+		newCreateRand(0, 1))
+	assert.False(t, ok)
+
+	phoneNumber, vanityFormat, ok := region.generate(
+		&numberSearch{startsWith: "312", contains: "4242"}, newCreateRand(0, 1))
+	assert.True(t, ok)
+	assert.Regexp(t, `^\+1312\d*4242\d*$`, phoneNumber)
+	assert.Equal(t, "", vanityFormat)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/team-telnyx/telnyx-mock/spec"
+)
+
+//
+// Public types
+//
+
+// NumberOrderQueue holds the number orders that are waiting to be fulfilled.
+// An order is pending when it's placed, and is fulfilled once Delay has
+// passed, at which point every number in it has either been ordered or
+// failed to be.
+//
+// It's safe for concurrent use.
+type NumberOrderQueue struct {
+	mu     sync.Mutex
+	orders []*PendingNumberOrder
+
+	// Delay is how long an order is pending for before it's fulfilled.
+	Delay time.Duration
+
+	// FailurePattern matches the numbers that fail to be ordered.
+	//
+	// nil if every number is ordered successfully.
+	FailurePattern *regexp.Regexp
+
+	// now returns the current time, and afterFunc schedules fulfillment.
+	// They're fields so that the passage of time can be controlled.
+	now       func() time.Time
+	afterFunc func(d time.Duration, f func()) stopper
+}
+
+// NewNumberOrderQueue initializes a new, empty NumberOrderQueue with the
+// default delay.
+func NewNumberOrderQueue() *NumberOrderQueue {
+	return &NumberOrderQueue{
+		Delay:     defaultNumberOrderDelay,
+		now:       time.Now,
+		afterFunc: realAfterFunc,
+	}
+}
+
+// Fails returns whether a number fails to be ordered.
+func (q *NumberOrderQueue) Fails(phoneNumber string) bool {
+	return q.FailurePattern != nil && q.FailurePattern.MatchString(phoneNumber)
+}
+
+// Fulfill removes the orders that are due from the queue and calls fulfill
+// with each of them. The queue isn't locked by then, so orders can be placed
+// while others are fulfilled, and fulfill is free to use the queue.
+func (q *NumberOrderQueue) Fulfill(fulfill func(order *PendingNumberOrder)) {
+	q.mu.Lock()
+	now := q.now()
+
+	var due, pending []*PendingNumberOrder
+	for _, order := range q.orders {
+		if now.Before(order.DueAt) {
+			pending = append(pending, order)
+		} else {
+			due = append(due, order)
+		}
+	}
+	q.orders = pending
+	q.mu.Unlock()
+
+	for _, order := range due {
+		fulfill(order)
+	}
+}
+
+// Push adds an order to the queue, to be fulfilled once the queue's delay has
+// passed, at which point fulfill is called.
+func (q *NumberOrderQueue) Push(order *PendingNumberOrder, fulfill func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	order.DueAt = q.now().Add(q.Delay)
+	order.timer = q.afterFunc(q.Delay, fulfill)
+	q.orders = append(q.orders, order)
+}
+
+// Reset forgets every pending order, cancelling their fulfillment.
+func (q *NumberOrderQueue) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, order := range q.orders {
+		if order.timer != nil {
+			order.timer.Stop()
+		}
+	}
+	q.orders = nil
+}
+
+// PendingNumberOrder is a number order in a NumberOrderQueue.
+type PendingNumberOrder struct {
+	// ID is the ID of the order, which is stored in the server's resource
+	// store.
+	ID string
+
+	// DueAt is when the order is fulfilled.
+	DueAt time.Time
+
+	// URL and FailoverURL are where the order's webhooks go.
+	URL         string
+	FailoverURL string
+
+	// timer fulfills the order once it's due.
+	timer stopper
+}
+
+//
+// Private values
+//
+
+// Number orders are fulfilled this long after they're placed unless
+// configured otherwise.
+const defaultNumberOrderDelay = 2 * time.Second
+
+// numberOrderCompleteEvent is sent once a number order has been fulfilled.
+const numberOrderCompleteEvent = "number_order.complete"
+
+// The statuses of a number order, and of each number in it.
+const (
+	numberOrderStatusFailure = "failure"
+	numberOrderStatusPending = "pending"
+	numberOrderStatusSuccess = "success"
+)
+
+// orderedNumberResources are the paths of the resources that represent an
+// ordered number. They all share the number's ID.
+var orderedNumberResources = []spec.Path{
+	"/phone_numbers/{id}",
+	"/phone_numbers/{id}/messaging",
+	"/phone_numbers/{id}/voice",
+}
+
+//
+// Private functions
+//
+
+// reconcileWithNumberOrders brings a request to order numbers and its
+// generated response in line with what the order will do. It's only used in
+// stateful mode, after the response has been reconciled with the store.
+//
+// A new order is pending, as is each number in it, and is queued to be
+// fulfilled. Its `number_order.complete` webhook is held back until then, so
+// it's removed from the webhooks that the request triggers right away.
+func (s *StubServer) reconcileWithNumberOrders(r *http.Request, route *stubServerRoute,
+	requestData map[string]interface{}, responseData interface{},
+	webhooks []string) (interface{}, []string) {
+
+	if string(route.path) != "/number_orders" || r.Method != http.MethodPost {
+		return responseData, webhooks
+	}
+
+	responseMap, _ := responseData.(map[string]interface{})
+	data, _ := responseMap["data"].(map[string]interface{})
+	if data == nil {
+		return responseData, webhooks
+	}
+
+	id, _ := data["id"].(string)
+	rng := newCreateRand(s.seed, atomic.AddUint64(&s.created, 1))
+
+	// The request's numbers replace the generated ones wholesale, so each
+	// is filled back out.
+	phoneNumbers, _ := data["phone_numbers"].([]interface{})
+	for i, phoneNumber := range phoneNumbers {
+		entry, ok := phoneNumber.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		entry = deepCopy(entry).(map[string]interface{})
+		entry["id"] = randomUUID(rng)
+		entry["record_type"] = "number_order_phone_number"
+		entry["status"] = numberOrderStatusPending
+		if _, ok := entry["regulatory_requirements"]; !ok {
+			entry["regulatory_requirements"] = []interface{}{}
+		}
+		if _, ok := entry["requirements_met"]; !ok {
+			entry["requirements_met"] = true
+		}
+		phoneNumbers[i] = entry
+	}
+	data["phone_numbers_count"] = len(phoneNumbers)
+	data["status"] = numberOrderStatusPending
+	s.store.Put(data)
+
+	order := &PendingNumberOrder{ID: id}
+	if s.webhooks != nil {
+		order.URL, order.FailoverURL = s.webhookURLs(requestData)
+	}
+	s.numberOrders.Push(order, s.fulfillNumberOrders)
+
+	var immediate []string
+	for _, eventType := range webhooks {
+		if eventType != numberOrderCompleteEvent {
+			immediate = append(immediate, eventType)
+		}
+	}
+	return responseData, immediate
+}
+
+// fulfillNumberOrders fulfills every number order that's due. Each number in
+// an order is either ordered, in which case it shows up in the phone number
+// lists with the order's connection and messaging profile, or fails if it
+// matches the queue's failure pattern. Each number has its own status, and
+// like Telnyx's, the order's status is `success` if any of its numbers were
+// ordered and `failure` if none were. Then its `number_order.complete`
+// webhook is sent.
+//
+// It runs when an order is due, and before any request in stateful mode, so
+// that orders are fulfilled on time even if the server is busy.
+func (s *StubServer) fulfillNumberOrders() {
+	s.numberOrders.Fulfill(func(pending *PendingNumberOrder) {
+		order, ok := s.store.Get("number_order", pending.ID)
+		if !ok {
+			return
+		}
+
+		now := formatTimestamp(s.numberOrders.now())
+		connectionID, _ := order["connection_id"].(string)
+		messagingProfileID, _ := order["messaging_profile_id"].(string)
+
+		var ordered, failed int
+		phoneNumbers, _ := order["phone_numbers"].([]interface{})
+		for _, entry := range phoneNumbers {
+			entry, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			phoneNumber, _ := entry["phone_number"].(string)
+			if s.numberOrders.Fails(phoneNumber) {
+				entry["status"] = numberOrderStatusFailure
+				failed++
+				continue
+			}
+
+			entry["status"] = numberOrderStatusSuccess
+			ordered++
+			s.storeOrderedNumber(phoneNumber, connectionID, messagingProfileID, now)
+		}
+
+		status := numberOrderStatusSuccess
+		if failed > 0 && ordered == 0 {
+			status = numberOrderStatusFailure
+		}
+
+		order["status"] = status
+		order["updated_at"] = now
+		s.store.Put(order)
+
+		if pending.URL == "" {
+			return
+		}
+
+		event, err := s.generateEvent(numberOrderCompleteEvent, nil)
+		if err != nil {
+			fmt.Printf("Couldn't generate %s webhook: %v\n", numberOrderCompleteEvent, err)
+			return
+		}
+		event["payload"] = order
+
+		go s.webhooks.Emit(pending.URL, pending.FailoverURL, []map[string]interface{}{event})
+	})
+}
+
+// generateResource generates the resource that's retrieved from a path, or
+// returns nil if the path isn't in the spec.
+func (s *StubServer) generateResource(path spec.Path) map[string]interface{} {
+	operation, ok := s.spec.Paths[path]["get"]
+	if !ok {
+		return nil
+	}
+
+	response, ok := operation.Responses["200"]
+	if !ok {
+		return nil
+	}
+
+	responseObject, err := response.ResolveRef(s.spec.Components.Responses)
+	if err != nil {
+		return nil
+	}
+
+	mediaType, ok := responseObject.Content["application/json"]
+	if !ok || mediaType.Schema == nil || mediaType.Schema.Properties["data"] == nil {
+		return nil
+	}
+
+	schema, err := mediaType.Schema.Properties["data"].ResolveRef(s.spec.Components.Schemas)
+	if err != nil {
+		return nil
+	}
+
+	generator := DataGenerator{s.spec.Components.Schemas, s.currentFixtures(), nil}
+	data, err := generator.generateInternal(&GenerateParams{
+		schema:  schema.FlattenAllOf(),
+		context: fmt.Sprintf("Generating %s resource:\n", path),
+		example: generator.prepareSchemaExample(schema),
+	})
+	if err != nil {
+		fmt.Printf("Couldn't generate %s resource: %v\n", path, err)
+		return nil
+	}
+
+	resource, _ := data.(map[string]interface{})
+	return resource
+}
+
+// storeOrderedNumber stores the resources that represent a newly ordered
+// number, so that it shows up in the phone number lists.
+func (s *StubServer) storeOrderedNumber(phoneNumber, connectionID, messagingProfileID, now string) {
+	rng := newCreateRand(s.seed, atomic.AddUint64(&s.created, 1))
+	id := syntheticNumericString("id", rng)
+
+	// Only properties that the resource has are set, apart from its ID and
+	// number.
+	values := map[string]interface{}{
+		"status":               "active",
+		"connection_id":        connectionID,
+		"messaging_profile_id": messagingProfileID,
+		"messaging_enabled":    messagingProfileID != "",
+		"purchased_at":         now,
+		"created_at":           now,
+		"updated_at":           now,
+	}
+
+	for _, path := range orderedNumberResources {
+		resource := s.generateResource(path)
+		if resource == nil {
+			continue
+		}
+
+		resource["id"] = id
+		resource["phone_number"] = phoneNumber
+		for key, value := range values {
+			if _, ok := resource[key]; ok {
+				resource[key] = value
+			}
+		}
+		s.store.Put(resource)
+	}
+}
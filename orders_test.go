@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/team-telnyx/telnyx-mock/webhook"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_NumberOrderFulfillment(t *testing.T) {
+	events := make(chan map[string]interface{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+
+		events <- body["data"].(map[string]interface{})
+	}))
+	defer receiver.Close()
+
+	signer, err := webhook.GenerateSigner()
+	assert.NoError(t, err)
+
+	now := time.Unix(1500000000, 0)
+
+	server := getStatefulStubServer(t)
+	server.numberOrders.Delay = time.Hour
+	server.numberOrders.FailurePattern = regexp.MustCompile(`0102$`)
+	server.numberOrders.now = func() time.Time { return now }
+	server.webhooks = &WebhookEmitter{Sender: &webhook.Sender{Signer: signer}}
+
+	get := func(path string) interface{} {
+		resp, body := sendRequestToServer(t, server, "GET", path, "", getDefaultHeaders())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+		return data["data"]
+	}
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v2/number_orders",
+		`{"phone_numbers": [{"phone_number": "+13125550101"}, {"phone_number": "+13125550102"}],
+		  "connection_id": "123", "messaging_profile_id": "profile_123",
+		  "webhook_url": "`+receiver.URL+`"}`,
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	order := data["data"].(map[string]interface{})
+	id := order["id"].(string)
+	assert.Equal(t, numberOrderStatusPending, order["status"])
+	assert.Equal(t, 2.0, order["phone_numbers_count"])
+
+	// Nothing has been ordered yet, and the order's webhook is held back.
+	order = get("/v2/number_orders/" + id).(map[string]interface{})
+	assert.Equal(t, numberOrderStatusPending, order["status"])
+	assert.Empty(t, get("/v2/phone_numbers"))
+	assert.Empty(t, events)
+
+	now = now.Add(time.Hour)
+
+	// Each number has its own status, and an order that's partly ordered
+	// succeeds.
+	order = get("/v2/number_orders/" + id).(map[string]interface{})
+	assert.Equal(t, numberOrderStatusSuccess, order["status"])
+	phoneNumbers := order["phone_numbers"].([]interface{})
+	assert.Equal(t, numberOrderStatusSuccess, phoneNumbers[0].(map[string]interface{})["status"])
+	assert.Equal(t, numberOrderStatusFailure, phoneNumbers[1].(map[string]interface{})["status"])
+
+	// Only the number that was ordered shows up, with the order's settings.
+	numbers := get("/v2/phone_numbers").([]interface{})
+	assert.Equal(t, 1, len(numbers))
+	number := numbers[0].(map[string]interface{})
+	assert.Equal(t, "+13125550101", number["phone_number"])
+	assert.Equal(t, "123", number["connection_id"])
+	assert.Equal(t, "profile_123", number["messaging_profile_id"])
+
+	messaging := get("/v2/phone_numbers/messaging").([]interface{})
+	assert.Equal(t, 1, len(messaging))
+	assert.Equal(t, number["id"], messaging[0].(map[string]interface{})["id"])
+	assert.Equal(t, "profile_123", messaging[0].(map[string]interface{})["messaging_profile_id"])
+
+	voice := get("/v2/phone_numbers/voice").([]interface{})
+	assert.Equal(t, 1, len(voice))
+	assert.Equal(t, "123", voice[0].(map[string]interface{})["connection_id"])
+
+	select {
+	case event := <-events:
+		assert.Equal(t, numberOrderCompleteEvent, event["event_type"])
+		payload := event["payload"].(map[string]interface{})
+		assert.Equal(t, id, payload["id"])
+		assert.Equal(t, numberOrderStatusSuccess, payload["status"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for webhook")
+	}
+
+	// An order fails if none of its numbers are ordered.
+	resp, body = sendRequestToServer(t, server, "POST", "/v2/number_orders",
+		`{"phone_numbers": [{"phone_number": "+13125550102"}]}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	id = data["data"].(map[string]interface{})["id"].(string)
+
+	now = now.Add(time.Hour)
+	order = get("/v2/number_orders/" + id).(map[string]interface{})
+	assert.Equal(t, numberOrderStatusFailure, order["status"])
+}
+
+//
+// Tests for private functions
+//
+
+func TestNumberOrderQueue_Fulfill(t *testing.T) {
+	clock := NewClock()
+	clock.Freeze()
+	queue := NewNumberOrderQueue()
+	queue.now = clock.Now
+	queue.afterFunc = clock.afterFunc
+
+	queue.Push(&PendingNumberOrder{ID: "order_1"}, func() {})
+	clock.Advance(queue.Delay)
+
+	// Orders can be placed while others are being fulfilled.
+	var fulfilled []string
+	queue.Fulfill(func(order *PendingNumberOrder) {
+		fulfilled = append(fulfilled, order.ID)
+		queue.Push(&PendingNumberOrder{ID: "order_2"}, func() {})
+	})
+	assert.Equal(t, []string{"order_1"}, fulfilled)
+
+	clock.Advance(queue.Delay)
+	queue.Fulfill(func(order *PendingNumberOrder) {
+		fulfilled = append(fulfilled, order.ID)
+	})
+	assert.Equal(t, []string{"order_1", "order_2"}, fulfilled)
+}
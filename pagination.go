@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strconv"
+)
+
+//
+// Private values
+//
+
+const (
+	// defaultListSize is the number of resources that a generated list has
+	// in total unless configured otherwise.
+	defaultListSize = 50
+
+	// defaultPageSize is the number of resources on a page of a list when
+	// `page[size]` isn't specified. It's the same as the live API's default.
+	defaultPageSize = 20
+)
+
+//
+// Private functions
+//
+
+// pageItemCount returns the number of items that appear on a page of a list
+// with the given total number of items.
+func pageItemCount(totalResults int, pageNumber int, pageSize int) int {
+	// Pages past the last are empty. This is checked before working out
+	// where the page starts so that huge page numbers can't overflow.
+	if pageNumber-1 >= totalPages(totalResults, pageSize) {
+		return 0
+	}
+
+	start := (pageNumber - 1) * pageSize
+
+	if remaining := totalResults - start; remaining < pageSize {
+		return remaining
+	}
+	return pageSize
+}
+
+// pageParams extracts the requested page number and size from a request's
+// `page[number]` and `page[size]` parameters, falling back to the first page
+// and defaultPageSize respectively.
+func pageParams(requestData map[string]interface{}) (int, int) {
+	pageNumber := 1
+	pageSize := defaultPageSize
+
+	page, ok := requestData["page"].(map[string]interface{})
+	if !ok {
+		return pageNumber, pageSize
+	}
+
+	if number, ok := positiveInt(page["number"]); ok {
+		pageNumber = number
+	}
+	if size, ok := positiveInt(page["size"]); ok {
+		pageSize = size
+	}
+
+	return pageNumber, pageSize
+}
+
+// paginate returns the items that appear on a page of a list.
+func paginate(items []interface{}, pageNumber int, pageSize int) []interface{} {
+	count := pageItemCount(len(items), pageNumber, pageSize)
+	if count < 1 {
+		return []interface{}{}
+	}
+
+	start := (pageNumber - 1) * pageSize
+	return items[start : start+count]
+}
+
+// positiveInt interprets a request parameter as a positive integer. Query
+// parameters may or may not have been coerced to a number depending on the
+// schema they were validated against, so strings are accepted too.
+func positiveInt(value interface{}) (int, bool) {
+	var n int
+
+	switch v := value.(type) {
+	case int:
+		n = v
+	case int64:
+		n = int(v)
+	case float64:
+		n = int(v)
+	case string:
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	return n, n > 0
+}
+
+// setPageMeta fills in the pagination properties of a list's `meta` object.
+// It does nothing if the list doesn't have one.
+func setPageMeta(meta interface{}, totalResults int, pageNumber int, pageSize int) {
+	metaMap, ok := meta.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	metaMap["page_number"] = pageNumber
+	metaMap["page_size"] = pageSize
+	metaMap["total_pages"] = totalPages(totalResults, pageSize)
+	metaMap["total_results"] = totalResults
+}
+
+// totalPages returns the number of pages that a list with the given total
+// number of items is split into.
+func totalPages(totalResults int, pageSize int) int {
+	if totalResults < 1 {
+		return 0
+	}
+	return (totalResults-1)/pageSize + 1
+}
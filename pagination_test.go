@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_Paginates(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures, listSize: 45}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		query      string
+		numItems   int
+		pageNumber float64
+		pageSize   float64
+		totalPages float64
+	}{
+		{"", 20, 1, 20, 3},
+		{"?page[number]=2", 20, 2, 20, 3},
+		{"?page[number]=3", 5, 3, 20, 3},
+		{"?page[number]=4", 0, 4, 20, 3},
+		{"?page[size]=45", 45, 1, 45, 1},
+		{"?page[number]=2&page[size]=44", 1, 2, 44, 2},
+
+		// Page numbers so large that finding where the page starts would
+		// overflow are past the end like any other.
+		{"?page[number]=4611686018427387905&page[size]=3", 0, 4611686018427387905, 3, 15},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.query, func(t *testing.T) {
+			resp, body := sendRequestToServer(t, server, "GET",
+				"/v2/messaging_profiles"+testCase.query, "", getDefaultHeaders())
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var data map[string]interface{}
+			err := json.Unmarshal(body, &data)
+			assert.NoError(t, err)
+
+			assert.Equal(t, testCase.numItems, len(data["data"].([]interface{})))
+
+			meta := data["meta"].(map[string]interface{})
+			assert.Equal(t, testCase.pageNumber, meta["page_number"])
+			assert.Equal(t, testCase.pageSize, meta["page_size"])
+			assert.Equal(t, testCase.totalPages, meta["total_pages"])
+			assert.Equal(t, 45.0, meta["total_results"])
+		})
+	}
+}
+
+func TestStubServer_PaginatesStored(t *testing.T) {
+	server := getStatefulStubServer(t)
+
+	for i := 0; i < 3; i++ {
+		server.store.Put(map[string]interface{}{
+			"id":          fmt.Sprintf("profile_%d", i),
+			"record_type": "messaging_profile",
+		})
+	}
+
+	resp, body := sendRequestToServer(t, server, "GET",
+		"/v2/messaging_profiles?page[number]=2&page[size]=2", "", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, len(data["data"].([]interface{})))
+
+	meta := data["meta"].(map[string]interface{})
+	assert.Equal(t, 2.0, meta["page_number"])
+	assert.Equal(t, 2.0, meta["total_pages"])
+	assert.Equal(t, 3.0, meta["total_results"])
+}
+
+//
+// Tests for private functions
+//
+
+func TestPageItemCount(t *testing.T) {
+	assert.Equal(t, 20, pageItemCount(50, 1, 20))
+	assert.Equal(t, 10, pageItemCount(50, 3, 20))
+	assert.Equal(t, 0, pageItemCount(50, 4, 20))
+	assert.Equal(t, 0, pageItemCount(0, 1, 20))
+	assert.Equal(t, 1, pageItemCount(1, 1, 1))
+	assert.Equal(t, 0, pageItemCount(50, math.MaxInt64/2+2, 3))
+}
+
+func TestPageParams(t *testing.T) {
+	testCases := []struct {
+		requestData map[string]interface{}
+		pageNumber  int
+		pageSize    int
+	}{
+		{nil, 1, defaultPageSize},
+		{map[string]interface{}{"page": map[string]interface{}{"number": "3"}}, 3, defaultPageSize},
+		{map[string]interface{}{"page": map[string]interface{}{"size": 5}}, 1, 5},
+		{map[string]interface{}{"page": map[string]interface{}{"number": 2.0, "size": "7"}}, 2, 7},
+		{map[string]interface{}{"page": map[string]interface{}{"number": "0", "size": "x"}}, 1, defaultPageSize},
+	}
+	for _, testCase := range testCases {
+		pageNumber, pageSize := pageParams(testCase.requestData)
+		assert.Equal(t, testCase.pageNumber, pageNumber)
+		assert.Equal(t, testCase.pageSize, pageSize)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := []interface{}{1, 2, 3, 4, 5}
+	assert.Equal(t, []interface{}{1, 2}, paginate(items, 1, 2))
+	assert.Equal(t, []interface{}{5}, paginate(items, 3, 2))
+	assert.Equal(t, []interface{}{}, paginate(items, 4, 2))
+	assert.Equal(t, []interface{}{}, paginate(items, math.MaxInt64/2+2, 3))
+}
+
+func TestSetPageMeta(t *testing.T) {
+	meta := map[string]interface{}{"total_pages": 3}
+	setPageMeta(meta, 55, 2, 25)
+	assert.Equal(t, map[string]interface{}{
+		"page_number":   2,
+		"page_size":     25,
+		"total_pages":   3,
+		"total_results": 55,
+	}, meta)
+
+	setPageMeta(meta, 0, 1, 25)
+	assert.Equal(t, 0, meta["total_pages"])
+
+	// Lists without meta are left alone.
+	setPageMeta(nil, 55, 2, 25)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/team-telnyx/telnyx-mock/spec"
 )
@@ -40,6 +41,21 @@ func CoerceParams(schema *spec.Schema, data map[string]interface{}) error {
 			// and coerce it
 		}
 
+		// Arrays of primitive values in a query string can be given as a
+		// single comma-separated value (e.g. `filter[features]=voice,sms`).
+		if valStr, ok := val.(string); ok && subSchema.Type == arrayType &&
+			subSchema.Items != nil && (subSchema.Items.Type == stringType ||
+			isSchemaPrimitiveType(subSchema.Items)) {
+			valSlice := make([]interface{}, 0)
+			for _, item := range strings.Split(valStr, ",") {
+				if item != "" {
+					valSlice = append(valSlice, item)
+				}
+			}
+			data[key] = valSlice
+			val = valSlice
+		}
+
 		valArr, ok := val.([]interface{})
 		if ok {
 			if subSchema.Items != nil {
@@ -81,6 +97,7 @@ const (
 	integerType = "integer"
 	numberType  = "number"
 	objectType  = "object"
+	stringType  = "string"
 )
 
 // maxSliceSize defines a somewhat arbitrary maximum size on an incoming
@@ -81,6 +81,25 @@ func TestCoerceParams_ArrayCoercion(t *testing.T) {
 		assert.Equal(t, 124, sliceVal[2])
 	}
 
+	// Array given as a comma-separated string
+	{
+		schema := &spec.Schema{Properties: map[string]*spec.Schema{
+			"arraykey": {
+				Items: &spec.Schema{
+					Type: integerType,
+				},
+				Type: arrayType,
+			},
+		}}
+		data := map[string]interface{}{
+			"arraykey": "123,124",
+		}
+
+		err := CoerceParams(schema, data)
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{123, 124}, data["arraykey"])
+	}
+
 	// Array of objects
 	{
 		schema := &spec.Schema{Properties: map[string]*spec.Schema{
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+//
+// Public types
+//
+
+// PortoutRegistry holds the comments posted on port-outs. Port-outs
+// themselves are kept in the server's resource store, but their comments
+// can't be, because the spec gives comments the same `record_type` as the
+// port-outs that they're on.
+//
+// It's safe for concurrent use.
+type PortoutRegistry struct {
+	mu       sync.Mutex
+	comments map[string][]map[string]interface{}
+}
+
+// NewPortoutRegistry initializes a new PortoutRegistry without any comments.
+func NewPortoutRegistry() *PortoutRegistry {
+	return &PortoutRegistry{
+		comments: make(map[string][]map[string]interface{}),
+	}
+}
+
+// Comment records a comment on a port-out.
+func (r *PortoutRegistry) Comment(portoutID string, comment map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.comments[portoutID] = append(r.comments[portoutID],
+		deepCopy(comment).(map[string]interface{}))
+}
+
+// Comments returns the comments on a port-out, oldest first.
+func (r *PortoutRegistry) Comments(portoutID string) []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	comments := make([]interface{}, len(r.comments[portoutID]))
+	for i, comment := range r.comments[portoutID] {
+		comments[i] = deepCopy(comment)
+	}
+	return comments
+}
+
+// Reset forgets every comment.
+func (r *PortoutRegistry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.comments = make(map[string][]map[string]interface{})
+}
+
+//
+// Private values
+//
+
+// The statuses that a port-out goes through.
+const (
+	portoutStatusAuthorized = "authorized"
+	portoutStatusPending    = "pending"
+	portoutStatusRejected   = "rejected"
+)
+
+const invalidPortoutTransition = "Port-out '%s' is %s, so it can't be %s."
+
+// portoutTransitions are the statuses that a port-out can be moved to from
+// each status. Port-outs are only ever decided on once.
+var portoutTransitions = map[string][]string{
+	portoutStatusPending: {portoutStatusAuthorized, portoutStatusRejected},
+}
+
+//
+// Private functions
+//
+
+// reconcileWithPortouts brings a generated response for one of the port-out
+// endpoints in line with the server's state. It's only used in stateful mode,
+// in place of reconciling with the store.
+//
+// Port-outs are requested by other carriers, so they can't be created through
+// the API. Instead, a port-out that's used by ID for the first time is taken
+// to be a new, pending one, and is remembered from then on. It can be moved
+// to another status with `PATCH /portouts/{id}/{status}` if the move is one of
+// portoutTransitions, and a ResponseError and its status code are returned if
+// it isn't.
+func (s *StubServer) reconcileWithPortouts(r *http.Request, route *stubServerRoute,
+	pathParams *PathParamsMap, requestData map[string]interface{},
+	responseData interface{}) (interface{}, int, *ResponseError) {
+
+	if string(route.path) == "/portouts" {
+		responseData, telnyxError := s.reconcileWithStore(r, route, pathParams,
+			requestData, responseData)
+		return responseData, http.StatusNotFound, telnyxError
+	}
+
+	responseMap, _ := responseData.(map[string]interface{})
+	if responseMap == nil {
+		return responseData, 0, nil
+	}
+
+	id := pathParamValue(route, pathParams, "id")
+	portout := s.portout(id)
+	if portout == nil {
+		return nil, http.StatusInternalServerError, createInternalServerError()
+	}
+
+	switch string(route.path) + " " + r.Method {
+	case "/portouts/{id} " + http.MethodGet:
+		responseMap["data"] = portout
+
+	case "/portouts/{id}/{status} " + http.MethodPatch:
+		status := pathParamValue(route, pathParams, "status")
+		current, _ := portout["status"].(string)
+		if !isPortoutTransition(current, status) {
+			return nil, http.StatusUnprocessableEntity, createTelnyxError(errorCodeBadRequest,
+				fmt.Sprintf(invalidPortoutTransition, id, current, status))
+		}
+
+		portout["status"] = status
+		stampTimestamps(portout, s.now(), false)
+		s.store.Put(portout)
+		responseMap["data"] = portout
+
+	case "/portouts/{id}/comments " + http.MethodGet:
+		comments := s.portouts.Comments(id)
+		pageNumber, pageSize := pageParams(requestData)
+		responseMap["data"] = paginate(comments, pageNumber, pageSize)
+		setPageMeta(responseMap["meta"], len(comments), pageNumber, pageSize)
+
+	case "/portouts/{id}/comments " + http.MethodPost:
+		comment, _ := responseMap["data"].(map[string]interface{})
+		if comment == nil {
+			break
+		}
+
+		comment["body"], _ = requestData["body"].(string)
+		comment["portout_id"] = id
+		comment["created_at"] = formatTimestamp(s.now())
+		s.portouts.Comment(id, comment)
+	}
+
+	return responseMap, 0, nil
+}
+
+// portout returns the stored port-out with an ID, storing a new, pending one
+// if there isn't one yet. It returns nil if a port-out can't be generated.
+func (s *StubServer) portout(id string) map[string]interface{} {
+	if portout, ok := s.store.Get("portout", id); ok {
+		return portout
+	}
+
+	portout := s.generateResource("/portouts/{id}")
+	if portout == nil {
+		return nil
+	}
+
+	portout["id"] = id
+	portout["status"] = portoutStatusPending
+	stampTimestamps(portout, s.now(), true)
+	s.store.Put(portout)
+	return portout
+}
+
+// isPortoutPath returns whether a path belongs to the port-out API.
+func isPortoutPath(routePath string) bool {
+	return routePath == "/portouts" || strings.HasPrefix(routePath, "/portouts/")
+}
+
+// isPortoutTransition returns whether a port-out can be moved from one status
+// to another.
+func isPortoutTransition(from, to string) bool {
+	for _, status := range portoutTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
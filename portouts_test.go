@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/team-telnyx/telnyx-mock/webhook"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_Portouts(t *testing.T) {
+	events := make(chan map[string]interface{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+
+		events <- body["data"].(map[string]interface{})
+	}))
+	defer receiver.Close()
+
+	signer, err := webhook.GenerateSigner()
+	assert.NoError(t, err)
+
+	server := getStatefulStubServer(t)
+	server.webhooks = &WebhookEmitter{
+		DefaultURL: receiver.URL,
+		Sender:     &webhook.Sender{Signer: signer},
+	}
+
+	send := func(method, path, body string, status int) map[string]interface{} {
+		resp, respBody := sendRequestToServer(t, server, method, path, body, getDefaultHeaders())
+		assert.Equal(t, status, resp.StatusCode, string(respBody))
+
+		var data map[string]interface{}
+		err := json.Unmarshal(respBody, &data)
+		assert.NoError(t, err)
+		return data
+	}
+	receive := func() map[string]interface{} {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for webhook")
+		}
+		return nil
+	}
+
+	const id = "0ccc7b54-4df3-4bca-a65a-3da1ecc777f1"
+	const otherID = "0ccc7b54-4df3-4bca-a65a-3da1ecc777f2"
+
+	// The status in the path isn't taken for the port-out's ID.
+	_, pathParams := server.routeRequest(&http.Request{Method: "PATCH",
+		URL: &url.URL{Path: "/v2/portouts/" + id + "/authorized"}})
+	assert.Equal(t, id, *pathParams.PrimaryID)
+	assert.Equal(t, 1, len(pathParams.SecondaryIDs))
+	assert.Equal(t, "status", pathParams.SecondaryIDs[0].Name)
+	assert.Equal(t, portoutStatusAuthorized, pathParams.SecondaryIDs[0].ID)
+
+	data := send("GET", "/v2/portouts", "", http.StatusOK)
+	assert.Empty(t, data["data"])
+
+	// A port-out is pending until it's decided on.
+	portout := send("GET", "/v2/portouts/"+id, "", http.StatusOK)["data"].(map[string]interface{})
+	assert.Equal(t, id, portout["id"])
+	assert.Equal(t, portoutStatusPending, portout["status"])
+
+	data = send("GET", "/v2/portouts", "", http.StatusOK)
+	assert.Equal(t, 1, len(data["data"].([]interface{})))
+
+	portout = send("PATCH", "/v2/portouts/"+id+"/authorized", "{}", http.StatusOK)["data"].(map[string]interface{})
+	assert.Equal(t, id, portout["id"])
+	assert.Equal(t, portoutStatusAuthorized, portout["status"])
+
+	event := receive()
+	assert.Equal(t, "portout.status_changed", event["event_type"])
+	assert.Equal(t, portoutStatusAuthorized, event["payload"].(map[string]interface{})["status"])
+
+	portout = send("GET", "/v2/portouts/"+id, "", http.StatusOK)["data"].(map[string]interface{})
+	assert.Equal(t, portoutStatusAuthorized, portout["status"])
+
+	// It can only be decided on once.
+	data = send("PATCH", "/v2/portouts/"+id+"/rejected", "{}", http.StatusUnprocessableEntity)
+	responseError := data["errors"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, errorCodeBadRequest, responseError["code"])
+	assert.Equal(t, "Port-out '"+id+"' is authorized, so it can't be rejected.", responseError["detail"])
+
+	portout = send("PATCH", "/v2/portouts/"+otherID+"/rejected", "{}", http.StatusOK)["data"].(map[string]interface{})
+	assert.Equal(t, portoutStatusRejected, portout["status"])
+	assert.Equal(t, "portout.status_changed", receive()["event_type"])
+
+	// Comments are kept for the port-out that they were posted on.
+	comment := send("POST", "/v2/portouts/"+id+"/comments", `{"body": "Approved by ops"}`,
+		http.StatusOK)["data"].(map[string]interface{})
+	assert.Equal(t, "Approved by ops", comment["body"])
+	assert.Equal(t, id, comment["portout_id"])
+
+	event = receive()
+	assert.Equal(t, "portout.new_comment", event["event_type"])
+	assert.Equal(t, "Approved by ops", event["payload"].(map[string]interface{})["body"])
+
+	data = send("GET", "/v2/portouts/"+id+"/comments", "", http.StatusOK)
+	comments := data["data"].([]interface{})
+	assert.Equal(t, 1, len(comments))
+	assert.Equal(t, comment, comments[0])
+	assert.Equal(t, 1.0, data["meta"].(map[string]interface{})["total_results"])
+
+	data = send("GET", "/v2/portouts/"+otherID+"/comments", "", http.StatusOK)
+	assert.Empty(t, data["data"])
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Public types
+//
+
+// Cassette holds responses recorded from an upstream API, keyed by the
+// requests that they were responses to, so that they can be replayed
+// offline.
+//
+// It's safe for concurrent use.
+type Cassette struct {
+	mu           sync.Mutex
+	path         string
+	interactions []*Interaction
+}
+
+// LoadCassette loads the cassette stored at path. A file that doesn't exist
+// yet is treated as an empty cassette that's created when the first
+// interaction is recorded.
+func LoadCassette(path string) (*Cassette, error) {
+	cassette := &Cassette{path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cassette, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading cassette: %v", err)
+	}
+
+	var file cassetteFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding cassette: %v", err)
+	}
+
+	cassette.interactions = file.Interactions
+	return cassette, nil
+}
+
+// Find returns the interaction recorded for a request with the given key, or
+// nil if there isn't one.
+func (c *Cassette) Find(key string) *Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, interaction := range c.interactions {
+		if interaction.Key == key {
+			return interaction
+		}
+	}
+	return nil
+}
+
+// Record adds an interaction to the cassette, replacing any that was
+// recorded for the same request, and saves the cassette to its file.
+func (c *Cassette) Record(interaction *Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	replaced := false
+	for i, existing := range c.interactions {
+		if existing.Key == interaction.Key {
+			c.interactions[i] = interaction
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		c.interactions = append(c.interactions, interaction)
+	}
+
+	data, err := json.MarshalIndent(&cassetteFile{Interactions: c.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.path, append(data, '\n'), 0644)
+}
+
+// Interaction is a response recorded from an upstream API along with the
+// request that it was a response to.
+type Interaction struct {
+	// Key identifies the request. See cassetteKey.
+	Key string `json:"key"`
+
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+
+	// Body is the response body. It's kept as JSON in the cassette if it was
+	// JSON so that cassettes are easy to read and edit, and as a string
+	// otherwise.
+	Body interface{} `json:"body"`
+}
+
+// write writes the recorded response.
+func (i *Interaction) write(w http.ResponseWriter, r *http.Request, start time.Time) {
+	for name, values := range i.Headers {
+		w.Header()[name] = values
+	}
+
+	body, ok := i.Body.(string)
+	if !ok {
+		// Without a recorded content type, the body is encoded like any other
+		// JSON response.
+		if w.Header().Get("Content-Type") == "" {
+			writeResponse(w, r, start, i.Status, i.Body)
+			return
+		}
+
+		encoded, err := json.Marshal(i.Body)
+		if err != nil {
+			fmt.Printf("Error serializing recorded response: %v\n", err)
+			writeResponse(w, r, start, http.StatusInternalServerError, nil)
+			return
+		}
+		body = string(encoded)
+	}
+
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/plain")
+	}
+	writeResponse(w, r, start, i.Status, []byte(body))
+}
+
+// Proxy records responses from an upstream API to a Cassette and replays
+// them.
+type Proxy struct {
+	Cassette *Cassette
+
+	// Client is the client that requests are forwarded with. It defaults to
+	// http.DefaultClient.
+	Client *http.Client
+
+	// Record is whether requests are forwarded to Upstream and recorded. If
+	// it's false, responses are only replayed from Cassette.
+	Record bool
+
+	// Upstream is the base URL of the API that requests are forwarded to,
+	// including the version (e.g. `https://api.telnyx.com/v2`).
+	Upstream *url.URL
+}
+
+// Forward sends a request on to the upstream API and records its response.
+// body is the request's body, which has already been read.
+func (p *Proxy) Forward(r *http.Request, body []byte) (*Interaction, error) {
+	upstreamURL := *p.Upstream
+	upstreamURL.Path = strings.TrimSuffix(upstreamURL.Path, "/") +
+		strings.TrimPrefix(r.URL.Path, "/v2")
+	upstreamURL.RawQuery = r.URL.RawQuery
+
+	upstreamRequest, err := http.NewRequest(r.Method, upstreamURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range r.Header {
+		if !hopByHopHeaders[http.CanonicalHeaderKey(name)] {
+			upstreamRequest.Header[name] = values
+		}
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(upstreamRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(http.Header)
+	for name, values := range resp.Header {
+		if !hopByHopHeaders[name] && !unrecordedHeaders[name] {
+			headers[name] = values
+		}
+	}
+
+	interaction := &Interaction{
+		Key:     cassetteKey(r, body),
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Status:  resp.StatusCode,
+		Headers: headers,
+		Body:    string(respBody),
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(respBody, &decoded); err == nil {
+		interaction.Body = decoded
+	}
+
+	err = p.Cassette.Record(interaction)
+	if err != nil {
+		return nil, fmt.Errorf("error saving cassette: %v", err)
+	}
+
+	return interaction, nil
+}
+
+// Replay returns the interaction recorded for a request, or nil if there
+// isn't one.
+func (p *Proxy) Replay(r *http.Request, body []byte) *Interaction {
+	return p.Cassette.Find(cassetteKey(r, body))
+}
+
+//
+// Private values
+//
+
+// defaultUpstreamURL is the API that requests are forwarded to when
+// recording unless configured otherwise.
+const defaultUpstreamURL = "https://api.telnyx.com/v2"
+
+// hopByHopHeaders are headers that only apply to a single connection, so
+// they're never forwarded.
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// unrecordedHeaders are response headers that describe a response as it was
+// sent rather than its content, so they aren't recorded.
+var unrecordedHeaders = map[string]bool{
+	"Content-Length": true,
+	"Date":           true,
+	"Set-Cookie":     true,
+}
+
+//
+// Private types
+//
+
+// cassetteFile is the format that a Cassette is stored in.
+type cassetteFile struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+//
+// Private functions
+//
+
+// cassetteKey identifies a request in a cassette by its method, path, and
+// parameters. Parameters are normalized so that requests that only differ in
+// the order of their query parameters or of the keys of their JSON body have
+// the same key.
+func cassetteKey(r *http.Request, body []byte) string {
+	params := make(map[string]interface{})
+
+	if query := r.URL.Query(); len(query) > 0 {
+		params["query"] = query
+	}
+
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 {
+		var decoded interface{}
+		if err := json.Unmarshal(trimmed, &decoded); err == nil {
+			params["body"] = decoded
+		} else {
+			params["body"] = string(trimmed)
+		}
+	}
+
+	// Maps are encoded with their keys sorted, so this is stable.
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		panic(err)
+	}
+
+	return r.Method + " " + r.URL.Path + " " + string(encoded)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_RecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telnyx-mock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cassettePath := filepath.Join(dir, "cassette.json")
+
+	var upstreamRequests []*http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests = append(upstreamRequests, r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "req_upstream")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"data": {"id": "upstream", "path": "` + r.URL.Path + `"}}`))
+	}))
+
+	getServer := func(record bool) *StubServer {
+		cassette, err := LoadCassette(cassettePath)
+		assert.NoError(t, err)
+
+		upstreamURL, err := url.Parse(upstream.URL + "/v2")
+		assert.NoError(t, err)
+
+		server := &StubServer{
+			spec:     &realSpec,
+			fixtures: &realFixtures,
+			proxy: &Proxy{
+				Cassette: cassette,
+				Record:   record,
+				Upstream: upstreamURL,
+			},
+		}
+		err = server.initializeRouter()
+		assert.NoError(t, err)
+		return server
+	}
+
+	getID := func(body []byte) string {
+		var data map[string]interface{}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+		return data["data"].(map[string]interface{})["id"].(string)
+	}
+
+	server := getServer(true)
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v2/messages?a=1&b=2",
+		`{"from": "+13125550001", "to": "+13125550002"}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "upstream", getID(body))
+
+	assert.Equal(t, 1, len(upstreamRequests))
+	assert.Equal(t, "/v2/messages", upstreamRequests[0].URL.Path)
+	assert.Equal(t, "a=1&b=2", upstreamRequests[0].URL.RawQuery)
+	assert.Equal(t, "Bearer KEYSUPERSECRET", upstreamRequests[0].Header.Get("Authorization"))
+
+	// Replaying doesn't need the upstream API.
+	upstream.Close()
+	server = getServer(false)
+
+	// Parameters are matched regardless of their order.
+	resp, body = sendRequestToServer(t, server, "POST", "/v2/messages?b=2&a=1",
+		`{"to": "+13125550002", "from": "+13125550001"}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "req_upstream", resp.Header.Get("X-Request-Id"))
+	assert.Equal(t, "upstream", getID(body))
+
+	// Unrecorded requests are generated as usual.
+	resp, body = sendRequestToServer(t, server, "POST", "/v2/messages",
+		`{"from": "+13125550001", "to": "+13125550003"}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, "upstream", getID(body))
+
+	// Replayed responses still require authorization.
+	resp, _ = sendRequestToServer(t, server, "POST", "/v2/messages?a=1&b=2",
+		`{"from": "+13125550001", "to": "+13125550002"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestLoadCassette(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telnyx-mock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cassettePath := filepath.Join(dir, "cassette.json")
+
+	// A cassette that doesn't exist yet starts out empty.
+	cassette, err := LoadCassette(cassettePath)
+	assert.NoError(t, err)
+	assert.Nil(t, cassette.Find("GET /v2/calls {}"))
+
+	err = cassette.Record(&Interaction{Key: "GET /v2/calls {}", Status: 200, Body: "first"})
+	assert.NoError(t, err)
+	err = cassette.Record(&Interaction{Key: "GET /v2/calls {}", Status: 200, Body: "second"})
+	assert.NoError(t, err)
+
+	cassette, err = LoadCassette(cassettePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", cassette.Find("GET /v2/calls {}").Body)
+
+	err = ioutil.WriteFile(cassettePath, []byte("{"), 0644)
+	assert.NoError(t, err)
+	_, err = LoadCassette(cassettePath)
+	assert.Error(t, err)
+}
+
+//
+// Tests for private functions
+//
+
+func TestCassetteKey(t *testing.T) {
+	key := func(method, target, body string) string {
+		return cassetteKey(httptest.NewRequest(method, target, nil), []byte(body))
+	}
+
+	assert.Equal(t, `GET /v2/calls {}`, key("GET", "/v2/calls", ""))
+	assert.Equal(t, key("GET", "/v2/calls?a=1&b=2", ""), key("GET", "/v2/calls?b=2&a=1", ""))
+	assert.NotEqual(t, key("GET", "/v2/calls?a=1", ""), key("GET", "/v2/calls?a=2", ""))
+	assert.NotEqual(t, key("GET", "/v2/calls", ""), key("DELETE", "/v2/calls", ""))
+	assert.Equal(t, key("POST", "/v2/calls", `{"a": 1, "b": 2}`),
+		key("POST", "/v2/calls", `{"b":2,"a":1}`))
+}
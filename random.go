@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Private values
+//
+
+// headerSeed is a request header that sets the seed for the PRNG that
+// generated data is varied with, overriding the -seed option. The seed that
+// was used is also sent back in a response header of the same name.
+const headerSeed = "Telnyx-Mock-Seed"
+
+const invalidSeed = "Invalid `" + headerSeed + "` header: '%s'. Expected an integer like `42`."
+
+// uuidPattern matches UUIDs.
+var uuidPattern = regexp.MustCompile(`\A[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\z`)
+
+//
+// Private functions
+//
+
+// formatUUID formats 16 bytes as a UUID.
+func formatUUID(uuid []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x",
+		uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+// newCreateRand returns a PRNG for minting the ID of the resource created by
+// the nth request to create one. Unlike newRequestRand, it doesn't depend on
+// the request, so that creating the same thing twice creates two different
+// resources, but the same seed and sequence of requests still always mint the
+// same IDs.
+func newCreateRand(seed int64, n uint64) *rand.Rand {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d\ncreate\n%d\n", seed, n)
+
+	return rand.New(rand.NewSource(int64(hash.Sum64())))
+}
+
+// newRequestRand returns a PRNG for generating the response to a request.
+// It's seeded with both seed and the request, so that the same seed and
+// request always produce the same response, but different requests get
+// different data.
+//
+// Pagination parameters are left out so that each page of a list is a page
+// of the same list.
+func newRequestRand(seed int64, r *http.Request, body []byte) *rand.Rand {
+	query := r.URL.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "page[") {
+			query.Del(key)
+		}
+	}
+
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d\n%s\n%s\n%s\n", seed, r.Method, r.URL.Path, query.Encode())
+	hash.Write(body)
+
+	return rand.New(rand.NewSource(int64(hash.Sum64())))
+}
+
+// randomCallControlID generates a call control ID, which are `v2:` followed
+// by a string of letters and digits.
+func randomCallControlID(rng *rand.Rand) string {
+	const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	id := make([]byte, 51)
+	for i := range id {
+		id[i] = alphabet[rng.Intn(len(alphabet))]
+	}
+	return "v2:" + string(id)
+}
+
+// randomUUID generates a version 4 UUID with a PRNG.
+func randomUUID(rng *rand.Rand) string {
+	var uuid [16]byte
+	rng.Read(uuid[:])
+
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return formatUUID(uuid[:])
+}
+
+// requestedSeed returns the seed that a request asked for with headerSeed, or
+// defaultSeed if it didn't ask for one.
+func requestedSeed(r *http.Request, defaultSeed int64) (int64, *ResponseError) {
+	header := r.Header.Get(headerSeed)
+	if header == "" {
+		return defaultSeed, nil
+	}
+
+	seed, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return 0, createTelnyxError(errorCodeBadRequest, fmt.Sprintf(invalidSeed, header))
+	}
+	return seed, nil
+}
+
+// varyID generates a new ID with the same shape as id: UUIDs become other
+// UUIDs, and otherwise each letter and digit is replaced with another of the
+// same kind. Prefixes that end in a colon or underscore (e.g. `v2:` or
+// `cus_`) are kept.
+func varyID(id string, rng *rand.Rand) string {
+	if uuidPattern.MatchString(id) {
+		return randomUUID(rng)
+	}
+
+	prefixLength := strings.LastIndexAny(id, ":_") + 1
+
+	varied := []byte(id)
+	for i := prefixLength; i < len(varied); i++ {
+		switch c := varied[i]; {
+		case c >= '0' && c <= '9':
+			// Don't introduce a leading zero into a number.
+			if i == prefixLength {
+				varied[i] = byte('1' + rng.Intn(9))
+			} else {
+				varied[i] = byte('0' + rng.Intn(10))
+			}
+		case c >= 'a' && c <= 'z':
+			varied[i] = byte('a' + rng.Intn(26))
+		case c >= 'A' && c <= 'Z':
+			varied[i] = byte('A' + rng.Intn(26))
+		}
+	}
+	return string(varied)
+}
+
+// varyListItem gives a generated item of a list its own identity, so that the
+// items of a list aren't all copies of the same one: its ID is replaced with a
+// new one of the same shape, and its timestamps are moved back by a random
+// amount.
+func varyListItem(item interface{}, rng *rand.Rand) {
+	itemMap, ok := item.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if id, ok := itemMap["id"].(string); ok && id != "" {
+		itemMap["id"] = varyID(id, rng)
+	}
+
+	// Every timestamp moves by the same amount so that they stay in the same
+	// order relative to each other.
+	offset := time.Duration(rng.Int63n(int64(30 * 24 * time.Hour))).Truncate(time.Second)
+	for key, value := range itemMap {
+		str, ok := value.(string)
+		if !ok || !strings.HasSuffix(key, "_at") {
+			continue
+		}
+
+		timestamp, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			continue
+		}
+		itemMap[key] = timestamp.Add(-offset).Format(time.RFC3339)
+	}
+}
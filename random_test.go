@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_SeedsGeneratedData(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures, seed: 42}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	listProfiles := func(query string, seed string) (*http.Response, []byte) {
+		headers := getDefaultHeaders()
+		if seed != "" {
+			headers[headerSeed] = seed
+		}
+		return sendRequestToServer(t, server, "GET", "/v2/messaging_profiles"+query, "", headers)
+	}
+
+	getIDs := func(body []byte) []string {
+		var data struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err)
+
+		ids := make([]string, len(data.Data))
+		for i, item := range data.Data {
+			ids[i] = item.ID
+		}
+		return ids
+	}
+
+	resp, body := listProfiles("", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "42", resp.Header.Get(headerSeed))
+
+	// Every item gets its own ID.
+	ids := getIDs(body)
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		assert.False(t, seen[id], "duplicate ID: %s", id)
+		seen[id] = true
+	}
+
+	// The same seed and request produce the same response.
+	_, sameBody := listProfiles("", "")
+	assert.Equal(t, string(body), string(sameBody))
+
+	_, sameBody = listProfiles("", "42")
+	assert.Equal(t, string(body), string(sameBody))
+
+	// Pages are pages of the same list.
+	_, pageBody := listProfiles("?page[number]=1&page[size]=5", "")
+	assert.Equal(t, ids[:5], getIDs(pageBody))
+
+	// Another seed produces another list.
+	resp, otherBody := listProfiles("", "7")
+	assert.Equal(t, "7", resp.Header.Get(headerSeed))
+	assert.NotEqual(t, ids, getIDs(otherBody))
+
+	resp, _ = listProfiles("", "seven")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStubServer_MintsIDsOnCreate(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	create := func(path string, body string) map[string]interface{} {
+		resp, respBody := sendRequestToServer(t, server, "POST", path, body, getDefaultHeaders())
+		assert.True(t, resp.StatusCode < 300, "unexpected status %d", resp.StatusCode)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(respBody, &data)
+		assert.NoError(t, err)
+		return data["data"].(map[string]interface{})
+	}
+
+	// Creating the same thing twice creates two resources.
+	profile := create("/v2/messaging_profiles", `{"name": "Profile"}`)
+	otherProfile := create("/v2/messaging_profiles", `{"name": "Profile"}`)
+	assert.Regexp(t, uuidPattern, profile["id"])
+	assert.NotEqual(t, "3fa85f64-5717-4562-b3fc-2c963f66afa6", profile["id"])
+	assert.NotEqual(t, profile["id"], otherProfile["id"])
+
+	// Connection IDs are numeric strings.
+	connection := create("/v2/credential_connections",
+		`{"connection_name": "Connection", "user_name": "user", "password": "password"}`)
+	assert.Regexp(t, `^[1-9][0-9]+$`, connection["id"])
+
+	call := create("/v2/calls",
+		`{"connection_id": "123", "to": "+13125550001", "from": "+13125550002"}`)
+	assert.Regexp(t, `^v2:[a-zA-Z0-9]+$`, call["call_control_id"])
+
+	// After a reset, the same requests mint the same IDs again.
+	server.reset()
+	assert.Equal(t, profile["id"], create("/v2/messaging_profiles", `{"name": "Profile"}`)["id"])
+}
+
+//
+// Tests for private functions
+//
+
+func TestNewCreateRand(t *testing.T) {
+	assert.Equal(t, newCreateRand(1, 1).Int63(), newCreateRand(1, 1).Int63())
+	assert.NotEqual(t, newCreateRand(1, 1).Int63(), newCreateRand(1, 2).Int63())
+	assert.NotEqual(t, newCreateRand(1, 1).Int63(), newCreateRand(2, 1).Int63())
+}
+
+func TestNewRequestRand(t *testing.T) {
+	draw := func(seed int64, target string, body string) int64 {
+		r := httptest.NewRequest("GET", target, nil)
+		return newRequestRand(seed, r, []byte(body)).Int63()
+	}
+
+	assert.Equal(t, draw(1, "/v2/calls", ""), draw(1, "/v2/calls", ""))
+	assert.NotEqual(t, draw(1, "/v2/calls", ""), draw(2, "/v2/calls", ""))
+	assert.NotEqual(t, draw(1, "/v2/calls", ""), draw(1, "/v2/messages", ""))
+	assert.NotEqual(t, draw(1, "/v2/calls", ""), draw(1, "/v2/calls", "{}"))
+
+	// Pagination doesn't matter, and neither does the order of the query.
+	assert.Equal(t, draw(1, "/v2/calls?a=1&b=2", ""),
+		draw(1, "/v2/calls?b=2&page[number]=3&a=1", ""))
+}
+
+func TestVaryID(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	uuid := "3fa85f64-5717-4562-b3fc-2c963f66afa6"
+	varied := varyID(uuid, rng)
+	assert.NotEqual(t, uuid, varied)
+	assert.Regexp(t, uuidPattern, varied)
+
+	assert.Regexp(t, `^v2:[a-zA-Z0-9]{8}-[0-9]{3}$`, varyID("v2:abcDEF12-345", rng))
+	assert.Regexp(t, `^cus_[0-9]{3}$`, varyID("cus_123", rng))
+	assert.Regexp(t, `^[1-9][0-9]{9}$`, varyID("1293384261", rng))
+}
+
+func TestVaryListItem(t *testing.T) {
+	item := map[string]interface{}{
+		"id":         "1293384261",
+		"created_at": "2020-01-01T12:00:00Z",
+		"updated_at": "2020-01-02T12:00:00Z",
+		"name":       "Profile",
+	}
+	varyListItem(item, rand.New(rand.NewSource(1)))
+
+	assert.NotEqual(t, "1293384261", item["id"])
+	assert.NotEqual(t, "2020-01-01T12:00:00Z", item["created_at"])
+	assert.Equal(t, "Profile", item["name"])
+
+	// Timestamps keep their order.
+	assert.True(t, item["created_at"].(string) < item["updated_at"].(string))
+}
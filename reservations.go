@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//
+// Public types
+//
+
+// ReservationRegistry tracks which numbers are held by number reservations,
+// and until when. A reservation expires once Duration has passed since it was
+// made or last extended, at which point its numbers are free to be reserved
+// or ordered by anyone else.
+//
+// It's safe for concurrent use.
+type ReservationRegistry struct {
+	mu           sync.Mutex
+	reservations map[string]*reservation
+
+	// Duration is how long a reservation holds its numbers for, both when
+	// it's made and each time that it's extended.
+	Duration time.Duration
+
+	// now returns the current time. It's a field so that the passage of time
+	// can be controlled.
+	now func() time.Time
+}
+
+// NewReservationRegistry initializes a new, empty ReservationRegistry with the
+// default duration.
+func NewReservationRegistry() *ReservationRegistry {
+	return &ReservationRegistry{
+		reservations: make(map[string]*reservation),
+		Duration:     defaultReservationDuration,
+		now:          time.Now,
+	}
+}
+
+// Extend pushes a reservation's expiry back to Duration from now. It returns
+// false if the reservation isn't known, and a ResponseError if it has
+// already expired, since its numbers may have been taken by someone else.
+func (r *ReservationRegistry) Extend(id string) (bool, *ResponseError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res, ok := r.reservations[id]
+	if !ok {
+		return false, nil
+	}
+
+	now := r.now()
+	if res.isExpired(now) {
+		return true, createTelnyxError(errorCodeBadRequest,
+			fmt.Sprintf(reservationExpired, id))
+	}
+
+	res.expiresAt = now.Add(r.Duration)
+	return true, nil
+}
+
+// Holder returns the ID and customer reference of the reservation that
+// currently holds a number, or false if no reservation that hasn't expired
+// holds it.
+func (r *ReservationRegistry) Holder(phoneNumber string) (string, string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.holder(phoneNumber, r.now())
+}
+
+// Reflect updates a reservation's representation in data to its current
+// state. It returns false if the reservation isn't known.
+func (r *ReservationRegistry) Reflect(id string, data map[string]interface{}) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res, ok := r.reservations[id]
+	if !ok {
+		return false
+	}
+
+	res.reflect(data, r.now())
+	return true
+}
+
+// Reserve records a new reservation holding the given numbers and updates
+// its representation in data to its initial state.
+//
+// A ResponseError is returned if any of the numbers is already held by
+// another reservation, in which case nothing is reserved.
+func (r *ReservationRegistry) Reserve(id, customerReference string, phoneNumbers []string,
+	data map[string]interface{}) *ResponseError {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for i, phoneNumber := range phoneNumbers {
+		if holderID, _, ok := r.holder(phoneNumber, now); ok {
+			return reservedNumberError(phoneNumber, holderID, i)
+		}
+	}
+
+	res := &reservation{
+		customerReference: customerReference,
+		expiresAt:         now.Add(r.Duration),
+		phoneNumbers:      phoneNumbers,
+	}
+	r.reservations[id] = res
+
+	res.reflect(data, now)
+	return nil
+}
+
+// Reset forgets every reservation.
+func (r *ReservationRegistry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reservations = make(map[string]*reservation)
+}
+
+// holder is Holder for a given time. The registry must be locked.
+func (r *ReservationRegistry) holder(phoneNumber string, now time.Time) (string, string, bool) {
+	for id, res := range r.reservations {
+		if res.isExpired(now) {
+			continue
+		}
+
+		for _, reserved := range res.phoneNumbers {
+			if reserved != "" && reserved == phoneNumber {
+				return id, res.customerReference, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+//
+// Private values
+//
+
+// Reservations hold their numbers for this long unless configured otherwise,
+// which is how long they last in the live API.
+const defaultReservationDuration = 30 * time.Minute
+
+// The status of a reservation, and of each number in it, once its numbers
+// have been reserved.
+const reservationStatusSuccess = "success"
+
+const (
+	numberReserved     = "Number %s is reserved by number reservation '%s'."
+	reservationExpired = "Number reservation '%s' has expired and can't be extended."
+)
+
+//
+// Private types
+//
+
+// reservation is the state of a single number reservation.
+type reservation struct {
+	customerReference string
+	expiresAt         time.Time
+	phoneNumbers      []string
+}
+
+// isExpired returns whether the reservation has expired at the given time.
+func (res *reservation) isExpired(now time.Time) bool {
+	return !now.Before(res.expiresAt)
+}
+
+// reflect updates the representation of the reservation in data to what it
+// is at the given time, setting `expired_at` and `expired` for each of its
+// numbers.
+func (res *reservation) reflect(data map[string]interface{}, now time.Time) {
+	phoneNumbers, _ := data["phone_numbers"].([]interface{})
+	for _, entry := range phoneNumbers {
+		entry, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		entry["expired_at"] = formatTimestamp(res.expiresAt)
+		entry["expired"] = res.isExpired(now)
+	}
+}
+
+//
+// Private functions
+//
+
+// checkReservedNumbers makes sure that a request to reserve or order numbers
+// doesn't take numbers that are held by another reservation. It's only used in
+// stateful mode, before the response has been reconciled with the store so
+// that a rejected reservation or order is never stored.
+//
+// A new reservation is recorded as it's checked so that two reservations
+// can't take the same number at once. Numbers can be ordered by the
+// reservation that holds them, which is the one with the order's
+// `customer_reference`. A reservation without a reference has no way to be
+// identified, so nothing can order its numbers until it expires.
+func (s *StubServer) checkReservedNumbers(r *http.Request, route *stubServerRoute,
+	requestData map[string]interface{}, responseData interface{}) *ResponseError {
+
+	if r.Method != http.MethodPost {
+		return nil
+	}
+
+	responseMap, _ := responseData.(map[string]interface{})
+	data, _ := responseMap["data"].(map[string]interface{})
+	if data == nil {
+		return nil
+	}
+
+	customerReference, _ := requestData["customer_reference"].(string)
+	phoneNumbers := requestedPhoneNumbers(data)
+
+	switch string(route.path) {
+	case "/number_reservations":
+		id, _ := data["id"].(string)
+		now := formatTimestamp(s.reservations.now())
+		rng := newCreateRand(s.seed, atomic.AddUint64(&s.created, 1))
+
+		// The request's numbers replace the generated ones wholesale, so
+		// each is filled back out.
+		entries, _ := data["phone_numbers"].([]interface{})
+		for i, entry := range entries {
+			entry, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			entry = deepCopy(entry).(map[string]interface{})
+			entry["id"] = randomUUID(rng)
+			entry["record_type"] = "reserved_phone_number"
+			entry["status"] = reservationStatusSuccess
+			entry["created_at"] = now
+			entry["updated_at"] = now
+			entries[i] = entry
+		}
+		data["status"] = reservationStatusSuccess
+		data["created_at"] = now
+		data["updated_at"] = now
+
+		return s.reservations.Reserve(id, customerReference, phoneNumbers, data)
+
+	case "/number_orders":
+		for i, phoneNumber := range phoneNumbers {
+			holderID, holderReference, ok := s.reservations.Holder(phoneNumber)
+			if ok && (holderReference == "" || holderReference != customerReference) {
+				return reservedNumberError(phoneNumber, holderID, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// reconcileWithReservations brings a generated response in line with the
+// state of the reservations that it includes. It's only used in stateful mode,
+// after the response has been reconciled with the store.
+//
+// Retrieved reservations reflect whether they've expired, and
+// `actions/extend` extends the reservation that it targets and returns it.
+func (s *StubServer) reconcileWithReservations(r *http.Request, route *stubServerRoute,
+	pathParams *PathParamsMap, responseData interface{}) (interface{}, int, *ResponseError) {
+
+	responseMap, _ := responseData.(map[string]interface{})
+	if responseMap == nil {
+		return responseData, 0, nil
+	}
+
+	switch string(route.path) {
+	case "/number_reservations":
+		if r.Method != http.MethodGet {
+			break
+		}
+
+		items, _ := responseMap["data"].([]interface{})
+		for _, item := range items {
+			if item, ok := item.(map[string]interface{}); ok {
+				id, _ := item["id"].(string)
+				s.reservations.Reflect(id, item)
+			}
+		}
+
+	case "/number_reservations/{number_reservation_id}":
+		if data, ok := responseMap["data"].(map[string]interface{}); ok {
+			id, _ := data["id"].(string)
+			s.reservations.Reflect(id, data)
+		}
+
+	case "/number_reservations/{number_reservation_id}/actions/extend":
+		id := pathParamValue(route, pathParams, "number_reservation_id")
+		ok, telnyxError := s.reservations.Extend(id)
+		if telnyxError != nil {
+			return nil, http.StatusUnprocessableEntity, telnyxError
+		}
+
+		data, stored := s.store.Get("number_reservation", id)
+		if !ok || !stored {
+			return nil, http.StatusNotFound, createTelnyxError(errorCodeResourceNotFound,
+				fmt.Sprintf(resourceNotFound, "number_reservation", id))
+		}
+
+		data["updated_at"] = formatTimestamp(s.reservations.now())
+		s.reservations.Reflect(id, data)
+		s.store.Put(data)
+		responseMap["data"] = data
+	}
+
+	return responseMap, 0, nil
+}
+
+// requestedPhoneNumbers returns the numbers in the `phone_numbers` of a
+// reservation or order, in the same order. Entries without a number are
+// empty.
+func requestedPhoneNumbers(data map[string]interface{}) []string {
+	entries, _ := data["phone_numbers"].([]interface{})
+	phoneNumbers := make([]string, len(entries))
+	for i, entry := range entries {
+		entry, _ := entry.(map[string]interface{})
+		phoneNumbers[i], _ = entry["phone_number"].(string)
+	}
+	return phoneNumbers
+}
+
+// reservedNumberError is the error for a request that tries to take a number
+// that's held by another reservation. It points at the number's place in the
+// request's `phone_numbers`.
+func reservedNumberError(phoneNumber, holderID string, index int) *ResponseError {
+	return createTelnyxErrorWithSource(errorCodeBadRequest,
+		fmt.Sprintf(numberReserved, phoneNumber, holderID),
+		&ResponseErrorSource{Pointer: "/phone_numbers/" + strconv.Itoa(index) + "/phone_number"})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_NumberReservations(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	server := getStatefulStubServer(t)
+	server.reservations.now = func() time.Time { return now }
+
+	send := func(method, path, body string, status int) map[string]interface{} {
+		resp, respBody := sendRequestToServer(t, server, method, path, body, getDefaultHeaders())
+		assert.Equal(t, status, resp.StatusCode, string(respBody))
+
+		var data map[string]interface{}
+		err := json.Unmarshal(respBody, &data)
+		assert.NoError(t, err)
+		return data
+	}
+	reservedNumber := func(data map[string]interface{}) map[string]interface{} {
+		reservation := data["data"].(map[string]interface{})
+		return reservation["phone_numbers"].([]interface{})[0].(map[string]interface{})
+	}
+
+	data := send("POST", "/v2/number_reservations",
+		`{"phone_numbers": [{"phone_number": "+13125550101"}], "customer_reference": "MY REF 001"}`,
+		http.StatusOK)
+	id := data["data"].(map[string]interface{})["id"].(string)
+	number := reservedNumber(data)
+	assert.Equal(t, "+13125550101", number["phone_number"])
+	assert.Equal(t, "2020-01-01T12:30:00Z", number["expired_at"])
+	assert.Equal(t, false, number["expired"])
+
+	// Another reservation can't take the number, and neither can an order
+	// that isn't from the reservation.
+	data = send("POST", "/v2/number_reservations",
+		`{"phone_numbers": [{"phone_number": "+13125550101"}]}`,
+		http.StatusUnprocessableEntity)
+	responseError := data["errors"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, errorCodeBadRequest, responseError["code"])
+	assert.Equal(t, "/phone_numbers/0/phone_number",
+		responseError["source"].(map[string]interface{})["pointer"])
+
+	send("POST", "/v2/number_orders",
+		`{"phone_numbers": [{"phone_number": "+13125550101"}], "customer_reference": "MY REF 002"}`,
+		http.StatusUnprocessableEntity)
+	send("POST", "/v2/number_orders",
+		`{"phone_numbers": [{"phone_number": "+13125550101"}], "customer_reference": "MY REF 001"}`,
+		http.StatusOK)
+
+	// Extending pushes the expiry back from now.
+	now = now.Add(20 * time.Minute)
+	data = send("POST", "/v2/number_reservations/"+id+"/actions/extend", "{}", http.StatusOK)
+	assert.Equal(t, id, data["data"].(map[string]interface{})["id"])
+	assert.Equal(t, "2020-01-01T12:50:00Z", reservedNumber(data)["expired_at"])
+
+	data = send("GET", "/v2/number_reservations/"+id, "", http.StatusOK)
+	assert.Equal(t, "2020-01-01T12:50:00Z", reservedNumber(data)["expired_at"])
+	assert.Equal(t, false, reservedNumber(data)["expired"])
+
+	// Once it's expired, it can't be extended, and its number is free.
+	now = now.Add(30 * time.Minute)
+	data = send("GET", "/v2/number_reservations/"+id, "", http.StatusOK)
+	assert.Equal(t, true, reservedNumber(data)["expired"])
+
+	data = send("GET", "/v2/number_reservations", "", http.StatusOK)
+	reservations := data["data"].([]interface{})
+	assert.Equal(t, 1, len(reservations))
+	number = reservations[0].(map[string]interface{})["phone_numbers"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, true, number["expired"])
+
+	send("POST", "/v2/number_reservations/"+id+"/actions/extend", "{}",
+		http.StatusUnprocessableEntity)
+	send("POST", "/v2/number_reservations/does-not-exist/actions/extend", "{}",
+		http.StatusNotFound)
+
+	send("POST", "/v2/number_reservations",
+		`{"phone_numbers": [{"phone_number": "+13125550101"}]}`,
+		http.StatusOK)
+
+	// Without a reference, nothing is from the reservation, including an
+	// order without one either.
+	send("POST", "/v2/number_orders",
+		`{"phone_numbers": [{"phone_number": "+13125550101"}]}`,
+		http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/team-telnyx/telnyx-mock/spec"
+)
+
+//
+// Public types
+//
+
+// ScenarioRegistry holds scenarios configured through the admin API, which
+// apply to matching requests without them having to ask via header.
+//
+// It's safe for concurrent use.
+type ScenarioRegistry struct {
+	mu    sync.Mutex
+	rules []*ScenarioRule
+}
+
+// NewScenarioRegistry initializes a new ScenarioRegistry without any
+// scenarios.
+func NewScenarioRegistry() *ScenarioRegistry {
+	return &ScenarioRegistry{}
+}
+
+// Add configures a new scenario. An error is returned if the rule is
+// invalid.
+func (r *ScenarioRegistry) Add(rule *ScenarioRule) *ResponseError {
+	if telnyxError := rule.validate(); telnyxError != nil {
+		return telnyxError
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rules = append(r.rules, rule)
+	return nil
+}
+
+// List returns copies of the configured scenarios in the order that they're
+// matched.
+func (r *ScenarioRegistry) List() []ScenarioRule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rules := make([]ScenarioRule, len(r.rules))
+	for i, rule := range r.rules {
+		rules[i] = *rule
+	}
+	return rules
+}
+
+// Match returns the status code that a request should be responded to with
+// according to the first scenario that matches it, or zero if none do.
+// routePath is the path of the route that the request was routed to, as it
+// appears in the spec.
+//
+// Matching a scenario that only applies a limited number of times uses one
+// of them up.
+func (r *ScenarioRegistry) Match(method string, path string, routePath string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, rule := range r.rules {
+		if !rule.matches(method, path, routePath) {
+			continue
+		}
+
+		if rule.Count > 0 {
+			rule.Count--
+			if rule.Count == 0 {
+				r.rules = append(r.rules[:i:i], r.rules[i+1:]...)
+			}
+		}
+
+		return rule.status()
+	}
+
+	return 0
+}
+
+// Reset removes every scenario.
+func (r *ScenarioRegistry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rules = nil
+}
+
+// ScenarioRule is a scenario configured through the admin API.
+type ScenarioRule struct {
+	// Method is the HTTP method of the requests that the scenario applies
+	// to. Empty matches any method.
+	Method string `json:"method,omitempty"`
+
+	// Path is the path of the requests that the scenario applies to. It can
+	// either be a request's path, or the path of an operation as it appears
+	// in the spec, like `/calls/{call_control_id}`. The `/v2` prefix is
+	// optional. Empty matches every path.
+	Path string `json:"path,omitempty"`
+
+	// Scenario is the name of one of the scenarios in responseScenarios.
+	// Exactly one of Scenario and Status is set.
+	Scenario string `json:"scenario,omitempty"`
+
+	// Status is the status code to respond with.
+	Status int `json:"status,omitempty"`
+
+	// Count is the number of matching requests left that the scenario
+	// applies to, after which it's removed. Zero means that it applies until
+	// it's reset.
+	Count int `json:"count,omitempty"`
+}
+
+// matches returns whether the rule applies to a request.
+func (r *ScenarioRule) matches(method string, path string, routePath string) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+
+	if r.Path == "" {
+		return true
+	}
+
+	rulePath := strings.TrimPrefix(r.Path, "/v2")
+	return rulePath == strings.TrimPrefix(path, "/v2") || rulePath == routePath
+}
+
+// status returns the status code that the rule responds with.
+func (r *ScenarioRule) status() int {
+	if r.Scenario != "" {
+		return responseScenarios[strings.ToLower(r.Scenario)]
+	}
+	return r.Status
+}
+
+// validate checks that the rule can be applied.
+func (r *ScenarioRule) validate() *ResponseError {
+	switch {
+	case r.Scenario != "" && r.Status != 0:
+		return createTelnyxError(errorCodeBadRequest,
+			"Specify only one of `scenario` or `status`.")
+
+	case r.Scenario != "":
+		if _, ok := responseScenarios[strings.ToLower(r.Scenario)]; !ok {
+			return createTelnyxErrorWithSource(errorCodeBadRequest,
+				fmt.Sprintf("Unknown scenario: '%s'.", r.Scenario),
+				&ResponseErrorSource{Pointer: "/scenario"})
+		}
+
+	case r.Status < 100 || r.Status > 599:
+		return createTelnyxErrorWithSource(errorCodeBadRequest,
+			fmt.Sprintf("Invalid status: %d. Expected an HTTP status code like `422`.", r.Status),
+			&ResponseErrorSource{Pointer: "/status"})
+	}
+
+	if r.Count < 0 {
+		return createTelnyxErrorWithSource(errorCodeBadRequest,
+			"`count` can't be negative.", &ResponseErrorSource{Pointer: "/count"})
+	}
+
+	return nil
+}
+
+//
+// Private values
+//
+
+const (
+	// headerResponseStatus is a request header that asks telnyx-mock to
+	// respond with a specific status code (e.g. `422`) instead of success.
+	headerResponseStatus = "Telnyx-Mock-Response-Status"
+
+	// headerScenario is a request header that asks telnyx-mock to respond
+	// according to one of the named scenarios in responseScenarios.
+	headerScenario = "Telnyx-Mock-Scenario"
+
+	invalidResponseStatus = "Invalid `" + headerResponseStatus + "` header: '%s'. " +
+		"Expected an HTTP status code like `422`."
+	invalidScenario       = "Unknown `" + headerScenario + "` header: '%s'."
+	unsupportedStatusCode = "Operation doesn't define a `%d` response so one " +
+		"can't be generated."
+)
+
+// responseScenarios maps the names of scenarios that can be requested with
+// headerScenario to the status code of the response that they produce.
+var responseScenarios = map[string]int{
+	"bad_request":         http.StatusBadRequest,
+	"forbidden":           http.StatusForbidden,
+	"not_found":           http.StatusNotFound,
+	"rate_limited":        http.StatusTooManyRequests,
+	"server_error":        http.StatusInternalServerError,
+	"service_unavailable": http.StatusServiceUnavailable,
+	"unauthorized":        http.StatusUnauthorized,
+	"validation_failed":   http.StatusUnprocessableEntity,
+}
+
+// statusErrorCodes maps HTTP status codes to the Telnyx error code that best
+// describes them. Statuses that aren't present fall back to
+// errorCodeUnexpectedError.
+var statusErrorCodes = map[int]string{
+	http.StatusBadRequest:          errorCodeBadRequest,
+	http.StatusUnauthorized:        errorCodeAuthenticationFailed,
+	http.StatusForbidden:           errorCodeAuthorizationFailed,
+	http.StatusNotFound:            errorCodeResourceNotFound,
+	http.StatusUnprocessableEntity: errorCodeBadRequest,
+	http.StatusTooManyRequests:     errorCodeTooManyRequests,
+}
+
+//
+// Private functions
+//
+
+// generateStatusResponse generates the body of a non-success response with
+// the given status code for an operation.
+//
+// The operation's response for the status (or its `default` response) is
+// used if it describes a body. Error responses in the Telnyx spec rarely do,
+// so if the generated body doesn't contain any errors, a Telnyx error
+// appropriate for the status code is returned instead.
+func (s *StubServer) generateStatusResponse(route *stubServerRoute, status int) interface{} {
+	response, ok := route.operation.Responses[spec.StatusCode(strconv.Itoa(status))]
+	if !ok {
+		response, ok = route.operation.Responses["default"]
+	}
+
+	detail := http.StatusText(status)
+
+	if ok {
+		responseObject, err := response.ResolveRef(s.spec.Components.Responses)
+		if err != nil {
+			fmt.Printf("error resolving response ref: %s\n", err)
+			return createInternalServerError()
+		}
+
+		if responseObject.Description != "" {
+			detail = responseObject.Description
+		}
+
+		if content, ok := responseObject.Content["application/json"]; ok && content.Schema != nil {
+			generator := DataGenerator{s.spec.Components.Schemas, s.currentFixtures(), nil}
+
+			data, err := generator.generateInternal(&GenerateParams{
+				schema:  content.Schema.FlattenAllOf(),
+				context: fmt.Sprintf("Responding with status %d:\n", status),
+				example: generator.prepareSchemaExample(content.Schema),
+			})
+			if err != nil {
+				fmt.Printf("Couldn't generate response: %v\n", err)
+				return createInternalServerError()
+			}
+
+			if dataMap, ok := data.(map[string]interface{}); ok {
+				if errors, ok := dataMap["errors"].([]interface{}); ok && len(errors) > 0 {
+					return dataMap
+				}
+			}
+		}
+	}
+
+	code, ok := statusErrorCodes[status]
+	if !ok {
+		code = errorCodeUnexpectedError
+	}
+
+	return createTelnyxError(code, detail)
+}
+
+// isSuccessStatus returns whether the given HTTP status is a 2xx.
+func isSuccessStatus(status int) bool {
+	return status >= 200 && status < 300
+}
+
+// requestedResponseStatus returns the status code that a request has asked
+// telnyx-mock to respond with via either headerResponseStatus or
+// headerScenario. Zero is returned if the request didn't ask for one.
+//
+// A ResponseError is returned if either header is present, but invalid.
+func requestedResponseStatus(r *http.Request) (int, *ResponseError) {
+	if name := r.Header.Get(headerScenario); name != "" {
+		status, ok := responseScenarios[strings.ToLower(name)]
+		if !ok {
+			return 0, createTelnyxError(errorCodeBadRequest,
+				fmt.Sprintf(invalidScenario, name))
+		}
+		return status, nil
+	}
+
+	if value := r.Header.Get(headerResponseStatus); value != "" {
+		status, err := strconv.Atoi(value)
+		if err != nil || status < 100 || status > 599 {
+			return 0, createTelnyxError(errorCodeBadRequest,
+				fmt.Sprintf(invalidResponseStatus, value))
+		}
+		return status, nil
+	}
+
+	return 0, nil
+}
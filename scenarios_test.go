@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_ResponseStatusHeader(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers[headerResponseStatus] = "429"
+
+	resp, body := sendRequest(t, "POST", "/v2/charges",
+		"{\"amount\": \"123\"}", headers)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	errorInfo := getFirstError(t, data)
+	assert.Equal(t, errorCodeTooManyRequests, errorInfo["code"])
+	assert.Equal(t, "Too many requests", errorInfo["title"])
+}
+
+func TestStubServer_ResponseStatusHeaderWithUntypedSchema(t *testing.T) {
+	server := &StubServer{spec: &realSpec, fixtures: &realFixtures}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	// The real spec's error responses don't say that they're objects.
+	headers := getDefaultHeaders()
+	headers[headerResponseStatus] = "429"
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v2/messages",
+		`{"from": "+13125550001", "to": "+13125550002", "text": "Hello"}`, headers)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	assert.Equal(t, errorCodeTooManyRequests, getFirstError(t, data)["code"])
+}
+
+func TestStubServer_ResponseStatusHeaderSkipsValidation(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers[headerResponseStatus] = "500"
+
+	// The request is missing a required parameter, but the requested error
+	// is returned anyway.
+	resp, body := sendRequest(t, "POST", "/v2/charges", "{}", headers)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	errorInfo := getFirstError(t, data)
+	assert.Equal(t, errorCodeUnexpectedError, errorInfo["code"])
+}
+
+func TestStubServer_ResponseStatusHeaderSuccess(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers[headerResponseStatus] = "200"
+
+	resp, _ := sendRequest(t, "POST", "/v2/charges",
+		"{\"amount\": \"123\"}", headers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	headers[headerResponseStatus] = "201"
+
+	resp, body := sendRequest(t, "POST", "/v2/charges",
+		"{\"amount\": \"123\"}", headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	errorInfo := getFirstError(t, data)
+	assert.Contains(t, errorInfo["detail"], "doesn't define a `201` response")
+}
+
+func TestStubServer_ScenarioHeader(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers[headerScenario] = "not_found"
+
+	resp, body := sendRequest(t, "GET", "/v2/charges", "", headers)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	assert.NoError(t, err)
+	errorInfo := getFirstError(t, data)
+	assert.Equal(t, errorCodeResourceNotFound, errorInfo["code"])
+}
+
+func TestStubServer_InvalidScenarioHeaders(t *testing.T) {
+	headers := getDefaultHeaders()
+	headers[headerScenario] = "not_a_scenario"
+
+	resp, _ := sendRequest(t, "GET", "/v2/charges", "", headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	headers = getDefaultHeaders()
+	headers[headerResponseStatus] = "abc"
+
+	resp, _ = sendRequest(t, "GET", "/v2/charges", "", headers)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+//
+// Tests for private functions
+//
+
+func TestRequestedResponseStatus(t *testing.T) {
+	testCases := []struct {
+		headers map[string]string
+		status  int
+		invalid bool
+	}{
+		{map[string]string{}, 0, false},
+		{map[string]string{headerResponseStatus: "422"}, 422, false},
+		{map[string]string{headerResponseStatus: "42"}, 0, true},
+		{map[string]string{headerResponseStatus: "fail"}, 0, true},
+		{map[string]string{headerScenario: "Rate_Limited"}, 429, false},
+		{map[string]string{headerScenario: "unknown"}, 0, true},
+
+		// The scenario header takes precedence.
+		{map[string]string{headerScenario: "forbidden", headerResponseStatus: "422"}, 403, false},
+	}
+	for _, testCase := range testCases {
+		req := httptest.NewRequest("GET", "https://telnyx.com/v2/charges", nil)
+		for k, v := range testCase.headers {
+			req.Header.Set(k, v)
+		}
+
+		status, telnyxError := requestedResponseStatus(req)
+		assert.Equal(t, testCase.status, status)
+		assert.Equal(t, testCase.invalid, telnyxError != nil)
+	}
+}
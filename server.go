@@ -1,16 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lestrrat/go-jsval"
+	"github.com/team-telnyx/telnyx-mock/generator/datareplacer"
 	"github.com/team-telnyx/telnyx-mock/param"
 	"github.com/team-telnyx/telnyx-mock/param/coercer"
 	"github.com/team-telnyx/telnyx-mock/spec"
@@ -104,63 +111,269 @@ func (p *PathParamsSecondaryID) appendReplacedID(replacedID string) {
 	}
 }
 
-// ResponseError is a JSON-serializable structure representing an error
-// returned from Telnyx's API.
-type ResponseError struct {
-	ErrorInfo struct {
-		Message string `json:"message"`
-		Type    string `json:"type"`
-	} `json:"error"`
-}
-
 // StubServer handles incoming HTTP requests and responds to them appropriately
 // based off the set of OpenAPI routes that it's been configured with.
 type StubServer struct {
 	fixtures *spec.Fixtures
 	routes   map[spec.HTTPVerb][]stubServerRoute
 	spec     *spec.Spec
+
+	// fixturesMu guards fixtures, which can be replaced through the admin
+	// API while requests are being handled. Fixtures are never modified in
+	// place, so it only needs to be held while swapping them.
+	fixturesMu sync.RWMutex
+
+	// initialFixtures are the fixtures that the server started with, which
+	// are restored when it's reset through the admin API.
+	initialFixtures *spec.Fixtures
+
+	// scenarios holds the scenarios configured through the admin API.
+	scenarios *ScenarioRegistry
+
+	// journal records requests and their responses.
+	//
+	// nil if journaling is disabled.
+	journal *Journal
+
+	// proxy records responses from an upstream API and replays them.
+	//
+	// nil unless a cassette is in use.
+	proxy *Proxy
+
+	// responseValidation is what to do about generated responses that don't
+	// conform to the schemas that the spec declares for them.
+	//
+	// Empty means responseValidationOff.
+	responseValidation responseValidationMode
+
+	// seed seeds the PRNG that generated data is varied with, unless a
+	// request asks for another with headerSeed.
+	seed int64
+
+	// created counts the requests that have created a resource, so that each
+	// resource gets an ID of its own even if it's created by a request that's
+	// identical to an earlier one. Accessed atomically.
+	created uint64
+
+	// listSize is the total number of resources in generated lists, which
+	// are paged through with `page[number]` and `page[size]`.
+	//
+	// Zero means defaultListSize.
+	listSize int
+
+	// clock keeps the time that resources are stamped with and that delayed
+	// state transitions follow. It can be frozen, set, and advanced through
+	// the admin API.
+	//
+	// The server that main starts always has one. It's only nil for servers
+	// put together without one, like those in tests, which follow real time
+	// without any way to control it.
+	clock *Clock
+
+	// store holds resources that have been created through the API so that
+	// they can be reflected back in subsequent requests.
+	//
+	// nil unless the server is running in stateful mode.
+	store *ResourceStore
+
+	// calls tracks the state of calls made through Call Control.
+	//
+	// nil unless the server is running in stateful mode.
+	calls *CallRegistry
+
+	// messages tracks the lifecycle of messages sent through the API.
+	//
+	// nil unless the server is running in stateful mode.
+	messages *MessageRegistry
+
+	// numberOrders holds number orders until they're fulfilled.
+	//
+	// nil unless the server is running in stateful mode.
+	numberOrders *NumberOrderQueue
+
+	// reservations tracks which numbers are held by number reservations.
+	//
+	// nil unless the server is running in stateful mode.
+	reservations *ReservationRegistry
+
+	// portouts holds the comments posted on port-outs, which are otherwise
+	// kept in store.
+	//
+	// nil unless the server is running in stateful mode.
+	portouts *PortoutRegistry
+
+	// eventSchemas holds the schemas of the events that the spec describes
+	// as callbacks, keyed by event type.
+	eventSchemas map[string]*spec.Schema
+
+	// webhooks delivers the webhooks triggered by requests.
+	//
+	// nil if webhooks are disabled.
+	webhooks *WebhookEmitter
 }
 
 // HandleRequest handes an HTTP request directed at the API stub.
 func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	fmt.Printf("Request: %v %v\n", r.Method, r.URL.Path)
-	fmt.Printf("Headers: %v\n", r.Header)
-	q, _ := url.ParseQuery(r.URL.RawQuery)
-	fmt.Printf("Query: %v\n", q)
-	fmt.Printf("Body: %v\n", r.Body)
+	if verbose {
+		fmt.Printf("Request: %v %v\n", r.Method, r.URL.Path)
+		fmt.Printf("Headers: %v\n", r.Header)
+		q, _ := url.ParseQuery(r.URL.RawQuery)
+		fmt.Printf("Query: %v\n", q)
+	}
+
+	// Read the body so that it can be logged, and then put it back so that
+	// it can still be parsed.
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			fmt.Printf("Couldn't read body: %v\n", err)
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if verbose {
+			fmt.Printf("Body: %s\n", body)
+		}
+	}
+
+	// The admin API controls the mock itself rather than mimicking the
+	// Telnyx API, so it doesn't need authorization, and isn't journaled.
+	if strings.HasPrefix(r.URL.Path, adminPathPrefix) {
+		s.handleAdminRequest(w, r, start)
+		return
+	}
+
+	var (
+		route       *stubServerRoute
+		pathParams  *PathParamsMap
+		requestData map[string]interface{}
+	)
+
+	if s.journal != nil {
+		recorder := &responseRecorder{ResponseWriter: w}
+		w = recorder
+
+		defer func() {
+			entry := &JournalEntry{
+				Method:       r.Method,
+				Path:         r.URL.Path,
+				Headers:      r.Header,
+				Status:       recorder.status,
+				ResponseBody: recorder.decodedBody(),
+			}
+			if requestData != nil {
+				entry.RequestData = deepCopy(requestData).(map[string]interface{})
+			}
+			if route != nil {
+				entry.OperationID = route.operation.OperationID
+				entry.Route = string(route.path)
+			}
+			s.journal.Record(entry)
+		}()
+	}
+
+	// When recording, the upstream API handles the request in its entirety,
+	// including authorization.
+	if s.proxy != nil && s.proxy.Record {
+		interaction, err := s.proxy.Forward(r, body)
+		if err != nil {
+			fmt.Printf("Error forwarding request upstream: %v\n", err)
+			writeResponse(w, r, start, http.StatusBadGateway, createInternalServerError())
+			return
+		}
+		interaction.write(w, r, start)
+		return
+	}
 
 	auth := r.Header.Get("Authorization")
 	if !validateAuth(auth) {
 		message := fmt.Sprintf(invalidAuthorization, auth)
-		telnyxError := createTelnyxError(typeInvalidRequestError, message)
+		telnyxError := createTelnyxError(errorCodeAuthenticationFailed, message)
 		writeResponse(w, r, start, http.StatusUnauthorized, telnyxError)
 		return
 	}
 
+	// Recorded responses take precedence over generated ones, which are
+	// still used for requests that weren't recorded.
+	if s.proxy != nil {
+		if interaction := s.proxy.Replay(r, body); interaction != nil {
+			interaction.write(w, r, start)
+			return
+		}
+	}
+
 	// Every response needs a X-Request-Id header except the invalid authorization
 	w.Header().Set("X-Request-Id", "req_123")
 
 	// Reflect the Request-Id header
 	w.Header().Set("Request-Id", r.Header.Get("Request-Id"))
 
-	route, pathParams := s.routeRequest(r)
+	route, pathParams = s.routeRequest(r)
 
 	if route == nil {
 		message := fmt.Sprintf(invalidRoute, r.Method, r.URL.Path)
-		telnyxError := createTelnyxError(typeInvalidRequestError, message)
+		telnyxError := createTelnyxError(errorCodeResourceNotFound, message)
 		writeResponse(w, r, start, http.StatusNotFound, telnyxError)
 		return
 	}
 
+	// The request's parameters are parsed up front so that they're journaled
+	// even if a response is requested via header and returned right away. A
+	// request that can't be parsed is only rejected once it's clear that it
+	// needs to be.
+	requestData, parseErr := param.ParseParams(r)
+
+	// A specific response may have been requested via header. Non-success
+	// responses are returned right away, before any request validation, so
+	// that they can be produced even for requests that wouldn't succeed.
+	responseStatus, telnyxError := requestedResponseStatus(r)
+	if telnyxError != nil {
+		writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+		return
+	}
+
+	seed, telnyxError := requestedSeed(r, s.seed)
+	if telnyxError != nil {
+		writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+		return
+	}
+	w.Header().Set(headerSeed, strconv.FormatInt(seed, 10))
+
+	if responseStatus == 0 && s.scenarios != nil {
+		responseStatus = s.scenarios.Match(r.Method, r.URL.Path, string(route.path))
+	}
+
+	if responseStatus != 0 && !isSuccessStatus(responseStatus) {
+		writeResponse(w, r, start, responseStatus,
+			s.generateStatusResponse(route, responseStatus))
+		return
+	}
+
 	var (
-		response spec.Response
-		ok       bool
+		response     spec.Response
+		responseCode spec.StatusCode
+		ok           bool
 	)
-	for _, code := range []spec.StatusCode{"200", "201", "202"} {
-		response, ok = route.operation.Responses[code]
-		if ok {
-			break
+	if responseStatus != 0 {
+		responseCode = spec.StatusCode(strconv.Itoa(responseStatus))
+		response, ok = route.operation.Responses[responseCode]
+		if !ok {
+			message := fmt.Sprintf(unsupportedStatusCode, responseStatus)
+			telnyxError := createTelnyxError(errorCodeBadRequest, message)
+			writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
+			return
+		}
+	} else {
+		responseStatus = http.StatusOK
+
+		for _, code := range []spec.StatusCode{"200", "201", "202"} {
+			response, ok = route.operation.Responses[code]
+			if ok {
+				responseCode = code
+				break
+			}
 		}
 	}
 	if !ok {
@@ -196,7 +409,7 @@ func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Set("Content-type", "text/plain")
 
-		writeResponse(w, r, start, http.StatusOK, []byte(value))
+		writeResponse(w, r, start, responseStatus, []byte(value))
 
 		return
 	}
@@ -286,11 +499,10 @@ func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("Response schema: %s\n", responseContent.Schema)
 	}
 
-	requestData, err := param.ParseParams(r)
-	if err != nil {
-		message := fmt.Sprintf("Couldn't parse query/body: %v", err)
+	if parseErr != nil {
+		message := fmt.Sprintf("Couldn't parse query/body: %v", parseErr)
 		fmt.Printf(message + "\n")
-		telnyxError := createTelnyxError(typeInvalidRequestError, message)
+		telnyxError := createTelnyxError(errorCodeBadRequest, message)
 		writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
 		return
 	}
@@ -306,7 +518,7 @@ func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	// Note that requestData is actually manipulated in place, but we show it
 	// returned here to make it clear that this function will be manipulating
 	// it.
-	requestData, telnyxError := validateAndCoerceRequest(r, route, requestData)
+	requestData, telnyxError = validateAndCoerceRequest(r, route, requestData)
 	if telnyxError != nil {
 		writeResponse(w, r, start, http.StatusBadRequest, telnyxError)
 		return
@@ -317,15 +529,27 @@ func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("Expansions: %+v\n", rawExpansions)
 	}
 
-	generator := DataGenerator{s.spec.Components.Schemas, s.fixtures}
+	pageNumber, pageSize := pageParams(requestData)
+
+	generator := DataGenerator{s.spec.Components.Schemas, s.currentFixtures(),
+		newRequestRand(seed, r, body)}
+
+	var newIDRand *rand.Rand
+	if r.Method == http.MethodPost && route.createsResource() {
+		newIDRand = newCreateRand(seed, atomic.AddUint64(&s.created, 1))
+	}
 
 	responseData, err := generator.Generate(schema, metaObject, &GenerateParams{
 		Expansions:    expansions,
+		NewIDRand:     newIDRand,
 		PathParams:    pathParams,
 		RequestData:   requestData,
 		RequestMethod: r.Method,
 		RequestPath:   r.URL.Path,
 		WrapWithList:  wrapWithList,
+		ListSize:      s.listSize,
+		PageNumber:    pageNumber,
+		PageSize:      pageSize,
 	})
 
 	if err != nil {
@@ -334,6 +558,91 @@ func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 			createInternalServerError())
 		return
 	}
+
+	webhooks := route.expectedWebhooks
+
+	// Calls don't have an `id`, so rather than the resource store, they're
+	// tracked by a registry that also knows what state each one is in.
+	if s.calls != nil && isCallPath(string(route.path)) {
+		var status int
+		responseData, webhooks, status, telnyxError = s.reconcileWithCalls(r, route,
+			pathParams, requestData, responseData)
+		if telnyxError != nil {
+			writeResponse(w, r, start, status, telnyxError)
+			return
+		}
+	} else if s.portouts != nil && isPortoutPath(string(route.path)) {
+		// Port-outs can't be created through the API, and have comments that
+		// share their record type, so they're reconciled on their own.
+		var status int
+		responseData, status, telnyxError = s.reconcileWithPortouts(r, route,
+			pathParams, requestData, responseData)
+		if telnyxError != nil {
+			writeResponse(w, r, start, status, telnyxError)
+			return
+		}
+	} else if s.store != nil {
+		if s.numberOrders != nil {
+			s.fulfillNumberOrders()
+		}
+
+		if s.reservations != nil {
+			telnyxError = s.checkReservedNumbers(r, route, requestData, responseData)
+			if telnyxError != nil {
+				writeResponse(w, r, start, http.StatusUnprocessableEntity, telnyxError)
+				return
+			}
+		}
+
+		responseData, telnyxError = s.reconcileWithStore(r, route, pathParams,
+			requestData, responseData)
+		if telnyxError != nil {
+			writeResponse(w, r, start, http.StatusNotFound, telnyxError)
+			return
+		}
+
+		if s.reservations != nil {
+			var status int
+			responseData, status, telnyxError = s.reconcileWithReservations(r, route,
+				pathParams, responseData)
+			if telnyxError != nil {
+				writeResponse(w, r, start, status, telnyxError)
+				return
+			}
+		}
+
+		if s.messages != nil && isMessagePath(string(route.path)) {
+			responseData = s.reconcileWithMessages(r, route, requestData, responseData)
+		}
+
+		if s.numberOrders != nil {
+			responseData, webhooks = s.reconcileWithNumberOrders(r, route, requestData,
+				responseData, webhooks)
+		}
+	}
+
+	// Available numbers aren't resources that can be stored, so they're
+	// found anew by every search.
+	if string(route.path) == availablePhoneNumbersPath && r.Method == http.MethodGet {
+		responseData = searchAvailablePhoneNumbers(requestData, responseData,
+			s.listSize, newRequestRand(seed, r, body))
+	}
+
+	if s.validatesResponses() {
+		if telnyxError := validateResponse(route, responseCode, responseData); telnyxError != nil {
+			fmt.Printf("%s\n", telnyxError.Errors[0].Detail)
+
+			if s.responseValidation == responseValidationStrict {
+				writeResponse(w, r, start, http.StatusInternalServerError, telnyxError)
+				return
+			}
+		}
+	}
+
+	if s.webhooks != nil && len(webhooks) > 0 {
+		s.emitWebhooks(route, webhooks, pathParams, requestData, responseData)
+	}
+
 	if verbose {
 		responseDataJSON, err := json.MarshalIndent(responseData, "", "  ")
 		if err != nil {
@@ -341,7 +650,16 @@ func (s *StubServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		}
 		fmt.Printf("Response data: %s\n", responseDataJSON)
 	}
-	writeResponse(w, r, start, http.StatusOK, responseData)
+	writeResponse(w, r, start, responseStatus, responseData)
+}
+
+// currentFixtures returns the fixtures that responses are currently being
+// generated from.
+func (s *StubServer) currentFixtures() *spec.Fixtures {
+	s.fixturesMu.RLock()
+	defer s.fixturesMu.RUnlock()
+
+	return s.fixtures
 }
 
 func (s *StubServer) initializeRouter() error {
@@ -350,9 +668,17 @@ func (s *StubServer) initializeRouter() error {
 	var numValidators int
 
 	s.routes = make(map[spec.HTTPVerb][]stubServerRoute)
+	s.eventSchemas = collectEventSchemas(s.spec.Paths)
+	s.initialFixtures = s.fixtures
+	s.scenarios = NewScenarioRegistry()
 
 	componentsForValidation := spec.GetComponentsForValidation(&s.spec.Components)
 
+	var componentsForResponseValidation *spec.ComponentsForValidation
+	if s.validatesResponses() {
+		componentsForResponseValidation = spec.GetComponentsForResponseValidation(&s.spec.Components)
+	}
+
 	for path, verbs := range s.spec.Paths {
 		numPaths++
 
@@ -418,8 +744,32 @@ func (s *StubServer) initializeRouter() error {
 				}
 			}
 
+			// The primary ID is normally the last parameter, but one that
+			// only takes a fixed set of values, like the `{status}` of
+			// `/portouts/{id}/{status}`, says what to do with a resource
+			// rather than which one, so the parameter before it is.
+			primaryIDIndex := len(pathParamNames) - 1
+			if hasPrimaryID && len(pathParamNames) > 1 &&
+				strings.HasSuffix(string(path), "{"+pathParamNames[primaryIDIndex]+"}") &&
+				isEnumPathParam(operation, pathParamNames[primaryIDIndex], s.spec.Components.Parameters) {
+				primaryIDIndex--
+			}
+
+			var validators responseValidators
+			if s.validatesResponses() {
+				var err error
+				validators, err = buildResponseValidators(operation, &s.spec.Components,
+					componentsForResponseValidation)
+				if err != nil {
+					return err
+				}
+			}
+
 			route := stubServerRoute{
+				expectedWebhooks:                 expectedWebhooks(verb, path, operation),
 				hasPrimaryID:                     hasPrimaryID,
+				primaryIDIndex:                   primaryIDIndex,
+				path:                             path,
 				pattern:                          pathPattern,
 				operation:                        operation,
 				pathParamNames:                   pathParamNames,
@@ -427,6 +777,7 @@ func (s *StubServer) initializeRouter() error {
 				requestSchema:                    requestSchema,
 				requestValidator:                 requestValidator,
 				requestSchemaHasNestedProperties: hasNestedProperties,
+				responseValidators:               validators,
 			}
 
 			// net/http will always give us verbs in uppercase, so build our
@@ -455,6 +806,12 @@ func (s *StubServer) initializeRouter() error {
 	return nil
 }
 
+// validatesResponses returns whether generated responses are checked against
+// their schemas.
+func (s *StubServer) validatesResponses() bool {
+	return s.responseValidation != "" && s.responseValidation != responseValidationOff
+}
+
 func schemaHasNestedProperties(oaiSchema *spec.Schema) bool {
 	for _, v := range oaiSchema.Properties {
 		if len(v.Properties) > 0 {
@@ -512,16 +869,20 @@ func (s *StubServer) routeRequest(r *http.Request) (*stubServerRoute, *PathParam
 
 		var secondaryIDs []*PathParamsSecondaryID
 		if numSecondaryIDs > 0 {
-			secondaryIDs = make([]*PathParamsSecondaryID, numSecondaryIDs)
-			for i := 0; i < numSecondaryIDs; i++ {
-				secondaryIDs[i] = &PathParamsSecondaryID{
+			secondaryIDs = make([]*PathParamsSecondaryID, 0, numSecondaryIDs)
+			for i, name := range route.pathParamNames {
+				if route.hasPrimaryID && i == route.primaryIDIndex {
+					continue
+				}
+
+				secondaryIDs = append(secondaryIDs, &PathParamsSecondaryID{
 					// Note that the first position of `firstMatch` is the
 					// entire matching string. Capture groups start at position
 					// 1, so we add one to `i`.
 					ID: firstMatch[i+1],
 
-					Name: route.pathParamNames[i],
-				}
+					Name: name,
+				})
 			}
 		}
 
@@ -533,7 +894,7 @@ func (s *StubServer) routeRequest(r *http.Request) (*stubServerRoute, *PathParam
 		//
 		var primaryID *string
 		if route.hasPrimaryID {
-			primaryID = &firstMatch[len(firstMatch)-1]
+			primaryID = &firstMatch[route.primaryIDIndex+1]
 		}
 
 		// Return the route along with any IDs that matched in the path.
@@ -545,6 +906,109 @@ func (s *StubServer) routeRequest(r *http.Request) (*stubServerRoute, *PathParam
 	return nil, nil
 }
 
+// reconcileWithStore brings a generated response in line with the contents
+// of the server's resource store. It's only used in stateful mode.
+//
+// Newly created resources are persisted, and requests that target an
+// existing resource by ID (retrieve, update, and delete) operate on the
+// stored copy instead of the generated one. Created and updated resources
+// are stamped with the server's current time. A ResponseError is returned if
+// the targeted resource doesn't exist. Lists at the top level of the API
+// (i.e., those without parameters in their path) return every stored
+// resource of the listed type.
+//
+// Responses that don't carry a `record_type` are returned unchanged.
+func (s *StubServer) reconcileWithStore(r *http.Request, route *stubServerRoute,
+	pathParams *PathParamsMap, requestData map[string]interface{},
+	responseData interface{}) (interface{}, *ResponseError) {
+
+	responseMap, ok := responseData.(map[string]interface{})
+	if !ok {
+		return responseData, nil
+	}
+
+	switch data := responseMap["data"].(type) {
+	case []interface{}:
+		if r.Method != http.MethodGet || len(route.pathParamNames) > 0 || len(data) < 1 {
+			break
+		}
+
+		item, ok := data[0].(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		recordType, ok := item["record_type"].(string)
+		if !ok {
+			break
+		}
+
+		stored := s.store.List(recordType)
+		items := make([]interface{}, len(stored))
+		for i, object := range stored {
+			items[i] = object
+		}
+		items = applyFilters(items, parseFilters(requestData))
+
+		pageNumber, pageSize := pageParams(requestData)
+		responseMap["data"] = paginate(items, pageNumber, pageSize)
+		setPageMeta(responseMap["meta"], len(items), pageNumber, pageSize)
+
+	case map[string]interface{}:
+		recordType, ok := data["record_type"].(string)
+		if !ok {
+			break
+		}
+
+		if pathParams == nil || pathParams.PrimaryID == nil {
+			if r.Method == http.MethodPost && route.createsResource() {
+				stampTimestamps(data, s.now(), true)
+				s.store.Put(data)
+			}
+			break
+		}
+
+		// Actions (e.g. `/actions/extend`) and nested resources (e.g.
+		// `/phone_numbers/{id}/voice`) have a primary ID, but don't return
+		// the resource that it identifies.
+		if !strings.HasSuffix(string(route.path), "}") {
+			break
+		}
+
+		id := *pathParams.PrimaryID
+		notFound := createTelnyxError(errorCodeResourceNotFound,
+			fmt.Sprintf(resourceNotFound, recordType, id))
+
+		switch r.Method {
+		case http.MethodGet:
+			object, ok := s.store.Get(recordType, id)
+			if !ok {
+				return nil, notFound
+			}
+			responseMap["data"] = object
+
+		case http.MethodPatch, http.MethodPut:
+			object, ok := s.store.Get(recordType, id)
+			if !ok {
+				return nil, notFound
+			}
+			object = datareplacer.ReplaceData(requestData, object)
+			stampTimestamps(object, s.now(), false)
+			s.store.Put(object)
+			responseMap["data"] = object
+
+		case http.MethodDelete:
+			object, ok := s.store.Delete(recordType, id)
+			if !ok {
+				return nil, notFound
+			}
+			responseMap["data"] = object
+		}
+	}
+
+	return responseMap, nil
+}
+
 //
 // Private values
 //
@@ -560,9 +1024,9 @@ const (
 
 	invalidRoute = "Unrecognized request URL (%s: %s)."
 
-	internalServerError = "An internal error occurred."
+	resourceNotFound = "No such %s: '%s'."
 
-	typeInvalidRequestError = "invalid_request_error"
+	internalServerError = "An internal error occurred."
 )
 
 // Suffixes for which we will try to exact an object's ID from the path.
@@ -597,14 +1061,30 @@ var pathParameterPattern = regexp.MustCompile(`\{(\w+)\}`)
 // pattern to match an incoming path and a description of the method that would
 // be executed in the event of a match.
 type stubServerRoute struct {
+	expectedWebhooks                 []string
 	hasPrimaryID                     bool
 	operation                        *spec.Operation
+	path                             spec.Path
 	pathParamNames                   []string
 	pattern                          *regexp.Regexp
 	requestMediaType                 *string
 	requestSchema                    *spec.Schema
 	requestValidator                 *jsval.JSVal
 	requestSchemaHasNestedProperties bool
+
+	// primaryIDIndex is the index in pathParamNames of the parameter that's
+	// the primary ID, if the route has one.
+	primaryIDIndex int
+
+	// responseValidators is nil unless responses are being validated.
+	responseValidators responseValidators
+}
+
+// createsResource returns whether a `POST` to the route creates a resource.
+// Those are the routes that don't identify an existing resource in their path
+// and that aren't actions, like `POST /messaging_profiles`.
+func (r *stubServerRoute) createsResource() bool {
+	return !r.hasPrimaryID && !strings.Contains(string(r.path), "/actions/")
 }
 
 //
@@ -640,24 +1120,6 @@ func compilePath(path spec.Path) (*regexp.Regexp, []string) {
 	return regexp.MustCompile(pattern + `\z`), pathParamNames
 }
 
-// Helper to create an internal server error for API issues.
-func createInternalServerError() *ResponseError {
-	return createTelnyxError(typeInvalidRequestError, internalServerError)
-}
-
-// This creates a Telnyx error to return in case of API errors.
-func createTelnyxError(errorType string, errorMessage string) *ResponseError {
-	return &ResponseError{
-		ErrorInfo: struct {
-			Message string `json:"message"`
-			Type    string `json:"type"`
-		}{
-			Message: errorMessage,
-			Type:    errorType,
-		},
-	}
-}
-
 func extractExpansions(data map[string]interface{}) (*ExpansionLevel, []string) {
 	expand, ok := data["expand"]
 	if !ok {
@@ -703,6 +1165,23 @@ func getRequestBodySchema(operation *spec.Operation) (*string, *spec.Schema) {
 	return nil, nil
 }
 
+// isEnumPathParam returns whether an operation's path parameter only takes a
+// fixed set of values.
+func isEnumPathParam(operation *spec.Operation, name string,
+	parameters map[string]*spec.Parameter) bool {
+
+	for _, param := range operation.Parameters {
+		param, err := param.ResolveRef(parameters)
+		if err != nil {
+			continue
+		}
+		if param.In == spec.ParameterPath && param.Name == name && param.Schema != nil {
+			return len(param.Schema.Enum) > 0
+		}
+	}
+	return false
+}
+
 func isCurl(userAgent string) bool {
 	return strings.HasPrefix(userAgent, "curl/")
 }
@@ -737,6 +1216,22 @@ func parseExpansionLevel(raw []string) *ExpansionLevel {
 	return level
 }
 
+// stampTimestamps sets a resource's `updated_at` to now, and if it was just
+// created, its `created_at` too. Timestamps are only set on resources that
+// have them.
+func stampTimestamps(data map[string]interface{}, now time.Time, created bool) {
+	keys := []string{"updated_at"}
+	if created {
+		keys = append(keys, "created_at")
+	}
+
+	for _, key := range keys {
+		if _, ok := data[key]; ok {
+			data[key] = formatTimestamp(now)
+		}
+	}
+}
+
 // validateAndCoerceRequest validates an incoming request against an OpenAPI
 // schema and does parameter coercion.
 //
@@ -761,7 +1256,7 @@ func validateAndCoerceRequest(
 		if contentType == "" {
 			message := fmt.Sprintf(contentTypeEmpty, *route.requestMediaType)
 			fmt.Printf(message + "\n")
-			return nil, createTelnyxError(typeInvalidRequestError, message)
+			return nil, createTelnyxError(errorCodeBadRequest, message)
 		}
 
 		// Truncate content type parameters. For example, given:
@@ -774,7 +1269,7 @@ func validateAndCoerceRequest(
 		if contentType != *route.requestMediaType {
 			message := fmt.Sprintf(contentTypeMismatched, *route.requestMediaType, contentType)
 			fmt.Printf(message + "\n")
-			return nil, createTelnyxError(typeInvalidRequestError, message)
+			return nil, createTelnyxError(errorCodeBadRequest, message)
 		}
 	}
 
@@ -782,7 +1277,9 @@ func validateAndCoerceRequest(
 
 	var paramsForValidation map[string]interface{}
 
-	if (r.Method == http.MethodGet || r.Method == http.MethodDelete) && !route.requestSchemaHasNestedProperties {
+	inQuery := r.Method == http.MethodGet || r.Method == http.MethodDelete
+
+	if inQuery && !route.requestSchemaHasNestedProperties {
 		paramsForValidation = flattenParams(requestData)
 	} else {
 		paramsForValidation = requestData
@@ -792,13 +1289,13 @@ func validateAndCoerceRequest(
 		if err := coercer.CoerceParams(route.requestSchema, paramsForValidation); err != nil {
 			message := fmt.Sprintf("Request coercion error: %v", err)
 			fmt.Printf(message + "\n")
-			return nil, createTelnyxError(typeInvalidRequestError, message)
+			return nil, createTelnyxError(errorCodeBadRequest, message)
 		}
 
 		if err := route.requestValidator.Validate(paramsForValidation); err != nil {
-			message := fmt.Sprintf("Request validation error: %v", err)
-			fmt.Printf(message + "\n")
-			return nil, createTelnyxError(typeInvalidRequestError, message)
+			fmt.Printf("Request validation error: %v\n", err)
+			return nil, createValidationError(err, route.requestSchema,
+				paramsForValidation, inQuery)
 		}
 	}
 
@@ -817,11 +1314,10 @@ func flattenParams(params map[string]interface{}) map[string]interface{} {
 		for k, v := range params {
 			switch child := v.(type) {
 			case map[string]interface{}:
-				depth = depth + 1
-				nm := flatten(child, depth)
+				nm := flatten(child, depth+1)
 
 				for nk, nv := range nm {
-					if depth == 1 {
+					if depth == 0 {
 						newKey = k + "[" + nk
 					} else {
 						newKey = k + "][" + nk
@@ -43,14 +43,11 @@ func TestStubServer_MissingParam(t *testing.T) {
 	var data map[string]interface{}
 	err := json.Unmarshal(body, &data)
 	assert.NoError(t, err)
-	errorInfo, ok := data["error"].(map[string]interface{})
-	assert.True(t, ok)
-	errorType, ok := errorInfo["type"]
-	assert.Equal(t, errorType, "invalid_request_error")
-	assert.True(t, ok)
-	message, ok := errorInfo["message"]
-	assert.True(t, ok)
-	assert.Contains(t, message, "object property 'amount' is required")
+	errorInfo := getFirstError(t, data)
+	assert.Equal(t, errorCodeMissingParameter, errorInfo["code"])
+	assert.Equal(t, "Missing required parameter", errorInfo["title"])
+	assert.Contains(t, errorInfo["detail"], "object property 'amount' is required")
+	assert.Equal(t, map[string]interface{}{"pointer": "/amount"}, errorInfo["source"])
 }
 
 func TestStubServer_ExtraParam(t *testing.T) {
@@ -61,14 +58,10 @@ func TestStubServer_ExtraParam(t *testing.T) {
 	var data map[string]interface{}
 	err := json.Unmarshal(body, &data)
 	assert.NoError(t, err)
-	errorInfo, ok := data["error"].(map[string]interface{})
-	assert.True(t, ok)
-	errorType, ok := errorInfo["type"]
-	assert.Equal(t, errorType, "invalid_request_error")
-	assert.True(t, ok)
-	message, ok := errorInfo["message"]
-	assert.True(t, ok)
-	assert.Contains(t, message, "additional properties are not allowed")
+	errorInfo := getFirstError(t, data)
+	assert.Equal(t, errorCodeBadRequest, errorInfo["code"])
+	assert.Contains(t, errorInfo["detail"], "additional properties are not allowed")
+	assert.Equal(t, map[string]interface{}{"pointer": "/doesntexist"}, errorInfo["source"])
 }
 
 func TestStubServer_QueryParam(t *testing.T) {
@@ -89,14 +82,10 @@ func TestStubServer_QueryExtraParam(t *testing.T) {
 	var data map[string]interface{}
 	err := json.Unmarshal(body, &data)
 	assert.NoError(t, err)
-	errorInfo, ok := data["error"].(map[string]interface{})
-	assert.True(t, ok)
-	errorType, ok := errorInfo["type"]
-	assert.Equal(t, errorType, "invalid_request_error")
-	assert.True(t, ok)
-	message, ok := errorInfo["message"]
-	assert.True(t, ok)
-	assert.Contains(t, message, "additional properties are not allowed")
+	errorInfo := getFirstError(t, data)
+	assert.Equal(t, errorCodeBadRequest, errorInfo["code"])
+	assert.Contains(t, errorInfo["detail"], "additional properties are not allowed")
+	assert.Equal(t, map[string]interface{}{"parameter": "doesntexist"}, errorInfo["source"])
 }
 
 func TestStubServer_InvalidAuthorization(t *testing.T) {
@@ -106,12 +95,10 @@ func TestStubServer_InvalidAuthorization(t *testing.T) {
 	var data map[string]interface{}
 	err := json.Unmarshal(body, &data)
 	assert.NoError(t, err)
-	errorInfo, ok := data["error"].(map[string]interface{})
-	assert.True(t, ok)
-	errorType, ok := errorInfo["type"]
-	assert.Equal(t, errorType, "invalid_request_error")
-	assert.True(t, ok)
-	_, ok = errorInfo["message"]
+	errorInfo := getFirstError(t, data)
+	assert.Equal(t, errorCodeAuthenticationFailed, errorInfo["code"])
+	assert.Equal(t, "Authentication failed", errorInfo["title"])
+	_, ok := errorInfo["detail"]
 	assert.True(t, ok)
 }
 
@@ -166,12 +153,11 @@ func TestStubServer_ErrorsOnEmptyContentType(t *testing.T) {
 	var data map[string]interface{}
 	err := json.Unmarshal(body, &data)
 	assert.NoError(t, err)
-	errorInfo, ok := data["error"].(map[string]interface{})
-	assert.True(t, ok)
-	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	errorInfo := getFirstError(t, data)
+	assert.Equal(t, errorCodeBadRequest, errorInfo["code"])
 	assert.Equal(t,
 		fmt.Sprintf(contentTypeEmpty, "application/json"),
-		errorInfo["message"])
+		errorInfo["detail"])
 }
 
 func TestStubServer_AllowsEmptyContentTypeOnDelete(t *testing.T) {
@@ -194,14 +180,13 @@ func TestStubServer_ErrorsOnMismatchedContentType(t *testing.T) {
 	var data map[string]interface{}
 	err := json.Unmarshal(body, &data)
 	assert.NoError(t, err)
-	errorInfo, ok := data["error"].(map[string]interface{})
-	assert.True(t, ok)
-	assert.Equal(t, "invalid_request_error", errorInfo["type"])
+	errorInfo := getFirstError(t, data)
+	assert.Equal(t, errorCodeBadRequest, errorInfo["code"])
 	assert.Equal(t,
 		fmt.Sprintf(contentTypeMismatched,
 			"application/json",
 			"application/x-www-form-urlencoded"),
-		errorInfo["message"])
+		errorInfo["detail"])
 }
 
 func TestStubServer_ReflectsRequestId(t *testing.T) {
@@ -213,6 +198,55 @@ func TestStubServer_ReflectsRequestId(t *testing.T) {
 	assert.Equal(t, "my-key", resp.Header.Get("Request-Id"))
 }
 
+func TestStubServer_Stateful(t *testing.T) {
+	server := getStatefulStubServer(t)
+
+	resp, body := sendRequestToServer(t, server, "POST", "/v2/messaging_profiles",
+		`{"name": "stateful profile"}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var created map[string]interface{}
+	assert.NoError(t, json.Unmarshal(body, &created))
+	id := created["data"].(map[string]interface{})["id"].(string)
+
+	// Retrieves the created resource
+	resp, body = sendRequestToServer(t, server, "GET", "/v2/messaging_profiles/"+id,
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var data map[string]interface{}
+	assert.NoError(t, json.Unmarshal(body, &data))
+	assert.Equal(t, "stateful profile", data["data"].(map[string]interface{})["name"])
+
+	// Lists the created resource
+	resp, body = sendRequestToServer(t, server, "GET", "/v2/messaging_profiles",
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.Unmarshal(body, &data))
+	assert.Equal(t, 1, len(data["data"].([]interface{})))
+
+	// Updates the created resource
+	resp, _ = sendRequestToServer(t, server, "PATCH", "/v2/messaging_profiles/"+id,
+		`{"name": "renamed profile"}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, body = sendRequestToServer(t, server, "GET", "/v2/messaging_profiles/"+id,
+		"", getDefaultHeaders())
+	assert.NoError(t, json.Unmarshal(body, &data))
+	assert.Equal(t, "renamed profile", data["data"].(map[string]interface{})["name"])
+
+	// Deletes the created resource
+	resp, _ = sendRequestToServer(t, server, "DELETE", "/v2/messaging_profiles/"+id,
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = sendRequestToServer(t, server, "GET", "/v2/messaging_profiles/"+id,
+		"", getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Unknown IDs 404
+	resp, _ = sendRequestToServer(t, server, "PATCH", "/v2/messaging_profiles/unknown",
+		`{"name": "renamed profile"}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
 func TestStubServer_RoutesRequest(t *testing.T) {
 	server := getStubServer(t)
 
@@ -396,6 +430,24 @@ func TestCompilePath(t *testing.T) {
 	}
 }
 
+func TestFlattenParams(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{
+		"filter[status]": "active",
+		"filter[voice.connection_name][contains]": "office",
+		"limit":      "10",
+		"page[size]": "250",
+	}, flattenParams(map[string]interface{}{
+		"filter": map[string]interface{}{
+			"status": "active",
+			"voice.connection_name": map[string]interface{}{
+				"contains": "office",
+			},
+		},
+		"limit": "10",
+		"page":  map[string]interface{}{"size": "250"},
+	}))
+}
+
 func TestParseExpansionLevel(t *testing.T) {
 	emptyExpansionLevel := &ExpansionLevel{
 		expansions: make(map[string]*ExpansionLevel),
@@ -454,6 +506,17 @@ func encode64(s string) string {
 	return base64.StdEncoding.EncodeToString([]byte(s))
 }
 
+// getFirstError extracts the first error from a decoded Telnyx error
+// response, failing the test if there isn't one.
+func getFirstError(t *testing.T, data map[string]interface{}) map[string]interface{} {
+	errors, ok := data["errors"].([]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(errors))
+	errorInfo, ok := errors[0].(map[string]interface{})
+	assert.True(t, ok)
+	return errorInfo
+}
+
 func getDefaultHeaders() map[string]string {
 	headers := make(map[string]string)
 	headers["Authorization"] = "Bearer KEYSUPERSECRET"
@@ -468,10 +531,32 @@ func getStubServer(t *testing.T) *StubServer {
 	return server
 }
 
+// getStatefulStubServer gets a server in stateful mode that routes with the
+// real spec because the test spec's resources don't carry record types.
+func getStatefulStubServer(t *testing.T) *StubServer {
+	server := &StubServer{
+		spec:         &realSpec,
+		fixtures:     &realFixtures,
+		calls:        NewCallRegistry(),
+		messages:     NewMessageRegistry(),
+		numberOrders: NewNumberOrderQueue(),
+		reservations: NewReservationRegistry(),
+		portouts:     NewPortoutRegistry(),
+		store:        NewResourceStore(),
+	}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+	return server
+}
+
 func sendRequest(t *testing.T, method string, url string, params string,
 	headers map[string]string) (*http.Response, []byte) {
 
-	server := getStubServer(t)
+	return sendRequestToServer(t, getStubServer(t), method, url, params, headers)
+}
+
+func sendRequestToServer(t *testing.T, server *StubServer, method string,
+	url string, params string, headers map[string]string) (*http.Response, []byte) {
 
 	fullURL := fmt.Sprintf("https://telnyx.com%s", url)
 	req := httptest.NewRequest(method, fullURL, bytes.NewBufferString(params))
@@ -1,10 +1,5 @@
 package spec
 
-import (
-	"fmt"
-	"strings"
-)
-
 // BuildQuerySchema builds a JSON schema that will be used to validate query
 // parameters on the incoming request. Unlike request bodies, OpenAPI puts
 // query parameters in a different, non-JSON schema part of an operation.
@@ -21,15 +16,9 @@ func BuildQuerySchema(operation *Operation, parameters map[string]*Parameter) (*
 	}
 
 	for _, param := range operation.Parameters {
-		if param.Ref != "" {
-			refParts := strings.SplitAfterN(param.Ref, "#/components/parameters/", 2)
-			refName := refParts[1]
-
-			if v, ok := parameters[refName]; ok {
-				param = v
-			} else {
-				return nil, fmt.Errorf("invalid $ref '%s'", param.Ref)
-			}
+		param, err := param.ResolveRef(parameters)
+		if err != nil {
+			return nil, err
 		}
 
 		if param.In != ParameterQuery {
@@ -0,0 +1,465 @@
+package spec
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// Public types
+//
+
+// DocumentLoader loads the document at a location, which is either a file
+// path or a URL, and returns it decoded from JSON or YAML into generic maps
+// and slices.
+type DocumentLoader func(location string) (interface{}, error)
+
+//
+// Public functions
+//
+
+// ResolveRefs resolves every JSON reference (`$ref`) in a decoded OpenAPI
+// document in place so that it can be decoded into a Spec that only
+// references schemas by `#/components/schemas/<name>`, which is the only
+// kind of reference the rest of telnyx-mock understands.
+//
+// References may point to other files or URLs relative to the document's
+// location (e.g. `./schemas/Call.yaml#/Call`), which are loaded with load.
+// References to references are followed to their ultimate target.
+//
+// References to schemas stay references, because schemas may be recursive,
+// but point directly at their target afterwards. Schemas from other files or
+// from anywhere but `#/components/schemas/` are added to the document's
+// components under a name derived from their location. Every other kind of
+// reference (parameters, responses, request bodies, headers, examples, and
+// so on) is replaced by a copy of the object that it points to.
+func ResolveRefs(root map[string]interface{}, location string, load DocumentLoader) error {
+	resolver := &refResolver{
+		documents: map[string]interface{}{location: root},
+		imported:  make(map[string]string),
+		inlining:  make(map[string]bool),
+		load:      load,
+		root:      root,
+		rootLoc:   location,
+	}
+	_, err := resolver.walk(root, location, refKindAny)
+	return err
+}
+
+//
+// Private values
+//
+
+// The kinds of values that the resolver walks over. They determine how a
+// reference found in a value is resolved.
+const (
+	refKindAny       = iota // Any object other than a schema
+	refKindSchema           // A schema
+	refKindSchemaMap        // A map of names to schemas, like `properties`
+)
+
+// maxRefChain is the longest chain of references to references that's
+// followed before giving up on it as circular.
+const maxRefChain = 32
+
+// componentSchemasPointer is the JSON pointer to the schemas in an OpenAPI
+// document's components.
+const componentSchemasPointer = "/components/schemas/"
+
+// invalidComponentNameChars matches the characters that aren't allowed in
+// the name of an OpenAPI component.
+var invalidComponentNameChars = regexp.MustCompile(`[^a-zA-Z0-9\.\-_]`)
+
+// literalKeys are the keys of values that are arbitrary JSON, which are
+// never searched for references, by the kind of value they're found in.
+// `default` is only literal in schemas because it's also the key of the
+// default response of an operation.
+var literalKeys = map[int]map[string]bool{
+	refKindAny: {
+		"example": true,
+		"value":   true,
+	},
+	refKindSchema: {
+		"default": true,
+		"enum":    true,
+		"example": true,
+	},
+}
+
+//
+// Private types
+//
+
+// refResolver holds the state of a call to ResolveRefs.
+type refResolver struct {
+	// documents caches every document that's been loaded by its location.
+	documents map[string]interface{}
+
+	// imported maps the full locations of schemas that have been added to
+	// the root document's components to the names they were added under.
+	imported map[string]string
+
+	// inlining is the set of full locations of the objects currently being
+	// copied in place of a reference, used to detect circular references.
+	inlining map[string]bool
+
+	load    DocumentLoader
+	root    map[string]interface{}
+	rootLoc string
+}
+
+// walk resolves the references within a value of the given kind from the
+// document at location. It returns the value to replace it with, which is
+// different from the value that was passed in if it was a reference that
+// was inlined.
+func (r *refResolver) walk(value interface{}, location string, kind int) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"].(string); ok {
+			return r.resolve(ref, location, kind)
+		}
+
+		for _, key := range sortedKeys(v) {
+			subKind, ok := childRefKind(kind, key)
+			if !ok {
+				continue
+			}
+
+			resolved, err := r.walk(v[key], location, subKind)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
+		}
+
+	case []interface{}:
+		for i, element := range v {
+			resolved, err := r.walk(element, location, kind)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	}
+
+	return value, nil
+}
+
+// resolve resolves a single reference found in the document at location.
+func (r *refResolver) resolve(ref string, location string, kind int) (interface{}, error) {
+	target, targetLoc, pointer, err := r.follow(ref, location)
+	if err != nil {
+		return nil, err
+	}
+
+	fullLoc := targetLoc + "#" + pointer
+
+	if kind == refKindSchema {
+		name, err := r.importSchema(target, targetLoc, pointer)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"$ref": "#" + componentSchemasPointer + name}, nil
+	}
+
+	if r.inlining[fullLoc] {
+		return nil, fmt.Errorf("circular $ref to '%s'", fullLoc)
+	}
+	r.inlining[fullLoc] = true
+	defer delete(r.inlining, fullLoc)
+
+	return r.walk(deepCopy(target), targetLoc, kind)
+}
+
+// follow finds the target of a reference found in the document at location,
+// following references to references. It returns the target along with the
+// location of its document and its JSON pointer within it.
+func (r *refResolver) follow(ref string, location string) (interface{}, string, string, error) {
+	for i := 0; i < maxRefChain; i++ {
+		targetLoc, pointer, err := r.splitRef(ref, location)
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		document, err := r.document(targetLoc)
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		target, err := lookupPointer(document, pointer)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("invalid $ref '%s': %v", ref, err)
+		}
+
+		targetMap, ok := target.(map[string]interface{})
+		if !ok {
+			return target, targetLoc, pointer, nil
+		}
+
+		nextRef, ok := targetMap["$ref"].(string)
+		if !ok {
+			return target, targetLoc, pointer, nil
+		}
+
+		ref = nextRef
+		location = targetLoc
+	}
+
+	return nil, "", "", fmt.Errorf("circular $ref '%s'", ref)
+}
+
+// document returns the document at a location, loading it if it hasn't been
+// already.
+func (r *refResolver) document(location string) (interface{}, error) {
+	if document, ok := r.documents[location]; ok {
+		return document, nil
+	}
+
+	if r.load == nil {
+		return nil, fmt.Errorf("can't load '%s' without a document loader", location)
+	}
+
+	document, err := r.load(location)
+	if err != nil {
+		return nil, fmt.Errorf("error loading '%s': %v", location, err)
+	}
+
+	r.documents[location] = document
+	return document, nil
+}
+
+// importSchema returns the name under the root document's components that a
+// schema can be referenced by, adding the schema to them if it isn't there
+// already.
+func (r *refResolver) importSchema(target interface{}, targetLoc string, pointer string) (string, error) {
+	if targetLoc == r.rootLoc && strings.HasPrefix(pointer, componentSchemasPointer) {
+		name := strings.TrimPrefix(pointer, componentSchemasPointer)
+		if !strings.Contains(name, "/") {
+			return unescapePointerSegment(name), nil
+		}
+	}
+
+	fullLoc := targetLoc + "#" + pointer
+	if name, ok := r.imported[fullLoc]; ok {
+		return name, nil
+	}
+
+	schemas := r.componentSchemas()
+	name := importedSchemaName(targetLoc, pointer)
+	for i := 2; schemas[name] != nil; i++ {
+		name = importedSchemaName(targetLoc, pointer) + "_" + strconv.Itoa(i)
+	}
+
+	// Register the name before walking the schema so that recursive
+	// references within it resolve to the name too.
+	r.imported[fullLoc] = name
+	schemas[name] = map[string]interface{}{}
+
+	schema, err := r.walk(deepCopy(target), targetLoc, refKindSchema)
+	if err != nil {
+		return "", err
+	}
+	schemas[name] = schema
+
+	return name, nil
+}
+
+// componentSchemas returns the schemas in the root document's components,
+// creating them if they don't exist.
+func (r *refResolver) componentSchemas() map[string]interface{} {
+	components, ok := r.root["components"].(map[string]interface{})
+	if !ok {
+		components = make(map[string]interface{})
+		r.root["components"] = components
+	}
+
+	schemas, ok := components["schemas"].(map[string]interface{})
+	if !ok {
+		schemas = make(map[string]interface{})
+		components["schemas"] = schemas
+	}
+
+	return schemas
+}
+
+// splitRef splits a reference found in the document at location into the
+// location of the document it points to and a JSON pointer within it.
+func (r *refResolver) splitRef(ref string, location string) (string, string, error) {
+	refLoc := ref
+	fragment := ""
+	if i := strings.Index(ref, "#"); i >= 0 {
+		refLoc = ref[:i]
+		fragment = ref[i+1:]
+	}
+
+	pointer, err := url.PathUnescape(fragment)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid $ref '%s': %v", ref, err)
+	}
+
+	if refLoc == "" {
+		return location, pointer, nil
+	}
+
+	return joinLocation(location, refLoc), pointer, nil
+}
+
+//
+// Private functions
+//
+
+// childRefKind returns the kind of the value under a key of a value of the
+// given kind. The last return value is false if the value under the key
+// shouldn't be searched for references at all.
+func childRefKind(kind int, key string) (int, bool) {
+	if literalKeys[kind][key] {
+		return 0, false
+	}
+
+	switch kind {
+	case refKindSchemaMap:
+		return refKindSchema, true
+
+	case refKindSchema:
+		switch key {
+		case "properties":
+			return refKindSchemaMap, true
+		case "additionalProperties", "allOf", "anyOf", "items", "not", "oneOf":
+			return refKindSchema, true
+		}
+		return refKindAny, true
+	}
+
+	switch key {
+	case "schema", "allOf", "anyOf", "oneOf":
+		return refKindSchema, true
+	case "schemas":
+		return refKindSchemaMap, true
+	}
+	return refKindAny, true
+}
+
+// deepCopy copies a decoded document so that inlining it in more than one
+// place doesn't share any maps or slices.
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, subValue := range v {
+			m[key] = deepCopy(subValue)
+		}
+		return m
+
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, element := range v {
+			s[i] = deepCopy(element)
+		}
+		return s
+	}
+
+	return value
+}
+
+// importedSchemaName derives a component name for a schema from its
+// location: the last segment of its JSON pointer, or the name of its file
+// if it's a whole document.
+func importedSchemaName(location string, pointer string) string {
+	name := ""
+	if segments := strings.Split(pointer, "/"); len(segments) > 1 {
+		name = unescapePointerSegment(segments[len(segments)-1])
+	}
+
+	if name == "" {
+		name = path.Base(filepath.ToSlash(location))
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+
+	name = invalidComponentNameChars.ReplaceAllString(name, "_")
+	if name == "" {
+		name = "schema"
+	}
+	return name
+}
+
+// joinLocation resolves a document location relative to the location of the
+// document that references it.
+func joinLocation(base string, ref string) string {
+	if u, err := url.Parse(ref); err == nil && u.Scheme != "" {
+		return ref
+	}
+
+	if u, err := url.Parse(base); err == nil && u.Scheme != "" {
+		refURL, err := url.Parse(ref)
+		if err == nil {
+			return u.ResolveReference(refURL).String()
+		}
+	}
+
+	if filepath.IsAbs(ref) {
+		return filepath.Clean(ref)
+	}
+	return filepath.Join(filepath.Dir(base), ref)
+}
+
+// lookupPointer finds the value that a JSON pointer points to in a document.
+func lookupPointer(document interface{}, pointer string) (interface{}, error) {
+	if pointer == "" {
+		return document, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("JSON pointer '%s' should start with '/'", pointer)
+	}
+
+	value := document
+	for _, segment := range strings.Split(pointer[1:], "/") {
+		segment = unescapePointerSegment(segment)
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			subValue, ok := v[segment]
+			if !ok {
+				return nil, fmt.Errorf("'%s' not found", segment)
+			}
+			value = subValue
+
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("index '%s' out of range", segment)
+			}
+			value = v[i]
+
+		default:
+			return nil, fmt.Errorf("'%s' not found", segment)
+		}
+	}
+
+	return value, nil
+}
+
+// sortedKeys returns the keys of a map in order so that documents are
+// always walked in the same order, and names given to imported schemas are
+// stable.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// unescapePointerSegment reverses the escaping of `~` and `/` in a segment of
+// a JSON pointer.
+func unescapePointerSegment(segment string) string {
+	segment = strings.Replace(segment, "~1", "/", -1)
+	return strings.Replace(segment, "~0", "~", -1)
+}
@@ -0,0 +1,269 @@
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestResolveRefs(t *testing.T) {
+	documents := map[string]string{
+		"schemas/Call.json": `{
+			"Call": {
+				"type": "object",
+				"properties": {
+					"call_control_id": {"type": "string"},
+					"leg": {"$ref": "#/Leg"},
+					"transfer": {"$ref": "#/Call"}
+				}
+			},
+			"Leg": {"$ref": "#/LegObject"},
+			"LegObject": {
+				"type": "object",
+				"properties": {"default": {"type": "boolean"}}
+			}
+		}`,
+		"parameters.json": `{
+			"PageSize": {
+				"name": "page[size]",
+				"in": "query",
+				"schema": {"type": "integer"}
+			}
+		}`,
+	}
+
+	root := decodeDocument(t, `{
+		"paths": {
+			"/calls/{id}": {
+				"get": {
+					"parameters": [
+						{"$ref": "#/components/parameters/Id"},
+						{"$ref": "parameters.json#/PageSize"}
+					],
+					"responses": {
+						"200": {"$ref": "#/components/responses/CallResponse"},
+						"default": {"$ref": "#/components/responses/ErrorResponse"}
+					}
+				},
+				"post": {
+					"requestBody": {"$ref": "#/components/requestBodies/CallRequest"},
+					"responses": {
+						"200": {"$ref": "#/components/responses/CallResponse"}
+					}
+				}
+			}
+		},
+		"components": {
+			"parameters": {
+				"Id": {"$ref": "#/components/parameters/IdParameter"},
+				"IdParameter": {
+					"name": "id",
+					"in": "path",
+					"required": true,
+					"schema": {"type": "string"}
+				}
+			},
+			"requestBodies": {
+				"CallRequest": {
+					"content": {
+						"application/json": {
+							"schema": {"$ref": "./schemas/Call.json#/Call"}
+						}
+					}
+				}
+			},
+			"responses": {
+				"CallResponse": {
+					"description": "A call.",
+					"headers": {
+						"X-Request-Id": {"$ref": "#/components/headers/RequestId"}
+					},
+					"content": {
+						"application/json": {
+							"schema": {
+								"type": "object",
+								"properties": {
+									"data": {"$ref": "#/components/schemas/CallAlias"}
+								}
+							},
+							"examples": {
+								"call": {"$ref": "#/components/examples/Call"}
+							}
+						}
+					}
+				},
+				"ErrorResponse": {"description": "An error."}
+			},
+			"headers": {
+				"RequestId": {"schema": {"type": "string"}}
+			},
+			"examples": {
+				"Call": {"value": {"$ref": "not a reference"}}
+			},
+			"schemas": {
+				"CallAlias": {"$ref": "schemas/Call.json#/Call"}
+			}
+		}
+	}`)
+
+	loads := 0
+	err := ResolveRefs(root, "spec.json", func(location string) (interface{}, error) {
+		loads++
+		data, ok := documents[location]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", location)
+		}
+		return decodeDocument(t, data), nil
+	})
+	assert.NoError(t, err)
+
+	// Every document is loaded once, however many times it's referenced.
+	assert.Equal(t, 2, loads)
+
+	var spec Spec
+	data, err := json.Marshal(root)
+	assert.NoError(t, err)
+	err = json.Unmarshal(data, &spec)
+	assert.NoError(t, err)
+
+	get := spec.Paths["/calls/{id}"]["get"]
+	assert.Equal(t, 2, len(get.Parameters))
+	assert.Equal(t, "id", get.Parameters[0].Name)
+	assert.Equal(t, "page[size]", get.Parameters[1].Name)
+	assert.Equal(t, "An error.", get.Responses["default"].Description)
+
+	// Schemas from other files are added to the components and referenced
+	// from there, including references to themselves.
+	response := get.Responses["200"]
+	assert.Equal(t, "A call.", response.Description)
+	dataSchema := response.Content["application/json"].Schema.Properties["data"]
+	assert.Equal(t, "#/components/schemas/Call", dataSchema.Ref)
+
+	call, err := dataSchema.ResolveRef(spec.Components.Schemas)
+	assert.NoError(t, err)
+	assert.Equal(t, "#/components/schemas/Call", call.Properties["transfer"].Ref)
+	assert.Equal(t, "#/components/schemas/LegObject", call.Properties["leg"].Ref)
+
+	leg, err := call.Properties["leg"].ResolveRef(spec.Components.Schemas)
+	assert.NoError(t, err)
+	assert.Equal(t, TypeBoolean, leg.Properties["default"].Type)
+
+	// The alias that pointed to the schema is left as a reference to it.
+	alias, err := (&Schema{Ref: "#/components/schemas/CallAlias"}).ResolveRef(spec.Components.Schemas)
+	assert.NoError(t, err)
+	assert.Equal(t, call, alias)
+
+	post := spec.Paths["/calls/{id}"]["post"]
+	assert.Equal(t, "#/components/schemas/Call",
+		post.RequestBody.Content["application/json"].Schema.Ref)
+
+	// Values in examples are never treated as references.
+	examples := root["components"].(map[string]interface{})["responses"].(map[string]interface{})["CallResponse"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["examples"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"$ref": "not a reference"},
+		examples["call"].(map[string]interface{})["value"])
+}
+
+func TestResolveRefs_Errors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		document string
+	}{
+		{"MissingTarget", `{"paths": {"/calls": {"get": {
+			"parameters": [{"$ref": "#/components/parameters/Missing"}]
+		}}}}`},
+		{"CircularChain", `{
+			"paths": {"/calls": {"get": {
+				"parameters": [{"$ref": "#/components/parameters/A"}]
+			}}},
+			"components": {"parameters": {
+				"A": {"$ref": "#/components/parameters/B"},
+				"B": {"$ref": "#/components/parameters/A"}
+			}}
+		}`},
+		{"CircularInline", `{
+			"paths": {"/calls": {"get": {
+				"responses": {"200": {"$ref": "#/components/responses/A"}}
+			}}},
+			"components": {"responses": {
+				"A": {"headers": {"B": {"$ref": "#/components/responses/A"}}}
+			}}
+		}`},
+		{"MissingFile", `{"paths": {"/calls": {"get": {
+			"parameters": [{"$ref": "parameters.json#/Id"}]
+		}}}}`},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := ResolveRefs(decodeDocument(t, testCase.document), "spec.json",
+				func(location string) (interface{}, error) {
+					return nil, fmt.Errorf("%s not found", location)
+				})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSchemaResolveRef(t *testing.T) {
+	schemas := map[string]*Schema{
+		"a": {Ref: "#/components/schemas/b"},
+		"b": {Type: TypeString},
+		"c": {Ref: "#/components/schemas/c"},
+	}
+
+	schema, err := (&Schema{Ref: "#/components/schemas/a"}).ResolveRef(schemas)
+	assert.NoError(t, err)
+	assert.Equal(t, TypeString, schema.Type)
+
+	_, err = (&Schema{Ref: "#/components/schemas/c"}).ResolveRef(schemas)
+	assert.Error(t, err)
+
+	_, err = (&Schema{Ref: "#/components/schemas/missing"}).ResolveRef(schemas)
+	assert.Error(t, err)
+
+	// References anywhere but the schemas in components are errors rather
+	// than panics.
+	_, err = (&Schema{Ref: "./schemas/Call.yaml#/Call"}).ResolveRef(schemas)
+	assert.Error(t, err)
+}
+
+func TestJoinLocation(t *testing.T) {
+	assert.Equal(t, "openapi/schemas/Call.yaml",
+		joinLocation("openapi/spec.yaml", "./schemas/Call.yaml"))
+	assert.Equal(t, "/schemas/Call.yaml",
+		joinLocation("openapi/spec.yaml", "/schemas/Call.yaml"))
+	assert.Equal(t, "https://example.com/openapi/schemas/Call.yaml",
+		joinLocation("https://example.com/openapi/spec.yaml", "schemas/Call.yaml"))
+	assert.Equal(t, "https://example.com/Call.yaml",
+		joinLocation("openapi/spec.yaml", "https://example.com/Call.yaml"))
+}
+
+func TestLookupPointer(t *testing.T) {
+	document := decodeDocument(t, `{"a/b": {"c~d": [1, 2]}}`)
+
+	value, err := lookupPointer(document, "/a~1b/c~0d/1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, value)
+
+	value, err = lookupPointer(document, "")
+	assert.NoError(t, err)
+	assert.Equal(t, document, value)
+
+	_, err = lookupPointer(document, "/a~1b/c~0d/2")
+	assert.Error(t, err)
+
+	_, err = lookupPointer(document, "a")
+	assert.Error(t, err)
+}
+
+//
+// Private functions
+//
+
+func decodeDocument(t *testing.T, data string) map[string]interface{} {
+	var document map[string]interface{}
+	err := json.Unmarshal([]byte(data), &document)
+	assert.NoError(t, err)
+	return document
+}
@@ -32,6 +32,11 @@ const (
 // Public types
 //
 
+// Callback is a struct representing a callback in an OpenAPI specification.
+// It maps runtime expressions (e.g. `{$request.body#/webhook_url}`) to the
+// requests that the API may make to the URLs that they evaluate to.
+type Callback map[string]map[HTTPVerb]*Operation
+
 // Components is a struct for the components section of an OpenAPI
 // specification.
 type Components struct {
@@ -75,6 +80,8 @@ var supportedSchemaFields = []string{
 	"minLength",
 	"maximum",
 	"minimum",
+	"exclusiveMaximum",
+	"exclusiveMinimum",
 	"default",
 	"nullable",
 	"pattern",
@@ -136,6 +143,11 @@ type Schema struct {
 	WriteOnly  bool               `json:"writeOnly,omitempty"`
 	ReadOnly   bool               `json:"readOnly,omitempty"`
 
+	// ExclusiveMinimum and ExclusiveMaximum make Minimum and Maximum
+	// exclusive, like in OpenAPI 3.0.
+	ExclusiveMinimum bool `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum bool `json:"exclusiveMaximum,omitempty"`
+
 	// Ref is populated if this JSON Schema is actually a JSON reference, and
 	// it defines the location of the actual schema definition.
 	Ref string `json:"$ref,omitempty"`
@@ -217,17 +229,25 @@ func (s *Schema) FlattenAllOf() *Schema {
 // ResolveRef returns the ultimate *Schema.
 //
 // If Ref is nil, the same *Schema is returned that was passed in. Otherwise,
-// the *Schema will be resolved from the provided schemas map.
+// the *Schema will be resolved from the provided schemas map, following
+// references to references.
 func (s *Schema) ResolveRef(schemas map[string]*Schema) (*Schema, error) {
-	if s.Ref == "" {
-		return s, nil
-	}
+	schema := s
+	for i := 0; schema.Ref != ""; i++ {
+		if i >= maxRefChain {
+			return nil, fmt.Errorf("circular $ref '%s'", s.Ref)
+		}
 
-	schemaName := strings.SplitAfterN(s.Ref, "#/components/schemas/", 2)
-	schema, ok := schemas[schemaName[1]]
+		name, err := componentName(schema.Ref, "schemas")
+		if err != nil {
+			return nil, err
+		}
 
-	if !ok {
-		return nil, fmt.Errorf("Could not find response %s in #/components/responses/", schemaName)
+		var ok bool
+		schema, ok = schemas[name]
+		if !ok {
+			return nil, fmt.Errorf("Could not find schema %s in #/components/schemas/", name)
+		}
 	}
 
 	return schema, nil
@@ -242,8 +262,9 @@ type MediaType struct {
 // Operation is a struct representing a possible HTTP operation in an OpenAPI
 // specification.
 type Operation struct {
+	Callbacks   map[string]Callback     `json:"callbacks,omitempty"`
 	Description string                  `json:"description"`
-	OperationID string                  `json:"operation_id"`
+	OperationID string                  `json:"operationId"`
 	Parameters  []*Parameter            `json:"parameters"`
 	RequestBody *RequestBody            `json:"requestBody"`
 	Responses   map[StatusCode]Response `json:"responses"`
@@ -260,6 +281,33 @@ type Parameter struct {
 	Ref         string  `json:"$ref,omitempty"`
 }
 
+// ResolveRef returns the ultimate *Parameter.
+//
+// If Ref is nil, the same *Parameter is returned that was passed in.
+// Otherwise, the *Parameter will be resolved from the provided parameters
+// map, following references to references.
+func (p *Parameter) ResolveRef(parameters map[string]*Parameter) (*Parameter, error) {
+	param := p
+	for i := 0; param.Ref != ""; i++ {
+		if i >= maxRefChain {
+			return nil, fmt.Errorf("circular $ref '%s'", p.Ref)
+		}
+
+		name, err := componentName(param.Ref, "parameters")
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+		param, ok = parameters[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid $ref '%s'", p.Ref)
+		}
+	}
+
+	return param, nil
+}
+
 // Path is a type for an HTTP path in an OpenAPI specification.
 type Path string
 
@@ -283,17 +331,25 @@ type Response struct {
 // ResolveRef returns the ultimate *Response.
 //
 // If Ref is nil, the same *Response is returned that was passed in. Otherwise,
-// the *Response will be resolved from the provided responses map.
+// the *Response will be resolved from the provided responses map, following
+// references to references.
 func (r *Response) ResolveRef(responses map[string]*Response) (*Response, error) {
-	if r.Ref == "" {
-		return r, nil
-	}
+	responseObject := r
+	for i := 0; responseObject.Ref != ""; i++ {
+		if i >= maxRefChain {
+			return nil, fmt.Errorf("circular $ref '%s'", r.Ref)
+		}
 
-	responseName := strings.SplitAfterN(r.Ref, "#/components/responses/", 2)
-	responseObject, ok := responses[responseName[1]]
+		name, err := componentName(responseObject.Ref, "responses")
+		if err != nil {
+			return nil, err
+		}
 
-	if !ok {
-		return nil, fmt.Errorf("Could not find response %s in #/components/responses/", responseName)
+		var ok bool
+		responseObject, ok = responses[name]
+		if !ok {
+			return nil, fmt.Errorf("Could not find response %s in #/components/responses/", name)
+		}
 	}
 
 	return responseObject, nil
@@ -340,3 +396,24 @@ func (s *Spec) Flatten() {
 // StatusCode is a type for the response status code of an HTTP operation in an
 // OpenAPI specification.
 type StatusCode string
+
+//
+// Private functions
+//
+
+// componentName extracts the name of a component of the given kind (e.g.
+// `schemas`) from a local reference to it. References to anywhere else need
+// to be resolved with ResolveRefs when the spec is loaded.
+func componentName(ref string, kind string) (string, error) {
+	prefix := "#/components/" + kind + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		return "", fmt.Errorf("unsupported $ref '%s' (expected one to %s)", ref, prefix)
+	}
+
+	name := strings.TrimPrefix(ref, prefix)
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("unsupported $ref '%s' (expected one to %s)", ref, prefix)
+	}
+
+	return unescapePointerSegment(name), nil
+}
@@ -10,12 +10,22 @@ import (
 // specification that's been translated into equivalent JSON Schemas.
 type ComponentsForValidation struct {
 	root interface{}
+
+	// bounds is whether the bounds that schemas put on numbers and the
+	// lengths of strings are enforced.
+	bounds bool
 }
 
 // GetValidatorForOpenAPI3Schema gets a JSON Schema validator for a given
-// OpenAPI specification and set of JSON Schema components.
+// OpenAPI specification and set of JSON Schema components. The validator
+// enforces bounds only if the components do, as they do when they're for
+// validating responses.
 func GetValidatorForOpenAPI3Schema(oaiSchema *Schema, components *ComponentsForValidation) (*jsval.JSVal, error) {
-	jsonSchemaAsJSON := getJSONSchemaForOpenAPI3Schema(oaiSchema)
+	if components == nil {
+		components = &ComponentsForValidation{root: make(map[string]interface{})}
+	}
+
+	jsonSchemaAsJSON := getJSONSchemaForOpenAPI3Schema(oaiSchema, components.bounds)
 
 	jsonSchema := schema.New()
 	err := jsonSchema.Extract(jsonSchemaAsJSON)
@@ -23,10 +33,6 @@ func GetValidatorForOpenAPI3Schema(oaiSchema *Schema, components *ComponentsForV
 		return nil, err
 	}
 
-	if components == nil {
-		components = &ComponentsForValidation{root: make(map[string]interface{})}
-	}
-
 	validatorBuilder := builder.New()
 	validator, err := validatorBuilder.BuildWithCtx(jsonSchema, components.root)
 	if err != nil {
@@ -41,9 +47,22 @@ func GetValidatorForOpenAPI3Schema(oaiSchema *Schema, components *ComponentsForV
 //
 // See also the comment on getJSONSchemaForOpenAPI3Schema.
 func GetComponentsForValidation(components *Components) *ComponentsForValidation {
+	return getComponentsForValidation(components, false)
+}
+
+// GetComponentsForResponseValidation is like GetComponentsForValidation, but
+// for validating responses, which are also held to the bounds that schemas put
+// on numbers (`minimum` and `maximum`) and on the lengths of strings
+// (`minLength` and `maxLength`). Requests aren't, so that the mock stays as
+// lenient about them as it's always been.
+func GetComponentsForResponseValidation(components *Components) *ComponentsForValidation {
+	return getComponentsForValidation(components, true)
+}
+
+func getComponentsForValidation(components *Components, bounds bool) *ComponentsForValidation {
 	jsonSchemas := make(map[string]interface{})
 	for name, oaiSchema := range components.Schemas {
-		jsonSchemas[name] = getJSONSchemaForOpenAPI3Schema(oaiSchema)
+		jsonSchemas[name] = getJSONSchemaForOpenAPI3Schema(oaiSchema, bounds)
 	}
 	return &ComponentsForValidation{
 		root: map[string]interface{}{
@@ -51,6 +70,7 @@ func GetComponentsForValidation(components *Components) *ComponentsForValidation
 				"schemas": jsonSchemas,
 			},
 		},
+		bounds: bounds,
 	}
 }
 
@@ -61,8 +81,9 @@ func GetComponentsForValidation(components *Components) *ComponentsForValidation
 // like "string".
 //
 // This converter only handles the options that are supported by the spec.Schema
-// type, and it must be updated when new options are supported.
-func getJSONSchemaForOpenAPI3Schema(oai *Schema) map[string]interface{} {
+// type, and it must be updated when new options are supported. Bounds are only
+// included if asked for.
+func getJSONSchemaForOpenAPI3Schema(oai *Schema, bounds bool) map[string]interface{} {
 	jss := make(map[string]interface{})
 	if oai.AdditionalProperties != nil {
 		// We currently don't decode `AdditionalProperties` into a custom
@@ -73,7 +94,7 @@ func getJSONSchemaForOpenAPI3Schema(oai *Schema) map[string]interface{} {
 	if len(oai.AnyOf) != 0 {
 		var jssAnyOf = make([]interface{}, len(oai.AnyOf))
 		for index, oaiSubschema := range oai.AnyOf {
-			jssAnyOf[index] = getJSONSchemaForOpenAPI3Schema(oaiSubschema)
+			jssAnyOf[index] = getJSONSchemaForOpenAPI3Schema(oaiSubschema, bounds)
 		}
 		if oai.Nullable {
 			jssAnyOf = append(jssAnyOf, map[string]interface{}{"const": nil})
@@ -94,18 +115,41 @@ func getJSONSchemaForOpenAPI3Schema(oai *Schema) map[string]interface{} {
 		jss["format"] = oai.Format
 	}
 	if oai.Items != nil {
-		jss["items"] = getJSONSchemaForOpenAPI3Schema(oai.Items)
+		jss["items"] = getJSONSchemaForOpenAPI3Schema(oai.Items, bounds)
 	}
 	if oai.MaxLength != 0 {
 		jss["maxLength"] = oai.MaxLength
 	}
+	if bounds {
+		// Numbers are given to jsschema as float64s like they'd be if the
+		// schema had been decoded from JSON, because it silently ignores
+		// them otherwise.
+		if oai.MaxLength != 0 {
+			jss["maxLength"] = float64(oai.MaxLength)
+		}
+		// An exclusive bound of zero is still a bound.
+		if oai.Maximum != 0 || oai.ExclusiveMaximum {
+			jss["maximum"] = float64(oai.Maximum)
+			jss["exclusiveMaximum"] = oai.ExclusiveMaximum
+		}
+		if oai.MinLength != 0 {
+			jss["minLength"] = float64(oai.MinLength)
+		}
+		if oai.Minimum != 0 || oai.ExclusiveMinimum {
+			// Like the other bounds, a minimum of zero can't be told apart
+			// from no minimum at all, so it isn't enforced unless it's
+			// exclusive.
+			jss["minimum"] = float64(oai.Minimum)
+			jss["exclusiveMinimum"] = oai.ExclusiveMinimum
+		}
+	}
 	if oai.Pattern != "" {
 		jss["pattern"] = oai.Pattern
 	}
 	if len(oai.Properties) != 0 {
 		var jssProperties = make(map[string]interface{})
 		for key, oaiSubschema := range oai.Properties {
-			jssProperties[key] = getJSONSchemaForOpenAPI3Schema(oaiSubschema)
+			jssProperties[key] = getJSONSchemaForOpenAPI3Schema(oaiSubschema, bounds)
 		}
 		jss["properties"] = jssProperties
 	}
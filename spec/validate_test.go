@@ -47,3 +47,33 @@ func TestValidator_Reference(t *testing.T) {
 	assert.NoError(t, v.Validate("hello"))
 	assert.Error(t, v.Validate(123))
 }
+
+func TestValidator_Bounds(t *testing.T) {
+	schema := Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"count": {Type: "integer", Minimum: 1, Maximum: 10},
+			"name":  {Type: "string", MinLength: 2},
+			"ratio": {Type: "number", Minimum: 0, ExclusiveMinimum: true, Maximum: 1, ExclusiveMaximum: true},
+		},
+	}
+	v, err := GetValidatorForOpenAPI3Schema(&schema,
+		GetComponentsForResponseValidation(&Components{}))
+	assert.NoError(t, err)
+	assert.NoError(t, v.Validate(map[string]interface{}{"count": 1.0, "name": "ab"}))
+	assert.Error(t, v.Validate(map[string]interface{}{"count": 0.0}))
+	assert.Error(t, v.Validate(map[string]interface{}{"count": 11.0}))
+	assert.Error(t, v.Validate(map[string]interface{}{"name": "a"}))
+	assert.NoError(t, v.Validate(map[string]interface{}{"ratio": 0.5}))
+	assert.Error(t, v.Validate(map[string]interface{}{"ratio": 0.0}))
+	assert.Error(t, v.Validate(map[string]interface{}{"ratio": 1.0}))
+
+	// Bounds are only enforced when validating responses.
+	for _, components := range []*ComponentsForValidation{
+		nil, GetComponentsForValidation(&Components{}),
+	} {
+		v, err = GetValidatorForOpenAPI3Schema(&schema, components)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Validate(map[string]interface{}{"count": 0.0, "name": "a"}))
+	}
+}
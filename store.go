@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sync"
+)
+
+//
+// Public types
+//
+
+// ResourceStore is an in-memory store for resources that have been created
+// through the API while telnyx-mock is running in stateful mode.
+//
+// Resources are keyed by their `record_type` and `id` fields, which every
+// top-level Telnyx resource carries. Objects that lack either field can't be
+// stored and are passed through untouched.
+//
+// A ResourceStore is safe for concurrent use. Objects going in and out of the
+// store are deep copied so that callers can mutate them freely without
+// affecting what's stored.
+type ResourceStore struct {
+	mu sync.RWMutex
+
+	// order tracks the IDs of each record type in the order that they were
+	// first stored so that lists come back in a stable order.
+	order map[string][]string
+
+	resources map[string]map[string]map[string]interface{}
+}
+
+// NewResourceStore initializes a new, empty ResourceStore.
+func NewResourceStore() *ResourceStore {
+	return &ResourceStore{
+		order:     make(map[string][]string),
+		resources: make(map[string]map[string]map[string]interface{}),
+	}
+}
+
+// Delete removes the resource with the given record type and ID from the
+// store and returns a copy of it. The second return value is false if no such resource
+// existed.
+func (s *ResourceStore) Delete(recordType, id string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, ok := s.resources[recordType][id]
+	if !ok {
+		return nil, false
+	}
+
+	delete(s.resources[recordType], id)
+
+	ids := s.order[recordType]
+	for i, orderedID := range ids {
+		if orderedID == id {
+			s.order[recordType] = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+
+	return deepCopy(object).(map[string]interface{}), true
+}
+
+// Get retrieves a copy of the resource with the given record type and ID. The
+// second return value is false if no such resource exists.
+func (s *ResourceStore) Get(recordType, id string) (map[string]interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.resources[recordType][id]
+	if !ok {
+		return nil, false
+	}
+
+	return deepCopy(object).(map[string]interface{}), true
+}
+
+// List retrieves copies of all resources of the given record type in the
+// order that they were created.
+func (s *ResourceStore) List(recordType string) []map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects := make([]map[string]interface{}, 0, len(s.order[recordType]))
+	for _, id := range s.order[recordType] {
+		objects = append(objects,
+			deepCopy(s.resources[recordType][id]).(map[string]interface{}))
+	}
+
+	return objects
+}
+
+// Put stores a copy of the given object, replacing any existing resource that
+// has the same record type and ID. It returns false if the object couldn't be
+// stored because it's missing a `record_type` or `id`.
+func (s *ResourceStore) Put(object map[string]interface{}) bool {
+	recordType, id, ok := resourceKey(object)
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.resources[recordType]; !ok {
+		s.resources[recordType] = make(map[string]map[string]interface{})
+	}
+
+	if _, ok := s.resources[recordType][id]; !ok {
+		s.order[recordType] = append(s.order[recordType], id)
+	}
+
+	s.resources[recordType][id] = deepCopy(object).(map[string]interface{})
+	return true
+}
+
+// Reset removes every resource from the store.
+func (s *ResourceStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.order = make(map[string][]string)
+	s.resources = make(map[string]map[string]map[string]interface{})
+}
+
+//
+// Private functions
+//
+
+// deepCopy produces a copy of a decoded JSON-like value (i.e., one made up of
+// maps, slices, and scalars) that shares no mutable state with the original.
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, subValue := range v {
+			copied[key] = deepCopy(subValue)
+		}
+		return copied
+
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, subValue := range v {
+			copied[i] = deepCopy(subValue)
+		}
+		return copied
+	}
+
+	return value
+}
+
+// resourceKey extracts the record type and ID that an object would be stored
+// under. The last return value is false if either is missing.
+func resourceKey(object map[string]interface{}) (string, string, bool) {
+	recordType, ok := object["record_type"].(string)
+	if !ok || recordType == "" {
+		return "", "", false
+	}
+
+	id, ok := object["id"].(string)
+	if !ok || id == "" {
+		return "", "", false
+	}
+
+	return recordType, id, true
+}
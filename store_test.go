@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestResourceStore(t *testing.T) {
+	store := NewResourceStore()
+
+	// Objects without a record type or ID can't be stored
+	assert.False(t, store.Put(map[string]interface{}{"id": "123"}))
+	assert.False(t, store.Put(map[string]interface{}{"record_type": "foo"}))
+
+	assert.True(t, store.Put(map[string]interface{}{
+		"id": "123", "name": "first", "record_type": "foo",
+	}))
+	assert.True(t, store.Put(map[string]interface{}{
+		"id": "456", "name": "second", "record_type": "foo",
+	}))
+
+	object, ok := store.Get("foo", "123")
+	assert.True(t, ok)
+	assert.Equal(t, "first", object["name"])
+
+	// Objects handed out are copies
+	object["name"] = "mutated"
+	object, _ = store.Get("foo", "123")
+	assert.Equal(t, "first", object["name"])
+
+	_, ok = store.Get("bar", "123")
+	assert.False(t, ok)
+
+	// Replacing an object keeps its original position in the list
+	assert.True(t, store.Put(map[string]interface{}{
+		"id": "123", "name": "updated", "record_type": "foo",
+	}))
+	objects := store.List("foo")
+	assert.Equal(t, 2, len(objects))
+	assert.Equal(t, "updated", objects[0]["name"])
+	assert.Equal(t, "second", objects[1]["name"])
+
+	object, ok = store.Delete("foo", "123")
+	assert.True(t, ok)
+	assert.Equal(t, "updated", object["name"])
+
+	// Deleted objects handed out are copies too
+	assert.True(t, store.Put(map[string]interface{}{
+		"id": "789", "name": "third", "record_type": "foo",
+	}))
+	stored := store.resources["foo"]["789"]
+	object, _ = store.Delete("foo", "789")
+	object["name"] = "mutated"
+	assert.Equal(t, "third", stored["name"])
+
+	_, ok = store.Delete("foo", "123")
+	assert.False(t, ok)
+	assert.Equal(t, 1, len(store.List("foo")))
+
+	store.Reset()
+	assert.Equal(t, 0, len(store.List("foo")))
+}
+
+func TestDeepCopy(t *testing.T) {
+	original := map[string]interface{}{
+		"nested": map[string]interface{}{"key": "value"},
+		"slice":  []interface{}{map[string]interface{}{"key": "value"}},
+	}
+
+	copied := deepCopy(original).(map[string]interface{})
+	assert.Equal(t, original, copied)
+
+	copied["nested"].(map[string]interface{})["key"] = "other"
+	copied["slice"].([]interface{})[0].(map[string]interface{})["key"] = "other"
+	assert.Equal(t, "value", original["nested"].(map[string]interface{})["key"])
+	assert.Equal(t, "value",
+		original["slice"].([]interface{})[0].(map[string]interface{})["key"])
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/team-telnyx/telnyx-mock/generator/patterngen"
+	"github.com/team-telnyx/telnyx-mock/spec"
+)
+
+//
+// Private values
+//
+
+// syntheticTime is the time used for synthetic timestamps. It's fixed so that
+// generated responses are stable between requests, and with a PRNG, timestamps
+// are spread out over the year before it.
+var syntheticTime = time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// Synthetic values for strings of well-known shapes.
+const (
+	syntheticDomain      = "example.com"
+	syntheticPhoneNumber = "+13125550100"
+)
+
+// stringFormatGenerators produce synthetic strings for the formats used in the
+// spec. Several formats have more than one spelling in it.
+//
+// Each is given the name of the property that it's generating a value for, and
+// a PRNG to vary the value with, which is nil if it should be stable.
+var stringFormatGenerators = map[string]func(name string, rng *rand.Rand) string{
+	"+E.164":            syntheticE164,
+	"address":           func(string, *rand.Rand) string { return "311 W Superior St, Chicago, IL 60654" },
+	"date":              func(_ string, rng *rand.Rand) string { return syntheticTimestamp(rng).Format("2006-01-02") },
+	"date-time":         syntheticDateTime,
+	"datetime":          syntheticDateTime,
+	"decimal":           func(string, *rand.Rand) string { return "1.00" },
+	"e164":              syntheticE164,
+	"e164_phone_number": syntheticE164,
+	"email":             func(string, *rand.Rand) string { return "user@" + syntheticDomain },
+	"hostname":          func(string, *rand.Rand) string { return syntheticDomain },
+	"int":               syntheticNumericString,
+	"int64":             syntheticNumericString,
+	"ipv4":              func(string, *rand.Rand) string { return "192.0.2.1" },
+	"ipv6":              func(string, *rand.Rand) string { return "2001:db8::1" },
+	"iso4217":           func(string, *rand.Rand) string { return "USD" },
+	"mime-type":         func(string, *rand.Rand) string { return "application/json" },
+	"uri":               syntheticURL,
+	"url":               syntheticURL,
+	"uuid":              syntheticUUID,
+}
+
+//
+// Private functions
+//
+
+// generateSyntheticInteger generates an integer within a schema's bounds,
+// which it only reaches if they're inclusive. Like in validation, a bound of
+// zero that isn't exclusive is no bound at all.
+func generateSyntheticInteger(schema *spec.Schema) int {
+	value := 0
+	if schema.Minimum != 0 || schema.ExclusiveMinimum {
+		minimum := schema.Minimum
+		if schema.ExclusiveMinimum {
+			minimum++
+		}
+		if minimum > value {
+			value = minimum
+		}
+	}
+	if schema.Maximum != 0 || schema.ExclusiveMaximum {
+		maximum := schema.Maximum
+		if schema.ExclusiveMaximum {
+			maximum--
+		}
+		if maximum < value {
+			value = maximum
+		}
+	}
+	return value
+}
+
+// generateSyntheticString generates a string for a schema, named name if it's
+// the schema of an object's property, that's as realistic as the schema
+// allows: it matches the schema's pattern, or looks like a value of its
+// format. Failing either, the name of the property is used as a hint to what
+// the value should look like. Whichever it is, it's then padded or cut to the
+// schema's length bounds.
+//
+// IDs, phone numbers, and timestamps are varied with rng unless it's nil.
+func generateSyntheticString(schema *spec.Schema, name string, rng *rand.Rand) string {
+	var value string
+	generated := false
+
+	if schema.Pattern != "" {
+		var err error
+		value, err = patterngen.Generate(schema.Pattern)
+		generated = err == nil
+	}
+
+	if generate, ok := stringFormatGenerators[schema.Format]; ok && !generated {
+		value = generate(name, rng)
+		generated = true
+	}
+
+	if !generated {
+		switch {
+		case name == "id" || strings.HasSuffix(name, "_id"):
+			value = syntheticUUID(name, rng)
+		case name == "phone_number" || strings.HasSuffix(name, "_phone_number"):
+			value = syntheticE164(name, rng)
+		case strings.HasSuffix(name, "_at"):
+			value = syntheticDateTime(name, rng)
+		case name == "url" || strings.HasSuffix(name, "_url"):
+			value = syntheticURL(name, rng)
+		case name == "email" || strings.HasSuffix(name, "_email"):
+			value = stringFormatGenerators["email"](name, rng)
+		default:
+			value = ""
+		}
+	}
+
+	for len(value) < schema.MinLength {
+		value += "x"
+	}
+	if schema.MaxLength != 0 && len(value) > schema.MaxLength {
+		value = value[:schema.MaxLength]
+	}
+
+	return value
+}
+
+func syntheticDateTime(name string, rng *rand.Rand) string {
+	return syntheticTimestamp(rng).Format(time.RFC3339)
+}
+
+// syntheticE164 returns syntheticPhoneNumber, or with a PRNG, another of the
+// numbers in its range that are reserved for fiction.
+func syntheticE164(name string, rng *rand.Rand) string {
+	if rng == nil {
+		return syntheticPhoneNumber
+	}
+	return syntheticPhoneNumber[:len(syntheticPhoneNumber)-2] + fmt.Sprintf("%02d", rng.Intn(100))
+}
+
+// syntheticNumericString generates a string of digits, like the IDs of some
+// Telnyx resources.
+func syntheticNumericString(name string, rng *rand.Rand) string {
+	var value uint64
+	if rng != nil {
+		value = rng.Uint64()
+	} else {
+		sum := sha1.Sum([]byte(name))
+		for _, b := range sum[:8] {
+			value = value<<8 | uint64(b)
+		}
+	}
+
+	// Keep it to 16 digits so that it can't be mistaken for anything but an
+	// ID, and doesn't start with a zero.
+	return fmt.Sprintf("%d", 1000000000000000+value%9000000000000000)
+}
+
+// syntheticTimestamp returns syntheticTime, or with a PRNG, a time in the
+// year before it.
+func syntheticTimestamp(rng *rand.Rand) time.Time {
+	if rng == nil {
+		return syntheticTime
+	}
+	return syntheticTime.Add(-time.Duration(rng.Int63n(int64(365 * 24 * time.Hour))).Truncate(time.Second))
+}
+
+func syntheticURL(name string, rng *rand.Rand) string {
+	return "https://" + syntheticDomain + "/" + strings.Replace(name, "_", "-", -1)
+}
+
+// syntheticUUID generates a random UUID with a PRNG. Without one, it's
+// derived from name so that the same property always gets the same UUID, but
+// different properties get different ones.
+func syntheticUUID(name string, rng *rand.Rand) string {
+	if rng != nil {
+		return randomUUID(rng)
+	}
+
+	sum := sha1.Sum([]byte("telnyx-mock:" + name))
+
+	// Mark it as a name-based (version 5) UUID.
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+
+	return formatUUID(sum[:16])
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lestrrat/go-jsval"
+	"github.com/team-telnyx/telnyx-mock/spec"
+)
+
+//
+// Private values
+//
+
+const (
+	// responseValidationOff skips validating responses.
+	responseValidationOff responseValidationMode = "off"
+
+	// responseValidationLog logs responses that don't conform to their schema,
+	// but still sends them.
+	responseValidationLog responseValidationMode = "log"
+
+	// responseValidationStrict replaces responses that don't conform to their
+	// schema with a 500 describing what's wrong with them.
+	responseValidationStrict responseValidationMode = "strict"
+)
+
+const invalidGeneratedResponse = "Generated response doesn't conform to its schema at `%s`: %s " +
+	"(generated value: %s). This is a bug in telnyx-mock, or in its spec or fixtures."
+
+//
+// Private types
+//
+
+// responseValidationMode controls whether generated responses are checked
+// against the schemas that the spec declares for them, and what happens when
+// one doesn't conform.
+type responseValidationMode string
+
+// responseValidators holds the validators for each of an operation's success
+// responses, keyed by status code.
+type responseValidators map[spec.StatusCode]*jsval.JSVal
+
+//
+// Private functions
+//
+
+// buildResponseValidators builds a validator for each of an operation's
+// success responses that has a JSON schema.
+func buildResponseValidators(operation *spec.Operation, components *spec.Components,
+	componentsForValidation *spec.ComponentsForValidation) (responseValidators, error) {
+
+	validators := make(responseValidators)
+	for code, response := range operation.Responses {
+		if !strings.HasPrefix(string(code), "2") {
+			continue
+		}
+
+		responseObject, err := response.ResolveRef(components.Responses)
+		if err != nil {
+			return nil, err
+		}
+
+		content, ok := responseObject.Content["application/json"]
+		if !ok || content.Schema == nil {
+			continue
+		}
+
+		validator, err := spec.GetValidatorForOpenAPI3Schema(content.Schema, componentsForValidation)
+		if err != nil {
+			return nil, err
+		}
+		validators[code] = validator
+	}
+
+	return validators, nil
+}
+
+// parseResponseValidationMode parses the value of the -validate-responses
+// option. An empty value means responseValidationOff.
+func parseResponseValidationMode(mode string) (responseValidationMode, error) {
+	if mode == "" {
+		return responseValidationOff, nil
+	}
+
+	switch parsed := responseValidationMode(mode); parsed {
+	case responseValidationOff, responseValidationLog, responseValidationStrict:
+		return parsed, nil
+	}
+
+	return "", fmt.Errorf("Please specify one of `off`, `log`, or `strict` for -validate-responses")
+}
+
+// validateResponse checks generated response data against the schema of the
+// response that it was generated for. It returns an error describing the
+// first problem with the data, or nil if it conforms or there's no schema to
+// check it against.
+func validateResponse(route *stubServerRoute, code spec.StatusCode,
+	responseData interface{}) *ResponseError {
+
+	validator, ok := route.responseValidators[code]
+	if !ok {
+		return nil
+	}
+
+	// Validate the data as a client would see it, which also turns any
+	// structs into the maps that jsval expects.
+	encoded, err := json.Marshal(responseData)
+	if err != nil {
+		return createInternalServerError()
+	}
+	var data interface{}
+	err = json.Unmarshal(encoded, &data)
+	if err != nil {
+		return createInternalServerError()
+	}
+
+	err = validator.Validate(data)
+	if err == nil {
+		return nil
+	}
+
+	message := validationValidatorPattern.ReplaceAllString(err.Error(), "")
+	path, _ := validationErrorPath(message, nil, data)
+	pointer := jsonPointer(path)
+
+	value, err := json.Marshal(valueAtPath(data, path))
+	if err != nil {
+		value = []byte("?")
+	}
+
+	return createTelnyxError(errorCodeUnexpectedError,
+		fmt.Sprintf(invalidGeneratedResponse, pointer, message, value))
+}
+
+// valueAtPath returns the value found by following a path of object keys and
+// array indexes into data, or nil if there's nothing there.
+func valueAtPath(data interface{}, path []string) interface{} {
+	current := data
+	for _, segment := range path {
+		switch value := current.(type) {
+		case map[string]interface{}:
+			current = value[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(value) {
+				return nil
+			}
+			current = value[index]
+		default:
+			return nil
+		}
+	}
+	return current
+}
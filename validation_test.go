@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/team-telnyx/telnyx-mock/spec"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_ValidatesResponses(t *testing.T) {
+	// The example for `count` is below its minimum, so the generated response
+	// doesn't conform to the schema.
+	responseSpec := &spec.Spec{
+		Paths: map[spec.Path]map[spec.HTTPVerb]*spec.Operation{
+			"/counters/{id}": {
+				"get": {
+					Responses: map[spec.StatusCode]spec.Response{
+						"200": {
+							Content: map[string]spec.MediaType{
+								"application/json": {
+									Schema: &spec.Schema{
+										Type: spec.TypeObject,
+										Properties: map[string]*spec.Schema{
+											"data": {
+												Type: spec.TypeObject,
+												Properties: map[string]*spec.Schema{
+													"count": {
+														Type:    "integer",
+														Minimum: 1,
+														Example: json.RawMessage(`0`),
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	getCounter := func(mode responseValidationMode) (*http.Response, []byte) {
+		server := &StubServer{
+			spec:               responseSpec,
+			fixtures:           &testFixtures,
+			responseValidation: mode,
+		}
+		err := server.initializeRouter()
+		assert.NoError(t, err)
+
+		return sendRequestToServer(t, server, "GET", "/v2/counters/123", "",
+			getDefaultHeaders())
+	}
+
+	for _, mode := range []responseValidationMode{"", responseValidationOff, responseValidationLog} {
+		resp, _ := getCounter(mode)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp, body := getCounter(responseValidationStrict)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	var telnyxError ResponseError
+	err := json.Unmarshal(body, &telnyxError)
+	assert.NoError(t, err)
+	assert.Equal(t, errorCodeUnexpectedError, telnyxError.Errors[0].Code)
+	assert.Contains(t, telnyxError.Errors[0].Detail, "`/data/count`")
+	assert.Contains(t, telnyxError.Errors[0].Detail, "less than the minimum")
+	assert.Contains(t, telnyxError.Errors[0].Detail, "(generated value: 0)")
+}
+
+// TestStubServer_ResponsesConformToSchemas checks that a request to every
+// route of the real spec produces a response that conforms to its schema, so
+// that drift between the spec, the fixtures, and the generator is caught.
+func TestStubServer_ResponsesConformToSchemas(t *testing.T) {
+	// Routes whose responses are known not to conform, almost always because
+	// the spec gives a `null` example for a property that isn't nullable.
+	// Remove routes from here as the spec is fixed.
+	knownNonconforming := map[string]bool{
+		"DELETE /v2/billing_groups/{id}":         true,
+		"GET /v2/billing_groups":                 true,
+		"GET /v2/billing_groups/{id}":            true,
+		"GET /v2/messages/{id}":                  true,
+		"GET /v2/phone_numbers/messaging":        true,
+		"GET /v2/phone_numbers/{id}/messaging":   true,
+		"PATCH /v2/billing_groups/{id}":          true,
+		"PATCH /v2/phone_numbers/{id}/messaging": true,
+		"POST /v2/billing_groups":                true,
+		"POST /v2/messages":                      true,
+		"POST /v2/messages/long_code":            true,
+		"POST /v2/messages/number_pool":          true,
+		"POST /v2/messages/short_code":           true,
+	}
+
+	// Routes that no response can be generated for at all, because the
+	// spec's example for the response isn't the type that its schema says,
+	// or because the response isn't wrapped in `data`. Remove routes from
+	// here as the spec is fixed.
+	knownUngeneratable := map[string]bool{
+		"DELETE /v2/messaging_hosted_numbers/{id}":        true,
+		"DELETE /v2/sim_card_groups/{id}":                 true,
+		"DELETE /v2/wireless/detail_records_reports/{id}": true,
+		"GET /v2/sim_card_groups":                         true,
+		"GET /v2/sim_card_groups/{id}":                    true,
+		"GET /v2/wireless/detail_records_reports":         true,
+		"GET /v2/wireless/detail_records_reports/{id}":    true,
+		"PATCH /v2/sim_card_groups/{id}":                  true,
+		"POST /v2/sim_card_groups":                        true,
+		"POST /v2/wireless/detail_records_reports":        true,
+	}
+
+	server := &StubServer{
+		spec:               &realSpec,
+		fixtures:           &realFixtures,
+		responseValidation: responseValidationStrict,
+	}
+	err := server.initializeRouter()
+	assert.NoError(t, err)
+
+	// Requests aren't validated so that every route's response gets
+	// generated, and checked, without having to build a valid request for it.
+	for _, verbRoutes := range server.routes {
+		for i := range verbRoutes {
+			verbRoutes[i].requestSchema = nil
+			verbRoutes[i].requestValidator = nil
+		}
+	}
+
+	pathParamPattern := regexp.MustCompile(`\{[^}]+\}`)
+
+	// Parameters that only take a fixed set of values get one of them.
+	pathParamValues := map[string]string{
+		"{status}": "authorized",
+	}
+	pathParamValue := func(param string) string {
+		if value, ok := pathParamValues[param]; ok {
+			return value
+		}
+		return "123"
+	}
+
+	for _, route := range server.adminRoutes() {
+		name := route.Method + " " + route.Path
+		if knownNonconforming[name] || knownUngeneratable[name] {
+			continue
+		}
+
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("Couldn't generate a response: %v", r)
+				}
+			}()
+
+			var body string
+			if route.Method != http.MethodGet && route.Method != http.MethodDelete {
+				body = "{}"
+			}
+
+			resp, respBody := sendRequestToServer(t, server, route.Method,
+				pathParamPattern.ReplaceAllStringFunc(route.Path, pathParamValue), body, getDefaultHeaders())
+			assert.True(t, resp.StatusCode < 300, "Got status %v: %s", resp.StatusCode, respBody)
+		})
+	}
+}
+
+//
+// Tests for private functions
+//
+
+func TestParseResponseValidationMode(t *testing.T) {
+	mode, err := parseResponseValidationMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, responseValidationOff, mode)
+
+	mode, err = parseResponseValidationMode("strict")
+	assert.NoError(t, err)
+	assert.Equal(t, responseValidationStrict, mode)
+
+	_, err = parseResponseValidationMode("loud")
+	assert.Error(t, err)
+}
+
+func TestValueAtPath(t *testing.T) {
+	data := map[string]interface{}{
+		"data": []interface{}{
+			map[string]interface{}{"id": "123"},
+		},
+	}
+
+	assert.Equal(t, "123", valueAtPath(data, []string{"data", "0", "id"}))
+	assert.Nil(t, valueAtPath(data, []string{"data", "1", "id"}))
+	assert.Nil(t, valueAtPath(data, []string{"data", "0", "id", "x"}))
+	assert.Equal(t, data, valueAtPath(data, nil))
+}
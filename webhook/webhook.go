@@ -0,0 +1,191 @@
+// Package webhook signs and delivers webhooks in the same way that Telnyx's
+// API does so that receivers can verify them without special casing
+// telnyx-mock.
+package webhook
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//
+// Public values
+//
+
+// Headers that carry a webhook's signature and the time at which it was
+// signed.
+const (
+	HeaderSignature = "Telnyx-Signature-Ed25519"
+	HeaderTimestamp = "Telnyx-Timestamp"
+)
+
+// DefaultTimeout is the amount of time that a Sender will wait for a webhook
+// receiver to respond when it hasn't been given a client of its own.
+const DefaultTimeout = 10 * time.Second
+
+// DefaultTolerance is how far a webhook's timestamp may be from the current
+// time for Verify to accept it, which is the same tolerance that Telnyx's
+// client libraries use by default.
+const DefaultTolerance = 5 * time.Minute
+
+// Errors returned by Verify.
+var (
+	ErrInvalidSignature = errors.New("webhook signature doesn't match its payload and timestamp")
+	ErrInvalidTimestamp = errors.New("webhook timestamp isn't a Unix timestamp")
+	ErrTimestampExpired = errors.New("webhook timestamp is outside of the tolerance")
+)
+
+//
+// Public types
+//
+
+// Sender delivers signed webhooks over HTTP.
+type Sender struct {
+	// Client is the HTTP client used to deliver webhooks.
+	//
+	// If nil, a client with DefaultTimeout is used.
+	Client *http.Client
+
+	// Signer signs each delivered webhook.
+	Signer *Signer
+}
+
+// Send signs a webhook payload and POSTs it to the given URL, returning the
+// status code of the receiver's response.
+//
+// An error is only returned if no response could be obtained. It's up to the
+// caller to decide whether a non-2xx status is a failure.
+func (s *Sender) Send(url string, payload []byte) (int, error) {
+	return s.SendAt(url, payload, time.Now())
+}
+
+// SendAt is like Send, but signs the webhook as of the given time instead of
+// the current one. A time far enough in the past or future produces a webhook
+// that receivers should reject.
+func (s *Sender) SendAt(url string, payload []byte, timestamp time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "telnyx-webhooks")
+
+	req.Header.Set(HeaderSignature, s.Signer.Sign(payload, timestamp))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so that the underlying connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return resp.StatusCode, nil
+}
+
+// Signer produces Ed25519 webhook signatures.
+type Signer struct {
+	privateKey ed25519.PrivateKey
+}
+
+// GenerateSigner creates a Signer with a new, random keypair.
+func GenerateSigner() (*Signer, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating webhook keypair: %v", err)
+	}
+
+	return &Signer{privateKey: privateKey}, nil
+}
+
+// ParseSigner creates a Signer from a base64-encoded Ed25519 private key,
+// which can either be the full 64-byte key or the 32-byte seed that it's
+// derived from.
+func ParseSigner(privateKey string) (*Signer, error) {
+	key, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding webhook private key: %v", err)
+	}
+
+	switch len(key) {
+	case ed25519.PrivateKeySize:
+		return &Signer{privateKey: ed25519.PrivateKey(key)}, nil
+	case ed25519.SeedSize:
+		return &Signer{privateKey: ed25519.NewKeyFromSeed(key)}, nil
+	}
+
+	return nil, fmt.Errorf("webhook private key must be %d or %d bytes, but was %d",
+		ed25519.SeedSize, ed25519.PrivateKeySize, len(key))
+}
+
+// PublicKey returns the public half of the Signer's keypair, which is what
+// webhook receivers use to verify signatures.
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.privateKey.Public().(ed25519.PublicKey)
+}
+
+// Sign signs a webhook payload as of the given time, returning the signature
+// encoded as base64 as it's sent in HeaderSignature.
+func (s *Signer) Sign(payload []byte, timestamp time.Time) string {
+	signature := ed25519.Sign(s.privateKey, signedPayload(payload, timestamp))
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+//
+// Public functions
+//
+
+// Verify checks a webhook's signature and timestamp, given as the values of
+// HeaderSignature and HeaderTimestamp, in the same way that a receiver
+// should: the signature must have been made with the private half of
+// publicKey over the payload and timestamp, and the timestamp must be within
+// tolerance of now.
+func Verify(publicKey ed25519.PublicKey, payload []byte, signature string,
+	timestamp string, tolerance time.Duration, now time.Time) error {
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	signedAt := time.Unix(seconds, 0)
+
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(publicKey, signedPayload(payload, signedAt), decoded) {
+		return ErrInvalidSignature
+	}
+
+	if age := now.Sub(signedAt); age > tolerance || age < -tolerance {
+		return ErrTimestampExpired
+	}
+
+	return nil
+}
+
+//
+// Private functions
+//
+
+// signedPayload produces the message that's actually signed, which like
+// Telnyx's is the webhook's timestamp and payload joined with a pipe so that
+// a signature can't be replayed at a different time.
+func signedPayload(payload []byte, timestamp time.Time) []byte {
+	message := strconv.FormatInt(timestamp.Unix(), 10) + "|"
+	return append([]byte(message), payload...)
+}
@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestSender_Send(t *testing.T) {
+	signer, err := GenerateSigner()
+	assert.NoError(t, err)
+
+	var received *http.Request
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sender := &Sender{Signer: signer}
+	payload := []byte(`{"data":{"event_type":"call.answered"}}`)
+
+	status, err := sender.Send(server.URL, payload)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	assert.Equal(t, http.MethodPost, received.Method)
+	assert.Equal(t, "application/json", received.Header.Get("Content-Type"))
+	assert.Equal(t, payload, receivedBody)
+
+	timestamp, err := strconv.ParseInt(received.Header.Get(HeaderTimestamp), 10, 64)
+	assert.NoError(t, err)
+
+	signature, err := base64.StdEncoding.DecodeString(received.Header.Get(HeaderSignature))
+	assert.NoError(t, err)
+	assert.True(t, ed25519.Verify(signer.PublicKey(),
+		signedPayload(payload, time.Unix(timestamp, 0)), signature))
+}
+
+func TestSender_SendUnreachable(t *testing.T) {
+	signer, err := GenerateSigner()
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	sender := &Sender{Signer: signer}
+	_, err = sender.Send(url, []byte(`{}`))
+	assert.Error(t, err)
+}
+
+func TestSigner_Sign(t *testing.T) {
+	signer, err := GenerateSigner()
+	assert.NoError(t, err)
+
+	payload := []byte(`{}`)
+	timestamp := time.Unix(1500000000, 0)
+
+	signature, err := base64.StdEncoding.DecodeString(signer.Sign(payload, timestamp))
+	assert.NoError(t, err)
+	assert.True(t, ed25519.Verify(signer.PublicKey(),
+		[]byte("1500000000|{}"), signature))
+
+	// A signature is only good for the time that it was made.
+	assert.False(t, ed25519.Verify(signer.PublicKey(),
+		signedPayload(payload, timestamp.Add(time.Second)), signature))
+}
+
+func TestSender_SendAt(t *testing.T) {
+	signer, err := GenerateSigner()
+	assert.NoError(t, err)
+
+	var received *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+	}))
+	defer server.Close()
+
+	sender := &Sender{Signer: signer}
+	timestamp := time.Now().Add(-time.Hour)
+
+	_, err = sender.SendAt(server.URL, []byte(`{}`), timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.FormatInt(timestamp.Unix(), 10), received.Header.Get(HeaderTimestamp))
+
+	// The signature is good, but it's too old.
+	err = Verify(signer.PublicKey(), []byte(`{}`), received.Header.Get(HeaderSignature),
+		received.Header.Get(HeaderTimestamp), DefaultTolerance, time.Now())
+	assert.Equal(t, ErrTimestampExpired, err)
+}
+
+func TestParseSigner(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+
+	signer, err := ParseSigner(base64.StdEncoding.EncodeToString(seed))
+	assert.NoError(t, err)
+	assert.Equal(t, privateKey.Public(), signer.PublicKey())
+
+	signer, err = ParseSigner(base64.StdEncoding.EncodeToString(privateKey))
+	assert.NoError(t, err)
+	assert.Equal(t, privateKey.Public(), signer.PublicKey())
+
+	_, err = ParseSigner("not base64")
+	assert.Error(t, err)
+
+	_, err = ParseSigner(base64.StdEncoding.EncodeToString(seed[:16]))
+	assert.Error(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	signer, err := GenerateSigner()
+	assert.NoError(t, err)
+
+	payload := []byte(`{}`)
+	timestamp := time.Unix(1500000000, 0)
+	signature := signer.Sign(payload, timestamp)
+
+	verify := func(payload []byte, signature string, timestamp string, now time.Time) error {
+		return Verify(signer.PublicKey(), payload, signature, timestamp, DefaultTolerance, now)
+	}
+
+	assert.NoError(t, verify(payload, signature, "1500000000", timestamp))
+	assert.NoError(t, verify(payload, signature, "1500000000", timestamp.Add(DefaultTolerance)))
+	assert.NoError(t, verify(payload, signature, "1500000000", timestamp.Add(-DefaultTolerance)))
+
+	assert.Equal(t, ErrTimestampExpired,
+		verify(payload, signature, "1500000000", timestamp.Add(DefaultTolerance+time.Second)))
+	assert.Equal(t, ErrTimestampExpired,
+		verify(payload, signature, "1500000000", timestamp.Add(-DefaultTolerance-time.Second)))
+
+	assert.Equal(t, ErrInvalidSignature, verify([]byte(`{"a":1}`), signature, "1500000000", timestamp))
+	assert.Equal(t, ErrInvalidSignature, verify(payload, signature, "1500000001", timestamp))
+	assert.Equal(t, ErrInvalidSignature, verify(payload, "not base64", "1500000000", timestamp))
+	assert.Equal(t, ErrInvalidTimestamp, verify(payload, signature, "yesterday", timestamp))
+}
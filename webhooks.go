@@ -0,0 +1,565 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/team-telnyx/telnyx-mock/spec"
+	"github.com/team-telnyx/telnyx-mock/webhook"
+)
+
+//
+// Public types
+//
+
+// WebhookEmitter delivers the webhooks that Telnyx sends as a result of API
+// requests, like the `call.answered` event that follows answering a call with
+// Call Control.
+//
+// Like Telnyx, it retries failed deliveries with an exponential backoff, and
+// once it's given up on a webhook's URL, moves on to its failover URL.
+//
+// It's safe for concurrent use once its fields have been set.
+type WebhookEmitter struct {
+	mu sync.Mutex
+
+	// DefaultURL is the URL that webhooks are delivered to when the request
+	// that caused them didn't include a `webhook_url` of its own.
+	//
+	// If empty, webhooks are only delivered for requests that specify a URL.
+	DefaultURL string
+
+	// DefaultFailoverURL is the URL that webhooks are delivered to when
+	// delivery to their URL fails and the request that caused them didn't
+	// include a `webhook_failover_url` of its own.
+	//
+	// If empty, there's no failover for requests that don't specify one.
+	DefaultFailoverURL string
+
+	// Log records every attempt to deliver a webhook.
+	//
+	// nil if attempts aren't recorded.
+	Log *DeliveryLog
+
+	// Retries is how many more times delivery to a URL is attempted after
+	// one that fails, either because the receiver didn't respond or didn't
+	// respond with a 2xx status.
+	Retries int
+
+	// RetryBackoff is how long to wait before the first retry. The wait
+	// doubles with each retry after that.
+	RetryBackoff time.Duration
+
+	// Sender signs and delivers webhooks.
+	Sender *webhook.Sender
+
+	// afterFunc schedules retries. It's a field so that the passage of time
+	// can be controlled, and realAfterFunc is used if it's nil.
+	afterFunc func(d time.Duration, f func()) stopper
+
+	// generation counts resets so that deliveries started before the last
+	// one know to stop, and retries are the retries waiting to be made.
+	generation int
+	retries    map[stopper]bool
+}
+
+// Deliver delivers a single event to a URL, retrying and then falling back to
+// failoverURL (unless it's empty) if that fails. The first attempt is made
+// right away, and retries are scheduled rather than waited for.
+//
+// Unless done is nil, it's called with every attempt that was made once the
+// event has been delivered or every attempt has failed. The last attempt is
+// the one that was delivered if any were.
+func (e *WebhookEmitter) Deliver(url string, failoverURL string,
+	event map[string]interface{}, done func(attempts []*DeliveryAttempt)) {
+
+	urls := []string{url}
+	if failoverURL != "" && failoverURL != url {
+		urls = append(urls, failoverURL)
+	}
+
+	e.mu.Lock()
+	generation := e.generation
+	e.mu.Unlock()
+
+	delivery := &webhookDelivery{
+		done:       done,
+		emitter:    e,
+		event:      event,
+		generation: generation,
+		urls:       urls,
+	}
+	delivery.attempt(0, 0, e.RetryBackoff)
+}
+
+// Emit delivers a series of events in order, each once the one before it has
+// been delivered or given up on. It makes the first attempt to deliver at
+// least the first event before returning, so it's normally run in its own
+// Goroutine.
+//
+// Failed deliveries are logged, but otherwise ignored.
+func (e *WebhookEmitter) Emit(url string, failoverURL string, events []map[string]interface{}) {
+	if len(events) == 0 {
+		return
+	}
+
+	event := events[0]
+	e.Deliver(url, failoverURL, event, func(attempts []*DeliveryAttempt) {
+		last := attempts[len(attempts)-1]
+		if !last.Delivered {
+			reason := last.Error
+			if reason == "" {
+				reason = fmt.Sprintf("status %d", last.Status)
+			}
+			fmt.Printf("Couldn't deliver %v webhook after %d attempts: %s\n",
+				event["event_type"], len(attempts), reason)
+		} else if verbose {
+			fmt.Printf("Delivered %v webhook to %s (status %d)\n",
+				event["event_type"], last.URL, last.Status)
+		}
+
+		e.Emit(url, failoverURL, events[1:])
+	})
+}
+
+// attempt makes a single attempt to deliver an event to a URL. number counts
+// the attempts to deliver the event, and is sent along with it.
+func (e *WebhookEmitter) attempt(url string, number int, event map[string]interface{}) *DeliveryAttempt {
+	attempt := &DeliveryAttempt{Attempt: number, URL: url}
+	attempt.EventID, _ = event["id"].(string)
+	attempt.EventType, _ = event["event_type"].(string)
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"data": event,
+		"meta": map[string]interface{}{
+			"attempt":      number,
+			"delivered_to": url,
+		},
+	})
+	if err != nil {
+		attempt.Error = fmt.Sprintf("couldn't encode webhook: %v", err)
+		return attempt
+	}
+
+	// The signature is timestamped with real time even though the event's
+	// `occurred_at` follows the server's clock, because receivers check the
+	// timestamp against their own clock and would reject webhooks from a
+	// server whose clock has been moved.
+	attempt.Status, err = e.Sender.Send(url, payload)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	attempt.Delivered = attempt.Status >= 200 && attempt.Status < 300
+	return attempt
+}
+
+// Reset cancels every delivery in progress. Retries that haven't been made
+// are stopped, and attempts that are under way aren't recorded or followed by
+// any others. Deliveries that are cancelled are never done.
+func (e *WebhookEmitter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.generation++
+	for retry := range e.retries {
+		retry.Stop()
+	}
+	e.retries = nil
+}
+
+// after schedules a function to run once a duration has passed.
+func (e *WebhookEmitter) after(d time.Duration, f func()) stopper {
+	if e.afterFunc == nil {
+		return realAfterFunc(d, f)
+	}
+	return e.afterFunc(d, f)
+}
+
+// retry schedules f to run in a Goroutine of its own once d has passed,
+// unless the emitter has been reset since generation or is reset before then.
+func (e *WebhookEmitter) retry(generation int, d time.Duration, f func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if generation != e.generation {
+		return
+	}
+
+	var timer stopper
+	timer = e.after(d, func() {
+		e.mu.Lock()
+		waiting := e.retries[timer]
+		delete(e.retries, timer)
+		e.mu.Unlock()
+
+		if waiting {
+			go f()
+		}
+	})
+
+	if e.retries == nil {
+		e.retries = make(map[stopper]bool)
+	}
+	e.retries[timer] = true
+}
+
+//
+// Private values
+//
+
+// Delivery of a webhook to a URL is attempted this many more times after one
+// that fails unless configured otherwise, with this long to wait before the
+// first retry.
+const (
+	defaultWebhookRetries      = 3
+	defaultWebhookRetryBackoff = time.Second
+)
+
+// expectedWebhooksHeading introduces the list of webhooks that an operation
+// will trigger in its description.
+const expectedWebhooksHeading = "**Expected Webhooks:**"
+
+// expectedWebhookPattern matches a single item in the list of webhooks that
+// follows expectedWebhooksHeading, e.g. "- `call.answered`".
+var expectedWebhookPattern = regexp.MustCompile("\\A\\s*[-*] `([^`]+)`")
+
+// resourceWebhooks are the webhooks that operations trigger without listing
+// them in their descriptions, keyed by method and path. Their events go to
+// the resource's `webhook_url`, like Telnyx's.
+var resourceWebhooks = map[string][]string{
+	"PATCH /portouts/{id}/{status}": {"portout.status_changed"},
+	"POST /number_orders":           {"number_order.complete"},
+	"POST /portouts/{id}/comments":  {"portout.new_comment"},
+}
+
+//
+// Private types
+//
+
+// webhookDelivery is the state of the delivery of a single event, which may
+// take several attempts.
+type webhookDelivery struct {
+	attempts []*DeliveryAttempt
+	done     func(attempts []*DeliveryAttempt)
+	emitter  *WebhookEmitter
+	event    map[string]interface{}
+	urls     []string
+
+	// generation is the emitter's generation when the delivery started.
+	generation int
+}
+
+// attempt makes an attempt to deliver the event to one of its URLs. retry
+// counts the attempts that have already been made to that URL, and backoff is
+// how long to wait before retrying it if this attempt fails.
+//
+// Retries are made in a Goroutine of their own so that the clock they're
+// scheduled on doesn't wait for the receiver. Once the URL's retries have run
+// out, the next URL is tried right away, and once every URL has been tried,
+// the delivery is done. If the emitter is reset, the delivery stops.
+func (d *webhookDelivery) attempt(urlIndex int, retry int, backoff time.Duration) {
+	e := d.emitter
+
+	attempt := e.attempt(d.urls[urlIndex], len(d.attempts)+1, d.event)
+	attempt.Failover = urlIndex > 0
+	d.attempts = append(d.attempts, attempt)
+
+	// The attempt is recorded while the emitter is locked so that one that
+	// was under way when the emitter was reset can't make it into the log.
+	e.mu.Lock()
+	cancelled := d.generation != e.generation
+	if !cancelled && e.Log != nil {
+		e.Log.Record(attempt)
+	}
+	e.mu.Unlock()
+
+	if cancelled {
+		return
+	}
+
+	switch {
+	case attempt.Delivered:
+		d.finish()
+
+	case retry < e.Retries:
+		e.retry(d.generation, backoff, func() { d.attempt(urlIndex, retry+1, backoff*2) })
+
+	case urlIndex+1 < len(d.urls):
+		d.attempt(urlIndex+1, 0, e.RetryBackoff)
+
+	default:
+		d.finish()
+	}
+}
+
+// finish reports the delivery's attempts to whoever's waiting for them.
+func (d *webhookDelivery) finish() {
+	if d.done != nil {
+		d.done(d.attempts)
+	}
+}
+
+//
+// Private functions
+//
+
+// emitWebhooks generates webhooks of the given event types for a request and
+// sends them off for asynchronous delivery.
+//
+// Webhooks go to the request's `webhook_url` if it has one, and otherwise to
+// the emitter's default URL.
+func (s *StubServer) emitWebhooks(route *stubServerRoute, eventTypes []string,
+	pathParams *PathParamsMap, requestData map[string]interface{},
+	responseData interface{}) {
+
+	url, failoverURL := s.webhookURLs(requestData)
+	if url == "" {
+		if verbose {
+			fmt.Printf("No webhook URL; not sending %v\n", eventTypes)
+		}
+		return
+	}
+
+	values := eventPayloadValues(route, pathParams, requestData, responseData)
+
+	var events []map[string]interface{}
+	for _, eventType := range eventTypes {
+		event, err := s.generateEvent(eventType, values)
+		if err != nil {
+			fmt.Printf("Couldn't generate %s webhook: %v\n", eventType, err)
+			return
+		}
+
+		// Events that the spec doesn't describe, like those in
+		// resourceWebhooks, carry the resource that the request returned.
+		if _, ok := s.eventSchemas[eventType]; !ok {
+			if responseMap, ok := responseData.(map[string]interface{}); ok {
+				if resource, ok := responseMap["data"].(map[string]interface{}); ok {
+					event["payload"] = deepCopy(resource)
+				}
+			}
+		}
+
+		events = append(events, event)
+	}
+
+	go s.webhooks.Emit(url, failoverURL, events)
+}
+
+// webhookURLs returns the URL and failover URL that the webhooks triggered by
+// a request go to, which are the request's `webhook_url` and
+// `webhook_failover_url`, or the emitter's defaults if it doesn't have them.
+func (s *StubServer) webhookURLs(requestData map[string]interface{}) (string, string) {
+	url := s.webhooks.DefaultURL
+	if webhookURL, ok := requestData["webhook_url"].(string); ok && webhookURL != "" {
+		url = webhookURL
+	}
+
+	failoverURL := s.webhooks.DefaultFailoverURL
+	if webhookURL, ok := requestData["webhook_failover_url"].(string); ok && webhookURL != "" {
+		failoverURL = webhookURL
+	}
+
+	return url, failoverURL
+}
+
+// generateEvent generates an event of the given type from the schema of the
+// webhook that carries it. Any property in the event's payload that has a
+// counterpart in values takes that value instead.
+func (s *StubServer) generateEvent(eventType string,
+	values map[string]interface{}) (map[string]interface{}, error) {
+
+	event := make(map[string]interface{})
+
+	if schema, ok := s.eventSchemas[eventType]; ok {
+		generator := DataGenerator{s.spec.Components.Schemas, s.currentFixtures(), nil}
+
+		data, err := generator.generateInternal(&GenerateParams{
+			schema:  schema,
+			context: fmt.Sprintf("Generating %s webhook:\n", eventType),
+			example: generator.prepareSchemaExample(schema),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if dataMap, ok := data.(map[string]interface{}); ok {
+			event = dataMap
+		}
+	}
+
+	payload, ok := event["payload"].(map[string]interface{})
+	if !ok {
+		payload = make(map[string]interface{})
+	}
+
+	for key := range payload {
+		if value, ok := values[key]; ok {
+			payload[key] = value
+		}
+	}
+
+	// Telnyx echoes a command's ID back in the webhooks that it causes so
+	// that they can be told apart from those of a retried command.
+	if commandID, ok := values["command_id"]; ok {
+		payload["command_id"] = commandID
+	}
+
+	event["event_type"] = eventType
+	event["id"] = newUUID()
+	// The event occurs at the time on the server's clock, while the signature
+	// of the webhook that carries it uses real time (see attempt).
+	event["occurred_at"] = formatTimestamp(s.now())
+	event["payload"] = payload
+	event["record_type"] = "event"
+
+	return event, nil
+}
+
+// collectEventSchemas finds the schema of every event that the spec
+// describes as a callback, keyed by event type (e.g. `call.answered`).
+func collectEventSchemas(paths map[spec.Path]map[spec.HTTPVerb]*spec.Operation) map[string]*spec.Schema {
+	eventSchemas := make(map[string]*spec.Schema)
+
+	for _, verbs := range paths {
+		for _, operation := range verbs {
+			for _, callback := range operation.Callbacks {
+				for _, callbackVerbs := range callback {
+					for _, callbackOperation := range callbackVerbs {
+						if callbackOperation.RequestBody == nil {
+							continue
+						}
+
+						mediaType, ok := callbackOperation.RequestBody.Content["application/json"]
+						if !ok || mediaType.Schema == nil {
+							continue
+						}
+
+						for _, eventType := range schemaEventTypes(mediaType.Schema) {
+							if _, ok := eventSchemas[eventType]; !ok {
+								eventSchemas[eventType] = mediaType.Schema
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return eventSchemas
+}
+
+// eventPayloadValues collects the values that are reflected into the
+// payloads of the webhooks triggered by a request. Values from the request
+// take precedence over those in the path, which take precedence over those
+// in the response.
+func eventPayloadValues(route *stubServerRoute, pathParams *PathParamsMap,
+	requestData map[string]interface{}, responseData interface{}) map[string]interface{} {
+
+	values := make(map[string]interface{})
+
+	if responseMap, ok := responseData.(map[string]interface{}); ok {
+		if data, ok := responseMap["data"].(map[string]interface{}); ok {
+			for key, value := range data {
+				values[key] = value
+			}
+		}
+	}
+
+	if pathParams != nil {
+		for _, secondaryID := range pathParams.SecondaryIDs {
+			values[secondaryID.Name] = secondaryID.ID
+		}
+
+		if pathParams.PrimaryID != nil && len(route.pathParamNames) > 0 {
+			name := route.pathParamNames[route.primaryIDIndex]
+			values[name] = *pathParams.PrimaryID
+		}
+	}
+
+	for key, value := range requestData {
+		values[key] = value
+	}
+
+	return values
+}
+
+// expectedWebhooks returns the event types that an operation triggers, which
+// are those listed in its description along with any in resourceWebhooks.
+func expectedWebhooks(verb spec.HTTPVerb, path spec.Path, operation *spec.Operation) []string {
+	eventTypes := parseExpectedWebhooks(operation.Description)
+	return append(eventTypes, resourceWebhooks[strings.ToUpper(string(verb))+" "+string(path)]...)
+}
+
+// newUUID generates a random (version 4) UUID.
+func newUUID() string {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		panic(err)
+	}
+
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return formatUUID(uuid[:])
+}
+
+// parseExpectedWebhooks extracts the event types that an operation triggers
+// from the "Expected Webhooks" list in its description.
+func parseExpectedWebhooks(description string) []string {
+	index := strings.Index(description, expectedWebhooksHeading)
+	if index == -1 {
+		return nil
+	}
+
+	var eventTypes []string
+	lines := strings.Split(description[index+len(expectedWebhooksHeading):], "\n")
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		matches := expectedWebhookPattern.FindStringSubmatch(line)
+		if matches == nil {
+			break
+		}
+		eventTypes = append(eventTypes, matches[1])
+	}
+
+	return eventTypes
+}
+
+// schemaEventTypes returns the types of event that a webhook schema
+// describes, which is more than one for schemas shared by related events
+// (e.g. `message.sent` and `message.finalized`). It returns nil if the schema
+// doesn't look like an event.
+func schemaEventTypes(schema *spec.Schema) []string {
+	property, ok := schema.Properties["event_type"]
+	if !ok {
+		return nil
+	}
+
+	var eventTypes []string
+	for _, value := range property.Enum {
+		if eventType, ok := value.(string); ok {
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+	if len(eventTypes) > 0 {
+		return eventTypes
+	}
+
+	var eventType string
+	if err := json.Unmarshal(property.Example, &eventType); err == nil && eventType != "" {
+		return []string{eventType}
+	}
+
+	return nil
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"github.com/team-telnyx/telnyx-mock/webhook"
+)
+
+//
+// Tests
+//
+
+func TestStubServer_EmitsWebhooks(t *testing.T) {
+	received := make(chan *http.Request, 10)
+	bodies := make(chan map[string]interface{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	server := getWebhookStubServer(t, receiver.URL)
+
+	resp, _ := sendRequestToServer(t, server, "POST",
+		"/v2/calls/call_123/actions/answer",
+		`{"client_state": "aGk=", "command_id": "cmd_123"}`,
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case r := <-received:
+		assert.NotEmpty(t, r.Header.Get(webhook.HeaderSignature))
+		assert.NotEmpty(t, r.Header.Get(webhook.HeaderTimestamp))
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for webhook")
+	}
+
+	body := <-bodies
+	event := body["data"].(map[string]interface{})
+	assert.Equal(t, "call.answered", event["event_type"])
+	assert.Equal(t, "event", event["record_type"])
+
+	payload := event["payload"].(map[string]interface{})
+	assert.Equal(t, "call_123", payload["call_control_id"])
+	assert.Equal(t, "aGk=", payload["client_state"])
+	assert.Equal(t, "cmd_123", payload["command_id"])
+}
+
+func TestStubServer_WebhookTimestamps(t *testing.T) {
+	received := make(chan *http.Request, 10)
+	bodies := make(chan map[string]interface{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	server := getWebhookStubServer(t, receiver.URL)
+	clock := NewClock()
+	clock.Freeze()
+	clock.Set(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	server.setClock(clock)
+
+	resp, _ := sendRequestToServer(t, server, "POST",
+		"/v2/calls/call_123/actions/answer", `{}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Events occur at the time on the server's clock, but signatures are
+	// timestamped with real time so that receivers accept them.
+	select {
+	case r := <-received:
+		timestamp, err := strconv.ParseInt(r.Header.Get(webhook.HeaderTimestamp), 10, 64)
+		assert.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), time.Unix(timestamp, 0), time.Minute)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for webhook")
+	}
+
+	event := (<-bodies)["data"].(map[string]interface{})
+	assert.Equal(t, "2020-01-01T12:00:00Z", event["occurred_at"])
+}
+
+func TestStubServer_EmitsWebhooksToRequestURL(t *testing.T) {
+	received := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Path
+	}))
+	defer receiver.Close()
+
+	// No default URL, so only requests that specify their own get webhooks.
+	server := getWebhookStubServer(t, "")
+
+	resp, _ := sendRequestToServer(t, server, "POST",
+		"/v2/calls/call_123/actions/answer", `{}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = sendRequestToServer(t, server, "POST", "/v2/calls",
+		`{"connection_id": "123", "to": "+13125550001", "from": "+13125550002", "webhook_url": "`+
+			receiver.URL+`/hooks"}`,
+		getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case path := <-received:
+		assert.Equal(t, "/hooks", path)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for webhook")
+	}
+}
+
+func TestStubServer_AdminWebhooks(t *testing.T) {
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	received := make(chan delivery, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- delivery{r.Header, body}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	server := getWebhookStubServer(t, "")
+
+	resp, body := sendRequestToServer(t, server, "GET", "/_mock/webhooks/public_key", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var publicKeyData struct {
+		Data struct {
+			PublicKey string `json:"public_key"`
+		} `json:"data"`
+	}
+	err := json.Unmarshal(body, &publicKeyData)
+	assert.NoError(t, err)
+	publicKey, err := base64.StdEncoding.DecodeString(publicKeyData.Data.PublicKey)
+	assert.NoError(t, err)
+
+	send := func(body string) (int, map[string]interface{}) {
+		resp, respBody := sendRequestToServer(t, server, "POST", "/_mock/webhooks/send", body, nil)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(respBody, &data)
+		assert.NoError(t, err)
+		return resp.StatusCode, data
+	}
+
+	// The payload is delivered exactly as given, with a signature that
+	// verifies against the public key.
+	payload := `{"data": {"event_type": "message.received"}}`
+	status, data := send(`{"url": "` + receiver.URL + `", "payload": ` + payload + `}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(http.StatusNoContent), data["data"].(map[string]interface{})["status"])
+
+	webhookDelivery := <-received
+	assert.Equal(t, payload, string(webhookDelivery.body))
+	assert.NoError(t, webhook.Verify(publicKey, webhookDelivery.body,
+		webhookDelivery.header.Get(webhook.HeaderSignature),
+		webhookDelivery.header.Get(webhook.HeaderTimestamp),
+		webhook.DefaultTolerance, time.Now()))
+
+	// An old timestamp produces a webhook that's correctly signed, but stale.
+	status, _ = send(`{"url": "` + receiver.URL + `", "payload": {}, "timestamp": 1500000000}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	webhookDelivery = <-received
+	assert.Equal(t, "1500000000", webhookDelivery.header.Get(webhook.HeaderTimestamp))
+	assert.Equal(t, webhook.ErrTimestampExpired, webhook.Verify(publicKey, webhookDelivery.body,
+		webhookDelivery.header.Get(webhook.HeaderSignature),
+		webhookDelivery.header.Get(webhook.HeaderTimestamp),
+		webhook.DefaultTolerance, time.Now()))
+
+	status, _ = send(`{"url": "not a url", "payload": {}}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	status, _ = send(`{"url": "` + receiver.URL + `"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	status, _ = send(`{"url": "` + unreachable.URL + `", "payload": {}}`)
+	assert.Equal(t, http.StatusBadGateway, status)
+
+	// Without webhooks, there's nothing to send them with.
+	resp, _ = sendRequestToServer(t, getStubServer(t), "GET", "/_mock/webhooks/public_key", "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStubServer_AdminTriggersWebhooks(t *testing.T) {
+	bodies := make(chan map[string]interface{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	server := getWebhookStubServer(t, receiver.URL)
+	server.webhooks.Log = NewDeliveryLog(defaultJournalSize)
+
+	trigger := func(body string) (int, map[string]interface{}) {
+		resp, respBody := sendRequestToServer(t, server, "POST", "/_mock/webhooks/trigger", body, nil)
+
+		var data map[string]interface{}
+		err := json.Unmarshal(respBody, &data)
+		assert.NoError(t, err)
+		return resp.StatusCode, data
+	}
+
+	// Overrides are merged into the event generated from the spec.
+	status, data := trigger(`{"event_type": "message.received", "data": {"payload": {"text": "Hello"}, "unknown": 1}}`)
+	assert.Equal(t, http.StatusAccepted, status)
+	triggered := data["data"].(map[string]interface{})["event"].(map[string]interface{})
+
+	event := (<-bodies)["data"].(map[string]interface{})
+	assert.Equal(t, triggered["id"], event["id"])
+	assert.Equal(t, "message.received", event["event_type"])
+	assert.Equal(t, "event", event["record_type"])
+	assert.Nil(t, event["unknown"])
+
+	payload := event["payload"].(map[string]interface{})
+	assert.Equal(t, "Hello", payload["text"])
+	assert.NotNil(t, payload["from"])
+
+	// The attempts to deliver the event can be found by its ID.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		resp, body := sendRequestToServer(t, server, "GET",
+			"/_mock/webhooks/deliveries?event_id="+triggered["id"].(string), "", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var deliveries struct {
+			Data []DeliveryAttempt `json:"data"`
+		}
+		err := json.Unmarshal(body, &deliveries)
+		assert.NoError(t, err)
+		if len(deliveries.Data) > 0 {
+			assert.Equal(t, 1, len(deliveries.Data))
+			assert.True(t, deliveries.Data[0].Delivered)
+			break
+		}
+
+		assert.True(t, time.Now().Before(deadline), "Timed out waiting for delivery log")
+		time.Sleep(time.Millisecond)
+	}
+
+	// Events that the spec doesn't describe take their payload as given.
+	status, _ = trigger(`{"event_type": "number_order.complete", "data": {"payload": {"id": "123"}}}`)
+	assert.Equal(t, http.StatusAccepted, status)
+
+	event = (<-bodies)["data"].(map[string]interface{})
+	assert.Equal(t, "number_order.complete", event["event_type"])
+	assert.Equal(t, map[string]interface{}{"id": "123"}, event["payload"])
+
+	status, _ = trigger(`{"data": {}}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	status, _ = trigger(`{"event_type": "call.initiated", "url": "not a url"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	// Without a default URL, one has to be given.
+	server.webhooks.DefaultURL = ""
+	status, _ = trigger(`{"event_type": "call.initiated"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	status, _ = trigger(`{"event_type": "call.initiated", "url": "` + receiver.URL + `"}`)
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Equal(t, "call.initiated", (<-bodies)["data"].(map[string]interface{})["event_type"])
+}
+
+func TestStubServer_WebhookRetriesAndFailover(t *testing.T) {
+	var primaryAttempts int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryAttempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	bodies := make(chan map[string]interface{}, 10)
+	failover := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		bodies <- body
+	}))
+	defer failover.Close()
+
+	server := getWebhookStubServer(t, "")
+	server.webhooks.Log = NewDeliveryLog(defaultJournalSize)
+	server.webhooks.Retries = 2
+	server.webhooks.RetryBackoff = time.Millisecond
+
+	// Number orders trigger a webhook that carries the order, and that goes
+	// to the order's webhook URLs.
+	resp, _ := sendRequestToServer(t, server, "POST", "/v2/number_orders",
+		`{"phone_numbers": [{"phone_number": "+19705555098"}], "webhook_url": "`+primary.URL+
+			`", "webhook_failover_url": "`+failover.URL+`"}`, getDefaultHeaders())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case body := <-bodies:
+		data := body["data"].(map[string]interface{})
+		assert.Equal(t, "number_order.complete", data["event_type"])
+		assert.Equal(t, "number_order", data["payload"].(map[string]interface{})["record_type"])
+
+		meta := body["meta"].(map[string]interface{})
+		assert.Equal(t, 4.0, meta["attempt"])
+		assert.Equal(t, failover.URL, meta["delivered_to"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for webhook")
+	}
+
+	// The primary URL is tried once and retried twice before failing over.
+	assert.Equal(t, int32(3), atomic.LoadInt32(&primaryAttempts))
+
+	// The successful attempt is logged once the receiver has responded.
+	for deadline := time.Now().Add(5 * time.Second); len(server.webhooks.Log.List("", "")) < 4; {
+		assert.True(t, time.Now().Before(deadline), "Timed out waiting for delivery log")
+		time.Sleep(time.Millisecond)
+	}
+
+	resp, body := sendRequestToServer(t, server, "GET",
+		"/_mock/webhooks/deliveries?event_type=number_order.complete", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var deliveries struct {
+		Data []DeliveryAttempt `json:"data"`
+	}
+	err := json.Unmarshal(body, &deliveries)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(deliveries.Data))
+
+	for i, attempt := range deliveries.Data[:3] {
+		assert.Equal(t, i+1, attempt.Attempt)
+		assert.Equal(t, primary.URL, attempt.URL)
+		assert.Equal(t, http.StatusServiceUnavailable, attempt.Status)
+		assert.False(t, attempt.Failover)
+		assert.False(t, attempt.Delivered)
+	}
+	assert.Equal(t, failover.URL, deliveries.Data[3].URL)
+	assert.True(t, deliveries.Data[3].Failover)
+	assert.True(t, deliveries.Data[3].Delivered)
+
+	resp, _ = sendRequestToServer(t, server, "DELETE", "/_mock/webhooks/deliveries", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, len(server.webhooks.Log.List("", "")))
+}
+
+func TestWebhookEmitter_DeliverUnreachable(t *testing.T) {
+	signer, err := webhook.GenerateSigner()
+	assert.NoError(t, err)
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	emitter := &WebhookEmitter{
+		Retries:      1,
+		RetryBackoff: time.Millisecond,
+		Sender:       &webhook.Sender{Signer: signer},
+	}
+
+	done := make(chan []*DeliveryAttempt, 1)
+	emitter.Deliver(unreachable.URL, "", map[string]interface{}{
+		"event_type": "call.initiated",
+		"id":         "123",
+	}, func(attempts []*DeliveryAttempt) { done <- attempts })
+
+	var attempts []*DeliveryAttempt
+	select {
+	case attempts = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for delivery")
+	}
+	assert.Equal(t, 2, len(attempts))
+	for _, attempt := range attempts {
+		assert.Equal(t, "call.initiated", attempt.EventType)
+		assert.Equal(t, "123", attempt.EventID)
+		assert.Equal(t, 0, attempt.Status)
+		assert.NotEmpty(t, attempt.Error)
+		assert.False(t, attempt.Delivered)
+	}
+}
+
+func TestWebhookEmitter_RetriesFollowClock(t *testing.T) {
+	signer, err := webhook.GenerateSigner()
+	assert.NoError(t, err)
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	clock := NewClock()
+	clock.Freeze()
+
+	emitter := &WebhookEmitter{
+		Log:          NewDeliveryLog(defaultJournalSize),
+		Retries:      2,
+		RetryBackoff: time.Minute,
+		Sender:       &webhook.Sender{Signer: signer},
+		afterFunc:    clock.afterFunc,
+	}
+
+	done := make(chan []*DeliveryAttempt, 1)
+	emitter.Deliver(unreachable.URL, "", map[string]interface{}{"event_type": "call.initiated"},
+		func(attempts []*DeliveryAttempt) { done <- attempts })
+
+	// Retries wait for the clock, however long that takes in real time, and
+	// are made in the background once it's advanced.
+	waitForAttempts := func(n int) {
+		for deadline := time.Now().Add(5 * time.Second); len(emitter.Log.List("", "")) < n; {
+			assert.True(t, time.Now().Before(deadline), "Timed out waiting for delivery log")
+			time.Sleep(time.Millisecond)
+		}
+	}
+	assert.Equal(t, 1, len(emitter.Log.List("", "")))
+
+	clock.Advance(time.Minute)
+	waitForAttempts(2)
+	assert.Empty(t, done)
+
+	// The backoff doubles with each retry.
+	clock.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 2, len(emitter.Log.List("", "")))
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, 3, len(<-done))
+}
+
+//
+// Tests for private functions
+//
+
+func TestCollectEventSchemas(t *testing.T) {
+	eventSchemas := collectEventSchemas(realSpec.Paths)
+
+	for _, eventType := range []string{"call.answered", "call.hangup", "call.initiated",
+		"message.sent", "message.finalized"} {
+
+		schema, ok := eventSchemas[eventType]
+		assert.True(t, ok, eventType)
+		assert.Contains(t, schemaEventTypes(schema), eventType)
+	}
+}
+
+func TestEventPayloadValues(t *testing.T) {
+	// The primary ID isn't always the last parameter.
+	route := &stubServerRoute{pathParamNames: []string{"id", "status"}, primaryIDIndex: 0}
+	id := "portout_123"
+	pathParams := &PathParamsMap{
+		PrimaryID:    &id,
+		SecondaryIDs: []*PathParamsSecondaryID{{ID: "authorized", Name: "status"}},
+	}
+
+	values := eventPayloadValues(route, pathParams, map[string]interface{}{"reason": "Done"},
+		map[string]interface{}{"data": map[string]interface{}{"id": "other", "record_type": "portout"}})
+	assert.Equal(t, map[string]interface{}{
+		"id":          "portout_123",
+		"reason":      "Done",
+		"record_type": "portout",
+		"status":      "authorized",
+	}, values)
+}
+
+func TestNewUUID(t *testing.T) {
+	uuid := newUUID()
+	assert.Regexp(t,
+		`\A[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\z`, uuid)
+	assert.NotEqual(t, uuid, newUUID())
+}
+
+func TestParseExpectedWebhooks(t *testing.T) {
+	assert.Equal(t,
+		[]string{"call.hangup", "call.recording.saved"},
+		parseExpectedWebhooks("Hang up the call.\n\n**Expected Webhooks:**\n\n"+
+			"- `call.hangup`\n- `call.recording.saved`\n\nMore text.\n- `not.an.event`"))
+
+	assert.Nil(t, parseExpectedWebhooks("Retrieve a call."))
+}
+
+//
+// Private functions
+//
+
+// getWebhookStubServer gets a server using the real spec that delivers
+// webhooks to the given default URL.
+func getWebhookStubServer(t *testing.T, url string) *StubServer {
+	signer, err := webhook.GenerateSigner()
+	assert.NoError(t, err)
+
+	server := &StubServer{
+		spec:     &realSpec,
+		fixtures: &realFixtures,
+		webhooks: &WebhookEmitter{
+			DefaultURL: url,
+			Sender:     &webhook.Sender{Signer: signer},
+		},
+	}
+	err = server.initializeRouter()
+	assert.NoError(t, err)
+	return server
+}